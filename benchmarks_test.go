@@ -52,6 +52,51 @@ func BenchmarkGetRecordByID(b *testing.B) {
 	}
 }
 
+// Benchmark inserting the records at once and flushing
+func BenchmarkInsertMany(b *testing.B) {
+	collection := db.NewCollection("minibase", logger.New(os.Stdout, os.Stderr))
+	collection.SetDir(b.TempDir())
+	b.ResetTimer()
+
+	records := make([]*models.Record, 0, LIMIT)
+	for i := 0; i < LIMIT; i++ {
+		record := models.NewRecord()
+		record.AddField("age", rand.Intn(100))
+		record.AddField("name", "Mahinda")
+		records = append(records, record)
+	}
+	collection.InsertMany(records)
+	collection.FlushRecords()
+}
+
+// Benchmark getting non cached records at once
+func BenchmarkGetMany(b *testing.B) {
+	tempDir := b.TempDir()
+
+	collection := db.NewCollection("minibase", logger.New(os.Stdout, os.Stderr))
+	collection.SetDir(tempDir)
+
+	for i := 0; i < LIMIT; i++ {
+		record := models.NewRecord()
+		record.AddField("age", rand.Intn(100))
+		record.AddField("name", "Mahinda")
+		collection.InsertRecord(record)
+	}
+	collection.FlushRecords()
+
+	newcollection := db.NewCollection("minibase", logger.New(os.Stdout, os.Stderr))
+	newcollection.SetDir(tempDir)
+
+	ids := make([]int, 0, LIMIT)
+	for id := range collection.GetRecords() {
+		ids = append(ids, id)
+	}
+
+	b.ResetTimer()
+
+	newcollection.GetMany(ids)
+}
+
 // Benchmark updating non cached records
 func BenchmarkUpdateRecord(b *testing.B) {
 	tempDir := b.TempDir()
@@ -101,3 +146,31 @@ func BenchmarkDeleteRecord(b *testing.B) {
 		newcollection.DeleteRecord(record.ID)
 	}
 }
+
+// Benchmark deleting non cached records with log storage
+func BenchmarkDeleteRecordLog(b *testing.B) {
+	tempDir := b.TempDir()
+
+	collection := db.NewCollection("minibase", logger.New(os.Stdout, os.Stderr))
+	collection.SetDir(tempDir)
+	collection.SetStorageMode(db.LogStorage)
+
+	for i := 0; i < LIMIT; i++ {
+		record := models.NewRecord()
+		record.AddField("age", rand.Intn(100))
+		record.AddField("name", "Mahinda")
+		collection.InsertRecord(record)
+	}
+	collection.FlushRecords()
+	collection.Close()
+
+	newcollection := db.NewCollection("minibase", logger.New(os.Stdout, os.Stderr))
+	newcollection.SetDir(tempDir)
+	defer newcollection.Close()
+
+	b.ResetTimer()
+
+	for _, record := range collection.GetRecords() {
+		newcollection.DeleteRecord(record.ID)
+	}
+}
@@ -0,0 +1,1084 @@
+// This package is used to handle B+tree files.
+//
+// The package includes an on-disk B+tree which keeps arbitrary
+// ordered byte keys, with range scans, page caching, splits and merges.
+// Pages are written to a write-ahead log and only copied into the
+// tree file once the log is committed by a sync, so a crash leaves
+// the tree as it was at its last sync.
+package btree
+
+import (
+	"bytes"
+	"container/list"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"sort"
+	"sync"
+)
+
+const (
+	// Default size of a page in the file.
+	PAGE_SIZE = 4096
+	// Default number of pages kept in the cache.
+	CACHE_SIZE = 256
+	// Maximum size of a key.
+	MAX_KEY_SIZE = 256
+)
+
+const (
+	magic       = "MBPT"
+	metaSize    = 30
+	nodeHeader  = 7
+	leafPage    = 1
+	branchPage  = 2
+	overflowHdr = 9
+	overflowPg  = 3
+	freePage    = 4
+)
+
+const (
+	// Suffix of the write-ahead log file next to the tree file.
+	walSuffix = ".wal"
+	// kind (1) + page id (4) + page length (4) + crc32 (4)
+	walHeader = 13
+	walPage   = 1
+	walCommit = 2
+	// Pages are never larger, a bigger length is a torn record.
+	maxPageSize = 1 << 24
+)
+
+// Options represents the settings of a tree.
+type Options struct {
+	// Size of a page, only used when the file is created.
+	PageSize int
+	// Number of pages kept in the cache.
+	CacheSize int
+}
+
+// Stats represents the state of a tree and its cache.
+type Stats struct {
+	Keys        int
+	Pages       int
+	FreePages   int
+	CacheHits   int
+	CacheMisses int
+}
+
+// Tree represents a B+tree saved in a file.
+// Keys are ordered by bytes.Compare. Values larger than
+// an eighth of a page are saved in overflow pages.
+type Tree struct {
+	mu        sync.Mutex
+	file      *os.File
+	pageSize  int
+	root      uint32
+	freeHead  uint32
+	pageCount uint32
+	count     uint64
+	capacity  int
+	cache     map[uint32]*list.Element
+	lru       *list.List
+	dirty     map[uint32]*node
+	metaDirty bool
+	stats     Stats
+	// Write-ahead log of the pages written since the last sync,
+	// with the offsets of the latest records of the pages.
+	wal      *os.File
+	walSize  int64
+	walPages map[uint32]int64
+}
+
+// value represents a value of a leaf, saved inline
+// or in a chain of overflow pages.
+type value struct {
+	inline   []byte
+	overflow uint32
+	length   uint32
+}
+
+// node represents a decoded leaf or branch page.
+// A branch with n keys has n+1 children, the child at i
+// holds the keys between keys[i-1] and keys[i].
+type node struct {
+	id       uint32
+	leaf     bool
+	keys     [][]byte
+	values   []value
+	children []uint32
+	next     uint32
+}
+
+// This function opens the tree file or creates it.
+func Open(path string, opts *Options) (*Tree, error) {
+	if opts == nil {
+		opts = &Options{}
+	}
+	pageSize, capacity := opts.PageSize, opts.CacheSize
+	if pageSize <= 0 {
+		pageSize = PAGE_SIZE
+	}
+	if pageSize < 4*(MAX_KEY_SIZE+64) {
+		return nil, fmt.Errorf("page size %d is too small", pageSize)
+	}
+	if capacity <= 0 {
+		capacity = CACHE_SIZE
+	}
+
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("error opening file: %v", err)
+	}
+	wal, err := os.OpenFile(path+walSuffix, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("error opening file: %v", err)
+	}
+	t := &Tree{
+		file:     file,
+		pageSize: pageSize,
+		capacity: capacity,
+		cache:    make(map[uint32]*list.Element),
+		lru:      list.New(),
+		dirty:    make(map[uint32]*node),
+		wal:      wal,
+		walPages: make(map[uint32]int64),
+	}
+	if err := t.recover(); err != nil {
+		t.closeFiles()
+		return nil, err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		t.closeFiles()
+		return nil, err
+	}
+	if info.Size() == 0 {
+		t.pageCount = 2
+		t.root = 1
+		t.markDirty(&node{id: 1, leaf: true})
+		t.metaDirty = true
+		if err := t.sync(); err != nil {
+			t.closeFiles()
+			return nil, err
+		}
+		return t, nil
+	}
+
+	if err := t.readMeta(); err != nil {
+		t.closeFiles()
+		return nil, err
+	}
+	return t, nil
+}
+
+// This function copies the pages of the batches committed to the
+// write-ahead log into the file and empties the log. The pages
+// written after the last commit are dropped, so the file is left
+// as it was at the last sync.
+func (t *Tree) recover() error {
+	committed := make(map[uint32]int64)
+	batch := make(map[uint32]int64)
+	header := make([]byte, walHeader)
+	for offset := int64(0); ; {
+		if _, err := t.wal.ReadAt(header, offset); err != nil {
+			break
+		}
+		length := binary.LittleEndian.Uint32(header[5:9])
+		if length > maxPageSize {
+			break
+		}
+		page := make([]byte, length)
+		if _, err := t.wal.ReadAt(page, offset+walHeader); err != nil {
+			break
+		}
+		if walChecksum(header[:9], page) != binary.LittleEndian.Uint32(header[9:13]) {
+			break
+		}
+		switch header[0] {
+		case walPage:
+			batch[binary.LittleEndian.Uint32(header[1:5])] = offset
+		case walCommit:
+			for id, at := range batch {
+				committed[id] = at
+			}
+			batch = make(map[uint32]int64)
+		}
+		offset += walHeader + int64(length)
+	}
+
+	if err := t.checkpoint(committed); err != nil {
+		return err
+	}
+	if err := t.wal.Truncate(0); err != nil {
+		return fmt.Errorf("error writing file: %v", err)
+	}
+	return nil
+}
+
+// This function copies the pages at the offsets of the
+// write-ahead log into the file and syncs it.
+func (t *Tree) checkpoint(pages map[uint32]int64) error {
+	if len(pages) == 0 {
+		return nil
+	}
+	ids := make([]uint32, 0, len(pages))
+	for id := range pages {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	header := make([]byte, walHeader)
+	for _, id := range ids {
+		if _, err := t.wal.ReadAt(header, pages[id]); err != nil {
+			return fmt.Errorf("error reading file: %v", err)
+		}
+		page := make([]byte, binary.LittleEndian.Uint32(header[5:9]))
+		if _, err := t.wal.ReadAt(page, pages[id]+walHeader); err != nil {
+			return fmt.Errorf("error reading file: %v", err)
+		}
+		if _, err := t.file.WriteAt(page, int64(id)*int64(len(page))); err != nil {
+			return fmt.Errorf("error writing file: %v", err)
+		}
+	}
+	return t.file.Sync()
+}
+
+// This function reads the meta page of the file.
+func (t *Tree) readMeta() error {
+	meta := make([]byte, metaSize)
+	if _, err := t.file.ReadAt(meta, 0); err != nil {
+		return fmt.Errorf("error reading file: %v", err)
+	}
+	if string(meta[0:4]) != magic {
+		return fmt.Errorf("error decoding data: not a B+tree file")
+	}
+	t.pageSize = int(binary.LittleEndian.Uint32(meta[6:10]))
+	t.root = binary.LittleEndian.Uint32(meta[10:14])
+	t.freeHead = binary.LittleEndian.Uint32(meta[14:18])
+	t.pageCount = binary.LittleEndian.Uint32(meta[18:22])
+	t.count = binary.LittleEndian.Uint64(meta[22:30])
+	return nil
+}
+
+// This function writes the meta page of the file.
+func (t *Tree) writeMeta() error {
+	meta := make([]byte, t.pageSize)
+	copy(meta[0:4], magic)
+	binary.LittleEndian.PutUint16(meta[4:6], 1)
+	binary.LittleEndian.PutUint32(meta[6:10], uint32(t.pageSize))
+	binary.LittleEndian.PutUint32(meta[10:14], t.root)
+	binary.LittleEndian.PutUint32(meta[14:18], t.freeHead)
+	binary.LittleEndian.PutUint32(meta[18:22], t.pageCount)
+	binary.LittleEndian.PutUint64(meta[22:30], t.count)
+	if err := t.writePage(0, meta); err != nil {
+		return err
+	}
+	t.metaDirty = false
+	return nil
+}
+
+// This function gets the value of the key.
+func (t *Tree) Get(key []byte) (data []byte, found bool, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	defer t.trimAfter(&err)
+
+	n, err := t.findLeaf(key)
+	if err != nil {
+		return nil, false, err
+	}
+	i, found := n.search(key)
+	if !found {
+		return nil, false, nil
+	}
+	if data, err = t.readValue(n.values[i]); err != nil {
+		return nil, false, err
+	}
+	return data, true, nil
+}
+
+// This function inserts the key or replaces its value.
+func (t *Tree) Put(key, data []byte) (err error) {
+	if len(key) == 0 || len(key) > MAX_KEY_SIZE {
+		return fmt.Errorf("key size must be between 1 and %d bytes", MAX_KEY_SIZE)
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	defer t.trimAfter(&err)
+
+	v, err := t.writeValue(data)
+	if err != nil {
+		return err
+	}
+	root, err := t.load(t.root)
+	if err != nil {
+		return err
+	}
+	separator, right, err := t.insert(root, key, v)
+	if err != nil {
+		return err
+	}
+	if right != 0 {
+		id, err := t.allocate()
+		if err != nil {
+			return err
+		}
+		t.markDirty(&node{id: id, keys: [][]byte{separator}, children: []uint32{root.id, right}})
+		t.root = id
+		t.metaDirty = true
+	}
+	return nil
+}
+
+// This function inserts the key into the subtree of the node.
+// Returns the separator and the page of the new right
+// sibling when the node was split.
+func (t *Tree) insert(n *node, key []byte, v value) ([]byte, uint32, error) {
+	if n.leaf {
+		i, found := n.search(key)
+		if found {
+			if err := t.freeValue(n.values[i]); err != nil {
+				return nil, 0, err
+			}
+			n.values[i] = v
+		} else {
+			n.keys = insertAt(n.keys, i, append([]byte(nil), key...))
+			n.values = insertAt(n.values, i, v)
+			t.count++
+			t.metaDirty = true
+		}
+		t.markDirty(n)
+	} else {
+		i := n.childIndex(key)
+		child, err := t.load(n.children[i])
+		if err != nil {
+			return nil, 0, err
+		}
+		separator, right, err := t.insert(child, key, v)
+		if err != nil {
+			return nil, 0, err
+		}
+		if right == 0 {
+			return nil, 0, nil
+		}
+		n.keys = insertAt(n.keys, i, separator)
+		n.children = insertAt(n.children, i+1, right)
+		t.markDirty(n)
+	}
+
+	if n.size() <= t.pageSize {
+		return nil, 0, nil
+	}
+	id, err := t.allocate()
+	if err != nil {
+		return nil, 0, err
+	}
+	right := &node{id: id, leaf: n.leaf}
+	separator := t.split(n, right)
+	t.markDirty(n)
+	t.markDirty(right)
+	return separator, id, nil
+}
+
+// This function moves the upper half of the node by size to
+// the right node. Returns the separator between them.
+func (t *Tree) split(n, right *node) []byte {
+	if n.leaf {
+		at := splitPoint(len(n.keys), n.entrySize)
+		right.keys = append([][]byte(nil), n.keys[at:]...)
+		right.values = append([]value(nil), n.values[at:]...)
+		right.next = n.next
+		n.keys, n.values, n.next = n.keys[:at], n.values[:at], right.id
+		return right.keys[0]
+	}
+
+	// The separator moves up, both halves keep a key.
+	at := splitPoint(len(n.keys), n.entrySize)
+	if at > len(n.keys)-2 {
+		at = len(n.keys) - 2
+	}
+	separator := n.keys[at]
+	right.keys = append([][]byte(nil), n.keys[at+1:]...)
+	right.children = append([]uint32(nil), n.children[at+1:]...)
+	n.keys, n.children = n.keys[:at], n.children[:at+1]
+	return separator
+}
+
+// This function deletes the key and reports whether it existed.
+func (t *Tree) Delete(key []byte) (found bool, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	defer t.trimAfter(&err)
+
+	root, err := t.load(t.root)
+	if err != nil {
+		return false, err
+	}
+	found, err = t.remove(root, key)
+	if err != nil || !found {
+		return found, err
+	}
+
+	if !root.leaf && len(root.keys) == 0 {
+		t.root = root.children[0]
+		t.metaDirty = true
+		if err := t.free(root.id); err != nil {
+			return true, err
+		}
+	}
+	return true, nil
+}
+
+// This function deletes the key from the subtree of the node
+// and rebalances the children which became underfull.
+func (t *Tree) remove(n *node, key []byte) (bool, error) {
+	if n.leaf {
+		i, found := n.search(key)
+		if !found {
+			return false, nil
+		}
+		if err := t.freeValue(n.values[i]); err != nil {
+			return false, err
+		}
+		n.keys = removeAt(n.keys, i)
+		n.values = removeAt(n.values, i)
+		t.count--
+		t.metaDirty = true
+		t.markDirty(n)
+		return true, nil
+	}
+
+	i := n.childIndex(key)
+	child, err := t.load(n.children[i])
+	if err != nil {
+		return false, err
+	}
+	found, err := t.remove(child, key)
+	if err != nil || !found {
+		return found, err
+	}
+	if child.size() < t.pageSize/4 {
+		if err := t.rebalance(n, i); err != nil {
+			return true, err
+		}
+	}
+	return true, nil
+}
+
+// This function merges the child at i with a sibling, or moves
+// entries between them when they don't fit in a single page.
+func (t *Tree) rebalance(parent *node, i int) error {
+	if len(parent.children) < 2 {
+		return nil
+	}
+	if i == len(parent.children)-1 {
+		i--
+	}
+	left, err := t.load(parent.children[i])
+	if err != nil {
+		return err
+	}
+	right, err := t.load(parent.children[i+1])
+	if err != nil {
+		return err
+	}
+	separator := parent.keys[i]
+
+	merged := &node{id: left.id, leaf: left.leaf}
+	if left.leaf {
+		merged.keys = append(append([][]byte(nil), left.keys...), right.keys...)
+		merged.values = append(append([]value(nil), left.values...), right.values...)
+		merged.next = right.next
+	} else {
+		merged.keys = append(append(append([][]byte(nil), left.keys...), separator), right.keys...)
+		merged.children = append(append([]uint32(nil), left.children...), right.children...)
+	}
+
+	if merged.size() <= t.pageSize {
+		*left = *merged
+		t.markDirty(left)
+		parent.keys = removeAt(parent.keys, i)
+		parent.children = removeAt(parent.children, i+1)
+		t.markDirty(parent)
+		return t.free(right.id)
+	}
+
+	*left = *merged
+	parent.keys[i] = t.split(left, right)
+	t.markDirty(left)
+	t.markDirty(right)
+	t.markDirty(parent)
+	return nil
+}
+
+// This function calls fn with every key between from and to in
+// order, from is inclusive and to is exclusive. A nil bound is
+// unlimited. The scan stops when fn returns false.
+// fn must not use the tree.
+func (t *Tree) Scan(from, to []byte, fn func(key, value []byte) bool) (err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	defer t.trimAfter(&err)
+
+	n, err := t.findLeaf(from)
+	if err != nil {
+		return err
+	}
+	i := 0
+	if from != nil {
+		i, _ = n.search(from)
+	}
+	for {
+		for ; i < len(n.keys); i++ {
+			if to != nil && bytes.Compare(n.keys[i], to) >= 0 {
+				return nil
+			}
+			data, err := t.readValue(n.values[i])
+			if err != nil {
+				return err
+			}
+			if !fn(n.keys[i], data) {
+				return nil
+			}
+		}
+		if n.next == 0 {
+			return nil
+		}
+		// The visited leaves are only read, they can
+		// leave the cache during a long scan.
+		if err := t.trim(); err != nil {
+			return err
+		}
+		if n, err = t.load(n.next); err != nil {
+			return err
+		}
+		i = 0
+	}
+}
+
+// This function gets the greatest key and its value.
+func (t *Tree) Last() (key, data []byte, found bool, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	defer t.trimAfter(&err)
+
+	n, err := t.load(t.root)
+	if err != nil {
+		return nil, nil, false, err
+	}
+	for !n.leaf {
+		if n, err = t.load(n.children[len(n.children)-1]); err != nil {
+			return nil, nil, false, err
+		}
+	}
+	if len(n.keys) == 0 {
+		return nil, nil, false, nil
+	}
+	if data, err = t.readValue(n.values[len(n.values)-1]); err != nil {
+		return nil, nil, false, err
+	}
+	return n.keys[len(n.keys)-1], data, true, nil
+}
+
+// This function gets the number of keys in the tree.
+func (t *Tree) Len() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return int(t.count)
+}
+
+// This function gets the statistics of the tree.
+func (t *Tree) Stats() (Stats, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	stats := t.stats
+	stats.Keys = int(t.count)
+	stats.Pages = int(t.pageCount)
+	for id := t.freeHead; id != 0; stats.FreePages++ {
+		next, err := t.readLink(id)
+		if err != nil {
+			return stats, err
+		}
+		id = next
+	}
+	return stats, nil
+}
+
+// This function writes the modified pages to the file. They are
+// committed to the write-ahead log before the file is changed.
+func (t *Tree) Sync() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.sync()
+}
+
+func (t *Tree) sync() error {
+	committed, err := t.commit()
+	if err != nil || !committed {
+		return err
+	}
+	if err := t.checkpoint(t.walPages); err != nil {
+		return err
+	}
+	if err := t.wal.Truncate(0); err != nil {
+		return fmt.Errorf("error writing file: %v", err)
+	}
+	t.walSize = 0
+	t.walPages = make(map[uint32]int64)
+	return nil
+}
+
+// This function writes the modified pages to the write-ahead log
+// and commits the pages written since the last sync. Reports false
+// when nothing was written.
+func (t *Tree) commit() (bool, error) {
+	ids := make([]uint32, 0, len(t.dirty))
+	for id := range t.dirty {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	for _, id := range ids {
+		if err := t.writeNode(t.dirty[id]); err != nil {
+			return false, err
+		}
+		delete(t.dirty, id)
+	}
+	if t.metaDirty {
+		if err := t.writeMeta(); err != nil {
+			return false, err
+		}
+	}
+	if t.walSize == 0 {
+		return false, nil
+	}
+
+	record := make([]byte, walHeader)
+	record[0] = walCommit
+	binary.LittleEndian.PutUint32(record[9:13], walChecksum(record[:9], nil))
+	if _, err := t.wal.WriteAt(record, t.walSize); err != nil {
+		return false, fmt.Errorf("error writing file: %v", err)
+	}
+	t.walSize += walHeader
+	return true, t.wal.Sync()
+}
+
+// This function writes the modified pages and closes the file.
+// The emptied write-ahead log is removed.
+func (t *Tree) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if err := t.sync(); err != nil {
+		t.closeFiles()
+		return err
+	}
+	if err := t.closeFiles(); err != nil {
+		return err
+	}
+	return os.Remove(t.wal.Name())
+}
+
+// This function closes the tree file and its write-ahead log.
+func (t *Tree) closeFiles() error {
+	err := t.file.Close()
+	if walErr := t.wal.Close(); err == nil {
+		err = walErr
+	}
+	return err
+}
+
+// This function finds the leaf which may hold the key.
+// A nil key finds the leftmost leaf.
+func (t *Tree) findLeaf(key []byte) (*node, error) {
+	n, err := t.load(t.root)
+	if err != nil {
+		return nil, err
+	}
+	for !n.leaf {
+		i := 0
+		if key != nil {
+			i = n.childIndex(key)
+		}
+		if n, err = t.load(n.children[i]); err != nil {
+			return nil, err
+		}
+	}
+	return n, nil
+}
+
+// This function gets the node of the page from the cache
+// or reads it from the file.
+func (t *Tree) load(id uint32) (*node, error) {
+	if element, ok := t.cache[id]; ok {
+		t.stats.CacheHits++
+		t.lru.MoveToFront(element)
+		return element.Value.(*node), nil
+	}
+	t.stats.CacheMisses++
+
+	page := make([]byte, t.pageSize)
+	if err := t.readPage(id, page); err != nil {
+		return nil, err
+	}
+	n, err := decodeNode(id, page)
+	if err != nil {
+		return nil, err
+	}
+	t.cache[id] = t.lru.PushFront(n)
+	return n, nil
+}
+
+// This function marks the node as modified and keeps it in
+// the cache until it is written.
+func (t *Tree) markDirty(n *node) {
+	t.dirty[n.id] = n
+	if element, ok := t.cache[n.id]; ok {
+		element.Value = n
+		t.lru.MoveToFront(element)
+		return
+	}
+	t.cache[n.id] = t.lru.PushFront(n)
+}
+
+// This function evicts the least recently used pages over the
+// capacity of the cache, modified pages are written first. A page
+// which can't be written stays in the cache.
+// Pages are only evicted between operations so a node is
+// never modified after it left the cache.
+func (t *Tree) trim() error {
+	for t.lru.Len() > t.capacity {
+		element := t.lru.Back()
+		n := element.Value.(*node)
+		if dirty, ok := t.dirty[n.id]; ok {
+			if err := t.writeNode(dirty); err != nil {
+				return err
+			}
+			delete(t.dirty, n.id)
+		}
+		t.lru.Remove(element)
+		delete(t.cache, n.id)
+	}
+	return nil
+}
+
+// This function trims the cache at the end of an operation and
+// sets the error of the trim unless the operation failed.
+func (t *Tree) trimAfter(err *error) {
+	if trimErr := t.trim(); *err == nil {
+		*err = trimErr
+	}
+}
+
+// This function appends the page to the write-ahead log. It is
+// copied into the file once the log is committed by sync.
+func (t *Tree) writePage(id uint32, page []byte) error {
+	record := make([]byte, walHeader+len(page))
+	record[0] = walPage
+	binary.LittleEndian.PutUint32(record[1:5], id)
+	binary.LittleEndian.PutUint32(record[5:9], uint32(len(page)))
+	binary.LittleEndian.PutUint32(record[9:13], walChecksum(record[:9], page))
+	copy(record[walHeader:], page)
+	if _, err := t.wal.WriteAt(record, t.walSize); err != nil {
+		return fmt.Errorf("error writing file: %v", err)
+	}
+	t.walPages[id] = t.walSize
+	t.walSize += int64(len(record))
+	return nil
+}
+
+// This function reads the start of the page into the buffer, from
+// the write-ahead log when the page was written since the last
+// sync.
+func (t *Tree) readPage(id uint32, buf []byte) error {
+	var err error
+	if offset, ok := t.walPages[id]; ok {
+		_, err = t.wal.ReadAt(buf, offset+walHeader)
+	} else {
+		_, err = t.file.ReadAt(buf, int64(id)*int64(t.pageSize))
+	}
+	if err != nil {
+		return fmt.Errorf("error reading file: page %d: %v", id, err)
+	}
+	return nil
+}
+
+// This function gets the checksum of a record of the
+// write-ahead log.
+func walChecksum(header, page []byte) uint32 {
+	return crc32.Update(crc32.ChecksumIEEE(header), crc32.IEEETable, page)
+}
+
+// This function encodes the node into its page.
+func (t *Tree) writeNode(n *node) error {
+	page := make([]byte, t.pageSize)
+	if n.leaf {
+		page[0] = leafPage
+	} else {
+		page[0] = branchPage
+	}
+	binary.LittleEndian.PutUint16(page[1:3], uint16(len(n.keys)))
+	if n.leaf {
+		binary.LittleEndian.PutUint32(page[3:7], n.next)
+	} else {
+		binary.LittleEndian.PutUint32(page[3:7], n.children[0])
+	}
+
+	offset := nodeHeader
+	for i, key := range n.keys {
+		binary.LittleEndian.PutUint16(page[offset:], uint16(len(key)))
+		offset += 2
+		offset += copy(page[offset:], key)
+		if !n.leaf {
+			binary.LittleEndian.PutUint32(page[offset:], n.children[i+1])
+			offset += 4
+			continue
+		}
+		v := n.values[i]
+		binary.LittleEndian.PutUint32(page[offset:], v.length)
+		if v.overflow != 0 {
+			page[offset+4] = 1
+			binary.LittleEndian.PutUint32(page[offset+5:], v.overflow)
+			offset += 9
+		} else {
+			offset += 5
+			offset += copy(page[offset:], v.inline)
+		}
+	}
+
+	return t.writePage(n.id, page)
+}
+
+// This function decodes a leaf or branch page.
+func decodeNode(id uint32, page []byte) (n *node, err error) {
+	defer func() {
+		if recover() != nil {
+			n, err = nil, fmt.Errorf("error decoding data: page %d is corrupt", id)
+		}
+	}()
+
+	n = &node{id: id}
+	switch page[0] {
+	case leafPage:
+		n.leaf = true
+		n.next = binary.LittleEndian.Uint32(page[3:7])
+	case branchPage:
+		n.children = []uint32{binary.LittleEndian.Uint32(page[3:7])}
+	default:
+		return nil, fmt.Errorf("error decoding data: page %d is not a node", id)
+	}
+
+	count := int(binary.LittleEndian.Uint16(page[1:3]))
+	offset := nodeHeader
+	for i := 0; i < count; i++ {
+		length := int(binary.LittleEndian.Uint16(page[offset:]))
+		offset += 2
+		n.keys = append(n.keys, append([]byte(nil), page[offset:offset+length]...))
+		offset += length
+		if !n.leaf {
+			n.children = append(n.children, binary.LittleEndian.Uint32(page[offset:]))
+			offset += 4
+			continue
+		}
+		v := value{length: binary.LittleEndian.Uint32(page[offset:])}
+		if page[offset+4] == 1 {
+			v.overflow = binary.LittleEndian.Uint32(page[offset+5:])
+			offset += 9
+		} else {
+			offset += 5
+			v.inline = append([]byte(nil), page[offset:offset+int(v.length)]...)
+			offset += int(v.length)
+		}
+		n.values = append(n.values, v)
+	}
+	return n, nil
+}
+
+// This function saves the value inline when it is small
+// or in a chain of overflow pages.
+func (t *Tree) writeValue(data []byte) (value, error) {
+	v := value{length: uint32(len(data))}
+	if len(data) <= t.pageSize/8 {
+		v.inline = append([]byte(nil), data...)
+		return v, nil
+	}
+
+	capacity := t.pageSize - overflowHdr
+	pages := make([]uint32, (len(data)+capacity-1)/capacity)
+	for i := range pages {
+		id, err := t.allocate()
+		if err != nil {
+			return v, err
+		}
+		pages[i] = id
+	}
+	for i, id := range pages {
+		page := make([]byte, t.pageSize)
+		page[0] = overflowPg
+		if i+1 < len(pages) {
+			binary.LittleEndian.PutUint32(page[1:5], pages[i+1])
+		}
+		end := (i + 1) * capacity
+		if end > len(data) {
+			end = len(data)
+		}
+		binary.LittleEndian.PutUint32(page[5:9], uint32(end-i*capacity))
+		copy(page[overflowHdr:], data[i*capacity:end])
+		if err := t.writePage(id, page); err != nil {
+			return v, err
+		}
+	}
+	v.overflow = pages[0]
+	return v, nil
+}
+
+// This function reads a value saved inline or in overflow pages.
+func (t *Tree) readValue(v value) ([]byte, error) {
+	if v.overflow == 0 {
+		return append([]byte(nil), v.inline...), nil
+	}
+	data := make([]byte, 0, v.length)
+	page := make([]byte, t.pageSize)
+	for id := v.overflow; id != 0; {
+		if err := t.readPage(id, page); err != nil {
+			return nil, err
+		}
+		if page[0] != overflowPg {
+			return nil, fmt.Errorf("error decoding data: page %d is not an overflow page", id)
+		}
+		length := int(binary.LittleEndian.Uint32(page[5:9]))
+		if length > t.pageSize-overflowHdr {
+			return nil, fmt.Errorf("error decoding data: page %d is corrupt", id)
+		}
+		data = append(data, page[overflowHdr:overflowHdr+length]...)
+		id = binary.LittleEndian.Uint32(page[1:5])
+	}
+	if len(data) != int(v.length) {
+		return nil, fmt.Errorf("error decoding data: value length mismatch")
+	}
+	return data, nil
+}
+
+// This function frees the overflow pages of a value.
+func (t *Tree) freeValue(v value) error {
+	for id := v.overflow; id != 0; {
+		next, err := t.readLink(id)
+		if err != nil {
+			return err
+		}
+		if err := t.free(id); err != nil {
+			return err
+		}
+		id = next
+	}
+	return nil
+}
+
+// This function reads the link to the next page of an
+// overflow or free page.
+func (t *Tree) readLink(id uint32) (uint32, error) {
+	link := make([]byte, 5)
+	if err := t.readPage(id, link); err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint32(link[1:5]), nil
+}
+
+// This function gets a page from the free list or
+// grows the file by a page.
+func (t *Tree) allocate() (uint32, error) {
+	t.metaDirty = true
+	if t.freeHead == 0 {
+		id := t.pageCount
+		t.pageCount++
+		return id, nil
+	}
+	id := t.freeHead
+	next, err := t.readLink(id)
+	if err != nil {
+		return 0, err
+	}
+	t.freeHead = next
+	return id, nil
+}
+
+// This function adds the page to the free list.
+func (t *Tree) free(id uint32) error {
+	if element, ok := t.cache[id]; ok {
+		t.lru.Remove(element)
+		delete(t.cache, id)
+	}
+	delete(t.dirty, id)
+
+	page := make([]byte, t.pageSize)
+	page[0] = freePage
+	binary.LittleEndian.PutUint32(page[1:5], t.freeHead)
+	if err := t.writePage(id, page); err != nil {
+		return err
+	}
+	t.freeHead = id
+	t.metaDirty = true
+	return nil
+}
+
+// This function finds the position of the key in a leaf.
+func (n *node) search(key []byte) (int, bool) {
+	i := sort.Search(len(n.keys), func(i int) bool { return bytes.Compare(n.keys[i], key) >= 0 })
+	return i, i < len(n.keys) && bytes.Equal(n.keys[i], key)
+}
+
+// This function finds the child of a branch which may hold the key.
+func (n *node) childIndex(key []byte) int {
+	return sort.Search(len(n.keys), func(i int) bool { return bytes.Compare(n.keys[i], key) > 0 })
+}
+
+// This function gets the encoded size of the entry at i.
+func (n *node) entrySize(i int) int {
+	if !n.leaf {
+		return 2 + len(n.keys[i]) + 4
+	}
+	if n.values[i].overflow != 0 {
+		return 2 + len(n.keys[i]) + 9
+	}
+	return 2 + len(n.keys[i]) + 5 + len(n.values[i].inline)
+}
+
+// This function gets the encoded size of the node.
+func (n *node) size() int {
+	size := nodeHeader
+	for i := range n.keys {
+		size += n.entrySize(i)
+	}
+	return size
+}
+
+// This function finds the index splitting the entries into
+// two halves of about the same size, both holding an entry.
+func splitPoint(count int, size func(int) int) int {
+	total := 0
+	for i := 0; i < count; i++ {
+		total += size(i)
+	}
+	half := 0
+	for i := 0; i < count-1; i++ {
+		half += size(i)
+		if half >= total/2 {
+			return i + 1
+		}
+	}
+	return count - 1
+}
+
+func insertAt[T any](items []T, i int, item T) []T {
+	items = append(items, item)
+	copy(items[i+1:], items[i:])
+	items[i] = item
+	return items
+}
+
+func removeAt[T any](items []T, i int) []T {
+	return append(items[:i], items[i+1:]...)
+}
@@ -0,0 +1,248 @@
+package btree
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+func key(i int) []byte {
+	return []byte(fmt.Sprintf("key-%06d", i))
+}
+
+func TestTree_PutGet(t *testing.T) {
+	tree, err := Open(filepath.Join(t.TempDir(), "tree.db"), &Options{PageSize: 1280, CacheSize: 4})
+	if err != nil {
+		t.Fatalf("Open() failed: %v", err)
+	}
+	defer tree.Close()
+
+	for _, i := range rand.Perm(2000) {
+		if err := tree.Put(key(i), []byte(fmt.Sprint(i))); err != nil {
+			t.Fatalf("Put() failed: %v", err)
+		}
+	}
+	if tree.Len() != 2000 {
+		t.Errorf("Put() failed: Expected 2000 keys, got %d", tree.Len())
+	}
+	for i := 0; i < 2000; i++ {
+		value, ok, err := tree.Get(key(i))
+		if err != nil || !ok || string(value) != fmt.Sprint(i) {
+			t.Fatalf("Get() failed: Unexpected value '%s' for key %d: %v", value, i, err)
+		}
+	}
+	if _, ok, _ := tree.Get([]byte("missing")); ok {
+		t.Errorf("Get() failed: Missing key was found")
+	}
+}
+
+func TestTree_Scan(t *testing.T) {
+	tree, err := Open(filepath.Join(t.TempDir(), "tree.db"), &Options{PageSize: 1280})
+	if err != nil {
+		t.Fatalf("Open() failed: %v", err)
+	}
+	defer tree.Close()
+
+	for _, i := range rand.Perm(500) {
+		tree.Put(key(i), []byte("value"))
+	}
+
+	var keys [][]byte
+	err = tree.Scan(key(100), key(200), func(k, v []byte) bool {
+		keys = append(keys, k)
+		return true
+	})
+	if err != nil {
+		t.Fatalf("Scan() failed: %v", err)
+	}
+	if len(keys) != 100 || !bytes.Equal(keys[0], key(100)) || !bytes.Equal(keys[99], key(199)) {
+		t.Fatalf("Scan() failed: Unexpected range of %d keys", len(keys))
+	}
+	if !sort.SliceIsSorted(keys, func(i, j int) bool { return bytes.Compare(keys[i], keys[j]) < 0 }) {
+		t.Errorf("Scan() failed: Keys are not in order")
+	}
+
+	last, _, ok, err := tree.Last()
+	if err != nil || !ok || !bytes.Equal(last, key(499)) {
+		t.Errorf("Last() failed: Expected '%s', got '%s'", key(499), last)
+	}
+}
+
+func TestTree_Delete(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tree.db")
+	tree, err := Open(path, &Options{PageSize: 1280, CacheSize: 8})
+	if err != nil {
+		t.Fatalf("Open() failed: %v", err)
+	}
+
+	expected := make(map[int]bool)
+	for _, i := range rand.Perm(3000) {
+		tree.Put(key(i), bytes.Repeat([]byte{byte(i)}, i%300))
+		expected[i] = true
+	}
+	for _, i := range rand.Perm(3000)[:2500] {
+		found, err := tree.Delete(key(i))
+		if err != nil || !found {
+			t.Fatalf("Delete() failed: Key %d: %v", i, err)
+		}
+		delete(expected, i)
+	}
+	if found, _ := tree.Delete([]byte("missing")); found {
+		t.Errorf("Delete() failed: Missing key was deleted")
+	}
+	if err := tree.Close(); err != nil {
+		t.Fatalf("Close() failed: %v", err)
+	}
+
+	tree, err = Open(path, nil)
+	if err != nil {
+		t.Fatalf("Open() failed: %v", err)
+	}
+	defer tree.Close()
+
+	if tree.Len() != len(expected) {
+		t.Errorf("Delete() failed: Expected %d keys, got %d", len(expected), tree.Len())
+	}
+	count := 0
+	tree.Scan(nil, nil, func(k, v []byte) bool {
+		var i int
+		fmt.Sscanf(string(k), "key-%d", &i)
+		if !expected[i] || !bytes.Equal(v, bytes.Repeat([]byte{byte(i)}, i%300)) {
+			t.Errorf("Delete() failed: Unexpected key %d after reopening", i)
+		}
+		count++
+		return true
+	})
+	if count != len(expected) {
+		t.Errorf("Delete() failed: Expected %d keys in the scan, got %d", len(expected), count)
+	}
+
+	stats, err := tree.Stats()
+	if err != nil {
+		t.Fatalf("Stats() failed: %v", err)
+	}
+	if stats.FreePages == 0 {
+		t.Errorf("Delete() failed: Expected merged pages to be freed")
+	}
+}
+
+func TestTree_Overflow(t *testing.T) {
+	tree, err := Open(filepath.Join(t.TempDir(), "tree.db"), nil)
+	if err != nil {
+		t.Fatalf("Open() failed: %v", err)
+	}
+	defer tree.Close()
+
+	large := bytes.Repeat([]byte("minibase"), 3000)
+	if err := tree.Put([]byte("large"), large); err != nil {
+		t.Fatalf("Put() failed: %v", err)
+	}
+	value, ok, err := tree.Get([]byte("large"))
+	if err != nil || !ok || !bytes.Equal(value, large) {
+		t.Fatalf("Get() failed: Overflow value does not match: %v", err)
+	}
+
+	before, _ := tree.Stats()
+	if err := tree.Put([]byte("large"), []byte("small")); err != nil {
+		t.Fatalf("Put() failed: %v", err)
+	}
+	after, _ := tree.Stats()
+	if after.FreePages <= before.FreePages {
+		t.Errorf("Put() failed: Overflow pages of the replaced value were not freed")
+	}
+}
+
+func TestTree_Recover(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tree.db")
+	opts := &Options{PageSize: 1280, CacheSize: 4}
+	tree, err := Open(path, opts)
+	if err != nil {
+		t.Fatalf("Open() failed: %v", err)
+	}
+	for i := 0; i < 1000; i++ {
+		if err := tree.Put(key(i), bytes.Repeat([]byte{'v'}, i%300)); err != nil {
+			t.Fatalf("Put() failed: %v", err)
+		}
+	}
+	if err := tree.Sync(); err != nil {
+		t.Fatalf("Sync() failed: %v", err)
+	}
+
+	// The changes after the sync are lost with a crash, the
+	// evicted pages never reached the file.
+	for i := 1000; i < 2000; i++ {
+		tree.Put(key(i), []byte("lost"))
+	}
+	for i := 0; i < 500; i++ {
+		tree.Delete(key(i))
+	}
+	if tree.walSize == 0 {
+		t.Fatalf("Put() failed: Expected evicted pages in the write-ahead log")
+	}
+	tree.closeFiles()
+
+	tree, err = Open(path, opts)
+	if err != nil {
+		t.Fatalf("Open() failed: %v", err)
+	}
+	if tree.Len() != 1000 {
+		t.Errorf("Open() failed: Expected the 1000 synced keys, got %d", tree.Len())
+	}
+	count := 0
+	err = tree.Scan(nil, nil, func(k, v []byte) bool {
+		count++
+		return true
+	})
+	if err != nil || count != 1000 {
+		t.Errorf("Scan() failed: Expected 1000 keys, got %d: %v", count, err)
+	}
+	if value, ok, err := tree.Get(key(299)); err != nil || !ok || len(value) != 299 {
+		t.Errorf("Get() failed: Expected the synced value, got %d bytes, %v, %v", len(value), ok, err)
+	}
+
+	// A committed log is copied into the file when it is opened.
+	for i := 1000; i < 1100; i++ {
+		tree.Put(key(i), []byte("kept"))
+	}
+	if _, err := tree.commit(); err != nil {
+		t.Fatalf("commit() failed: %v", err)
+	}
+	tree.closeFiles()
+
+	tree, err = Open(path, opts)
+	if err != nil {
+		t.Fatalf("Open() failed: %v", err)
+	}
+	if value, ok, err := tree.Get(key(1050)); err != nil || !ok || string(value) != "kept" || tree.Len() != 1100 {
+		t.Errorf("Open() failed: Expected the committed keys, got '%s', %d keys: %v", value, tree.Len(), err)
+	}
+	if err := tree.Close(); err != nil {
+		t.Fatalf("Close() failed: %v", err)
+	}
+	if _, err := os.Stat(path + walSuffix); !os.IsNotExist(err) {
+		t.Errorf("Close() failed: Expected the write-ahead log to be removed, got %v", err)
+	}
+}
+
+func TestTree_WriteError(t *testing.T) {
+	tree, err := Open(filepath.Join(t.TempDir(), "tree.db"), &Options{PageSize: 1280, CacheSize: 4})
+	if err != nil {
+		t.Fatalf("Open() failed: %v", err)
+	}
+	defer tree.closeFiles()
+
+	tree.wal.Close()
+	for i := 0; i < 1000 && err == nil; i++ {
+		err = tree.Put(key(i), []byte("value"))
+	}
+	if err == nil {
+		t.Errorf("Put() failed: Expected the error of writing an evicted page")
+	}
+	if err := tree.Sync(); err == nil {
+		t.Errorf("Sync() failed: Expected the error of writing the pages")
+	}
+}
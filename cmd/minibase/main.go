@@ -0,0 +1,175 @@
+// This package is the command line tool of minibase.
+//
+// The tool includes maintenance commands for collections.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/OmerMohideen/minibase/db"
+	"github.com/OmerMohideen/minibase/logger"
+)
+
+const usage = `Usage: minibase <command> [flags]
+
+Commands:
+  vacuum    reclaim the space left by deleted records
+  validate  report the records not matching the schema
+  schema    print the schema as JSON Schema or import one
+`
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+// This function runs the command and returns the exit code.
+func run(args []string, stdout, stderr io.Writer) int {
+	if len(args) == 0 {
+		fmt.Fprint(stderr, usage)
+		return 2
+	}
+
+	switch args[0] {
+	case "vacuum":
+		return vacuum(args[1:], stdout, stderr)
+	case "validate":
+		return validate(args[1:], stdout, stderr)
+	case "schema":
+		return schema(args[1:], stdout, stderr)
+	case "help", "-h", "-help", "--help":
+		fmt.Fprint(stdout, usage)
+		return 0
+	}
+	fmt.Fprintf(stderr, "unknown command '%s'\n\n%s", args[0], usage)
+	return 2
+}
+
+// collectionFlags represents the flags which select a collection.
+type collectionFlags struct {
+	*flag.FlagSet
+	dir  *string
+	name *string
+}
+
+// This function creates the flags of a command working on a collection.
+func newCollectionFlags(command string, stderr io.Writer) *collectionFlags {
+	flags := flag.NewFlagSet(command, flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	return &collectionFlags{
+		FlagSet: flags,
+		dir:     flags.String("dir", ".", "directory of the database"),
+		name:    flags.String("collection", "", "name of the collection"),
+	}
+}
+
+// This function parses the arguments and opens the collection.
+func (f *collectionFlags) open(args []string) (*db.Collection, error) {
+	if err := f.Parse(args); err != nil {
+		return nil, err
+	}
+	if *f.name == "" {
+		return nil, fmt.Errorf("-collection is required")
+	}
+	if _, err := os.Stat(filepath.Join(*f.dir, *f.name)); err != nil {
+		return nil, fmt.Errorf("collection '%s' does not exist in %s", *f.name, *f.dir)
+	}
+
+	collection := db.NewCollection(*f.name, logger.New(os.Stdout, os.Stderr))
+	collection.SetDir(*f.dir)
+	return collection, nil
+}
+
+// This function vacuums a collection and reports the space reclaimed.
+func vacuum(args []string, stdout, stderr io.Writer) int {
+	collection, err := newCollectionFlags("vacuum", stderr).open(args)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 2
+	}
+	defer collection.Close()
+
+	stats, err := collection.Vacuum()
+	if err != nil {
+		fmt.Fprintf(stderr, "error vacuuming collection: %v\n", err)
+		return 1
+	}
+	fmt.Fprintf(stdout, "files removed: %d\nfiles rewritten: %d\nbytes reclaimed: %d\n",
+		stats.FilesRemoved, stats.FilesRewritten, stats.BytesReclaimed)
+	return 0
+}
+
+// This function checks the records of a collection against its
+// schema and reports the ones which don't match.
+// The exit code is 1 when any record is invalid.
+func validate(args []string, stdout, stderr io.Writer) int {
+	collection, err := newCollectionFlags("validate", stderr).open(args)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 2
+	}
+	defer collection.Close()
+
+	invalid, err := collection.ValidateAll()
+	if err != nil {
+		fmt.Fprintf(stderr, "error validating collection: %v\n", err)
+		return 1
+	}
+	for _, record := range invalid {
+		for _, err := range record.Errors {
+			fmt.Fprintf(stdout, "record %d: %v\n", record.ID, err)
+		}
+	}
+	fmt.Fprintf(stdout, "invalid records: %d\n", len(invalid))
+	if len(invalid) > 0 {
+		return 1
+	}
+	return 0
+}
+
+// This function prints the schema of a collection as JSON Schema.
+// With -import the schema is replaced by the JSON Schema file first.
+func schema(args []string, stdout, stderr io.Writer) int {
+	flags := newCollectionFlags("schema", stderr)
+	file := flags.String("import", "", "JSON Schema file to set as the schema")
+	mode := flags.String("mode", "strict", "validation mode of the imported schema: off, warn or strict")
+	collection, err := flags.open(args)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 2
+	}
+	defer collection.Close()
+
+	if *file != "" {
+		validation, err := db.ParseValidationMode(*mode)
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			return 2
+		}
+		data, err := os.ReadFile(*file)
+		if err != nil {
+			fmt.Fprintf(stderr, "error reading schema: %v\n", err)
+			return 1
+		}
+		if err := collection.SetJSONSchema(data, validation); err != nil {
+			fmt.Fprintf(stderr, "error importing schema: %v\n", err)
+			return 1
+		}
+		// A new collection saves its schema with its first flush.
+		if err := collection.FlushRecords(); err != nil {
+			fmt.Fprintf(stderr, "error saving schema: %v\n", err)
+			return 1
+		}
+	}
+
+	data, err := collection.JSONSchema()
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	fmt.Fprintf(stdout, "%s\n", data)
+	return 0
+}
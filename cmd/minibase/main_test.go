@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/OmerMohideen/minibase/db"
+	"github.com/OmerMohideen/minibase/logger"
+	"github.com/OmerMohideen/minibase/models"
+)
+
+func TestRun_Vacuum(t *testing.T) {
+	tempDir := t.TempDir()
+	collection := db.NewCollection("test_collection", logger.New(nil, nil))
+	collection.SetDir(tempDir)
+	collection.InsertRecord(&models.Record{Fields: map[string]interface{}{"name": "Sajith"}})
+	collection.FlushRecords()
+	collection.Close()
+
+	var stdout, stderr bytes.Buffer
+	code := run([]string{"vacuum", "-dir", tempDir, "-collection", "test_collection"}, &stdout, &stderr)
+	if code != 0 {
+		t.Fatalf("run() failed: Exit code %d: %s", code, stderr.String())
+	}
+	if !strings.Contains(stdout.String(), "bytes reclaimed: 0") {
+		t.Errorf("run() failed: Unexpected output %q", stdout.String())
+	}
+
+	code = run([]string{"vacuum", "-dir", tempDir, "-collection", "missing"}, &stdout, &stderr)
+	if code == 0 {
+		t.Errorf("run() failed: Missing collection was vacuumed")
+	}
+}
+
+func TestRun_Validate(t *testing.T) {
+	tempDir := t.TempDir()
+	collection := db.NewCollection("test_collection", logger.New(nil, nil))
+	collection.SetDir(tempDir)
+	collection.InsertRecord(&models.Record{Fields: map[string]interface{}{"name": "Sajith"}})
+	collection.InsertRecord(&models.Record{Fields: map[string]interface{}{"name": 7}})
+	collection.FlushRecords()
+	collection.SetSchema(&models.Schema{Fields: map[string]*models.FieldSchema{
+		"name": {Type: models.KindString, Required: true},
+	}}, db.ValidationWarn)
+	collection.Close()
+
+	var stdout, stderr bytes.Buffer
+	code := run([]string{"validate", "-dir", tempDir, "-collection", "test_collection"}, &stdout, &stderr)
+	if code != 1 {
+		t.Fatalf("run() failed: Expected exit code 1, got %d: %s", code, stderr.String())
+	}
+	expected := "record 2: field 'name' must be string, got int\ninvalid records: 1\n"
+	if stdout.String() != expected {
+		t.Errorf("run() failed: Unexpected output %q", stdout.String())
+	}
+}
+
+func TestRun_Schema(t *testing.T) {
+	tempDir := t.TempDir()
+	collection := db.NewCollection("test_collection", logger.New(nil, nil))
+	collection.SetDir(tempDir)
+	collection.InsertRecord(&models.Record{Fields: map[string]interface{}{"name": "Sajith"}})
+	collection.FlushRecords()
+	collection.Close()
+
+	file := filepath.Join(tempDir, "schema.json")
+	os.WriteFile(file, []byte(`{"type": "object", "required": ["name"], "properties": {"name": {"type": "string"}}}`), 0644)
+
+	var stdout, stderr bytes.Buffer
+	code := run([]string{"schema", "-dir", tempDir, "-collection", "test_collection", "-import", file, "-mode", "warn"}, &stdout, &stderr)
+	if code != 0 {
+		t.Fatalf("run() failed: Exit code %d: %s", code, stderr.String())
+	}
+	if !strings.Contains(stdout.String(), `"required": [`) {
+		t.Errorf("run() failed: Unexpected output %q", stdout.String())
+	}
+
+	collection = db.NewCollection("test_collection", logger.New(nil, nil))
+	collection.SetDir(tempDir)
+	defer collection.Close()
+	if schema, mode := collection.Schema(); schema == nil || mode != db.ValidationWarn {
+		t.Errorf("run() failed: Schema was not imported")
+	}
+
+	// A collection directory without records or metadata.
+	if err := os.Mkdir(filepath.Join(tempDir, "new_collection"), 0755); err != nil {
+		t.Fatalf("Mkdir() failed: %v", err)
+	}
+	code = run([]string{"schema", "-dir", tempDir, "-collection", "new_collection", "-import", file}, &stdout, &stderr)
+	if code != 0 {
+		t.Fatalf("run() failed: Exit code %d: %s", code, stderr.String())
+	}
+	created := db.NewCollection("new_collection", logger.New(nil, nil))
+	created.SetDir(tempDir)
+	defer created.Close()
+	if schema, mode := created.Schema(); schema == nil || mode != db.ValidationStrict {
+		t.Errorf("run() failed: Schema of a new collection was not imported")
+	}
+}
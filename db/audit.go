@@ -0,0 +1,276 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/OmerMohideen/minibase/models"
+)
+
+// Name of the audit trail file inside the collection directory.
+const AUDIT_FILE = "audit.log"
+
+// actorKey is the key of the actor in a context.
+type actorKey struct{}
+
+// This function returns a copy of the context carrying the actor,
+// the principal making the changes, recorded by the audit trail.
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// This function gets the actor of the context, it is empty when
+// the context has none.
+func ActorFrom(ctx context.Context) string {
+	actor, _ := ctx.Value(actorKey{}).(string)
+	return actor
+}
+
+// AuditOptions represents the settings of the audit trail.
+type AuditOptions struct {
+	Enabled bool `json:"enabled"`
+	// Age after which entries are pruned, zero keeps all of them.
+	Retention time.Duration `json:"retention,omitempty"`
+}
+
+// AuditEntry represents a change recorded by the audit trail.
+// Changes lists the fields which differ, inserts add all the
+// fields of the record and deletes remove them.
+type AuditEntry struct {
+	Op      ChangeKind           `json:"op"`
+	ID      int                  `json:"id"`
+	Key     string               `json:"key,omitempty"`
+	Actor   string               `json:"actor,omitempty"`
+	Time    time.Time            `json:"time"`
+	Changes []models.FieldChange `json:"changes,omitempty"`
+}
+
+// AuditQuery selects entries of the audit trail, zero values
+// select all of them. Since is inclusive and Until exclusive.
+type AuditQuery struct {
+	ID    int
+	Actor string
+	Ops   []ChangeKind
+	Since time.Time
+	Until time.Time
+	// Maximum number of entries, the latest ones are kept.
+	Limit int
+}
+
+// This function tells if the entry is selected by the query.
+func (q AuditQuery) matches(entry AuditEntry) bool {
+	if q.ID != 0 && entry.ID != q.ID {
+		return false
+	}
+	if q.Actor != "" && entry.Actor != q.Actor {
+		return false
+	}
+	if len(q.Ops) > 0 && !containsKind(q.Ops, entry.Op) {
+		return false
+	}
+	if !q.Since.IsZero() && entry.Time.Before(q.Since) {
+		return false
+	}
+	return q.Until.IsZero() || entry.Time.Before(q.Until)
+}
+
+// This function enables or disables the audit trail of the
+// collection. The setting is saved in the metadata of the
+// collection. The actor of a change is taken from the context
+// of the operation, see WithActor. A change is recorded once it is
+// saved, by the next flush for most of them, and an entry which
+// can't be written fails the flush or the operation saving it.
+func (c *Collection) SetAudit(opts AuditOptions) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.auditOpts = opts
+	if err := c.openAuditLog(); err != nil {
+		return err
+	}
+	return c.saveMetadata()
+}
+
+// This function gets the entries of the audit trail selected by
+// the query in the order the changes were saved.
+func (c *Collection) AuditLog(query AuditQuery) ([]AuditEntry, error) {
+	return c.AuditLogContext(context.Background(), query)
+}
+
+// This function is AuditLog with a context.
+func (c *Collection) AuditLogContext(ctx context.Context, query AuditQuery) ([]AuditEntry, error) {
+	if err := c.ready(ctx); err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	if c.audit == nil {
+		c.mu.Unlock()
+		return nil, fmt.Errorf("collection '%s' has no audit trail: %w", c.name, ErrNotFound)
+	}
+	// The entries written so far are read without the lock,
+	// pruning replaces the file so the opened one stays whole.
+	file, err := os.Open(c.audit.path)
+	size := c.audit.size
+	c.mu.Unlock()
+	if err != nil {
+		return nil, fmt.Errorf("error opening file: %w", err)
+	}
+	defer file.Close()
+
+	var entries []AuditEntry
+	err = readJournal(io.NewSectionReader(file, 0, size), func(_ byte, id uint64, payload []byte) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if query.ID != 0 && int(id) != query.ID {
+			return nil
+		}
+		var entry AuditEntry
+		if err := json.Unmarshal(payload, &entry); err != nil {
+			return corrupt(err)
+		}
+		if query.matches(entry) {
+			entries = append(entries, entry)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if query.Limit > 0 && len(entries) > query.Limit {
+		entries = entries[len(entries)-query.Limit:]
+	}
+	return entries, nil
+}
+
+// This function removes the entries of the audit trail older
+// than its retention and gets how many were removed. It is
+// called by the collection every REAP_INTERVAL.
+func (c *Collection) PruneAuditLog() (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.audit == nil || c.auditOpts.Retention <= 0 {
+		return 0, nil
+	}
+	return c.audit.prune(c.now().Add(-c.auditOpts.Retention))
+}
+
+// This function closes the audit trail and opens it again when
+// it is enabled.
+// The caller must hold the lock.
+func (c *Collection) openAuditLog() error {
+	if c.audit != nil {
+		c.audit.close()
+		c.audit = nil
+	}
+	if !c.auditOpts.Enabled {
+		return nil
+	}
+	audit, err := openAuditLog(filepath.Join(c.dir, c.name))
+	if err != nil {
+		return err
+	}
+	c.audit = audit
+	return nil
+}
+
+// This function creates the audit entry of a change made by
+// the actor.
+func newAuditEntry(actor string, event ChangeEvent) AuditEntry {
+	entry := AuditEntry{Op: event.Kind, ID: event.ID, Actor: actor, Time: event.Time}
+	var before, after map[string]interface{}
+	if event.Before != nil {
+		before, entry.Key = event.Before.Fields, event.Before.Key
+	}
+	if event.After != nil {
+		after, entry.Key = event.After.Fields, event.After.Key
+	}
+	entry.Changes = models.Diff(before, after)
+	return entry
+}
+
+// auditLog is the journal of the audit trail of a collection.
+// The entries have the kind of the change as operation and the
+// id of the record as id.
+type auditLog struct {
+	*journal
+	// Time of the oldest entry, zero when there is none.
+	oldest time.Time
+}
+
+// This function opens the audit trail of the collection directory.
+func openAuditLog(dir string) (*auditLog, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	audit := &auditLog{}
+	j, err := openJournal(filepath.Join(dir, AUDIT_FILE), func(_ byte, _ uint64, payload []byte) {
+		audit.account(payload)
+	})
+	if err != nil {
+		return nil, err
+	}
+	audit.journal = j
+	return audit, nil
+}
+
+// This function accounts an entry of the journal.
+func (a *auditLog) account(payload []byte) {
+	if !a.oldest.IsZero() {
+		return
+	}
+	var entry AuditEntry
+	if err := json.Unmarshal(payload, &entry); err == nil {
+		a.oldest = entry.Time
+	}
+}
+
+// This function appends the entry to the journal.
+func (a *auditLog) appendEntry(entry AuditEntry) error {
+	payload, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("error encoding data: %v", err)
+	}
+	if err := a.append(byte(entry.Op), uint64(entry.ID), payload); err != nil {
+		return err
+	}
+	if a.oldest.IsZero() {
+		a.oldest = entry.Time
+	}
+	return nil
+}
+
+// This function rewrites the journal without the entries older
+// than the time and gets how many were removed.
+func (a *auditLog) prune(before time.Time) (int, error) {
+	if a.oldest.IsZero() || !a.oldest.Before(before) {
+		return 0, nil
+	}
+	pruned := 0
+	oldest := time.Time{}
+	err := a.rewrite(func(_ byte, _ uint64, payload []byte) bool {
+		var entry AuditEntry
+		if err := json.Unmarshal(payload, &entry); err != nil {
+			return true
+		}
+		if entry.Time.Before(before) {
+			pruned++
+			return false
+		}
+		if oldest.IsZero() {
+			oldest = entry.Time
+		}
+		return true
+	})
+	if err != nil {
+		return 0, err
+	}
+	a.oldest = oldest
+	return pruned, nil
+}
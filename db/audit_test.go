@@ -0,0 +1,103 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/OmerMohideen/minibase/logger"
+	"github.com/OmerMohideen/minibase/models"
+)
+
+func TestCollection_Audit(t *testing.T) {
+	logger, tempDir := logger.New(nil, nil), t.TempDir()
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	collection := NewCollection("test_collection", logger)
+	collection.SetDir(tempDir)
+	collection.SetClock(func() time.Time { return now })
+
+	if _, err := collection.AuditLog(AuditQuery{}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("AuditLog() failed: Expected ErrNotFound without an audit trail, got %v", err)
+	}
+	if err := collection.SetAudit(AuditOptions{Enabled: true, Retention: time.Hour}); err != nil {
+		t.Fatalf("SetAudit() failed: %v", err)
+	}
+
+	alice := WithActor(context.Background(), "alice")
+	bob := WithActor(context.Background(), "bob")
+	for i := 0; i < 2; i++ {
+		record := &models.Record{Fields: map[string]interface{}{"name": "a", "n": i}}
+		if err := collection.InsertRecordContext(alice, record); err != nil {
+			t.Fatalf("InsertRecordContext() failed: %v", err)
+		}
+	}
+	now = now.Add(time.Minute)
+	if err := collection.UpdateRecordContext(bob, 1, &models.Record{Fields: map[string]interface{}{"name": "b", "n": 0}}); err != nil {
+		t.Fatalf("UpdateRecordContext() failed: %v", err)
+	}
+	if err := collection.DeleteRecordContext(bob, 2); err != nil {
+		t.Fatalf("DeleteRecordContext() failed: %v", err)
+	}
+	if err := collection.FlushRecords(); err != nil {
+		t.Fatalf("FlushRecords() failed: %v", err)
+	}
+	collection.Close()
+
+	newcollection := NewCollection("test_collection", logger)
+	newcollection.SetDir(tempDir)
+	newcollection.SetClock(func() time.Time { return now })
+	defer newcollection.Close()
+
+	entries, err := newcollection.AuditLog(AuditQuery{ID: 1})
+	if err != nil {
+		t.Fatalf("AuditLog() failed: %v", err)
+	}
+	expected := []AuditEntry{
+		{Op: ChangeInsert, ID: 1, Actor: "alice", Time: now.Add(-time.Minute), Changes: []models.FieldChange{
+			{Path: "n", Op: models.FieldAdded, After: int64(0)},
+			{Path: "name", Op: models.FieldAdded, After: "a"},
+		}},
+		{Op: ChangeUpdate, ID: 1, Actor: "bob", Time: now, Changes: []models.FieldChange{
+			{Path: "name", Op: models.FieldChanged, Before: "a", After: "b"},
+		}},
+	}
+	if !reflect.DeepEqual(entries, expected) {
+		t.Errorf("AuditLog() failed: Expected %+v, got %+v", expected, entries)
+	}
+
+	entries, err = newcollection.AuditLog(AuditQuery{Actor: "bob", Ops: []ChangeKind{ChangeDelete}})
+	if err != nil || len(entries) != 1 || entries[0].ID != 2 || len(entries[0].Changes) != 2 || entries[0].Changes[0].Op != models.FieldRemoved {
+		t.Errorf("AuditLog() failed: Expected the delete of 2, got %+v, %v", entries, err)
+	}
+	entries, err = newcollection.AuditLog(AuditQuery{Since: now, Limit: 1})
+	// The delete was saved right away, before the flush of the update.
+	if err != nil || len(entries) != 1 || entries[0].Op != ChangeUpdate {
+		t.Errorf("AuditLog() failed: Expected the latest saved entry, got %+v, %v", entries, err)
+	}
+
+	now = now.Add(time.Hour - 30*time.Second)
+	if err := newcollection.InsertRecord(&models.Record{Fields: map[string]interface{}{"name": "c"}}); err != nil {
+		t.Fatalf("InsertRecord() failed: %v", err)
+	}
+	if entries, err := newcollection.AuditLog(AuditQuery{}); err != nil || len(entries) != 4 {
+		t.Errorf("AuditLog() failed: Expected no entry of an unsaved change, got %+v, %v", entries, err)
+	}
+	if err := newcollection.FlushRecords(); err != nil {
+		t.Fatalf("FlushRecords() failed: %v", err)
+	}
+	pruned, err := newcollection.PruneAuditLog()
+	if err != nil || pruned != 2 {
+		t.Errorf("PruneAuditLog() failed: Expected 2 pruned, got %d, %v", pruned, err)
+	}
+	entries, err = newcollection.AuditLog(AuditQuery{})
+	if err != nil || len(entries) != 3 || entries[0].Op != ChangeDelete || entries[2].Op != ChangeInsert || entries[2].Actor != "" {
+		t.Errorf("AuditLog() failed: Expected the entries within the retention, got %+v, %v", entries, err)
+	}
+
+	newcollection.audit.file.Close()
+	if err := newcollection.DeleteRecord(3); err == nil {
+		t.Errorf("DeleteRecord() failed: Expected the error of writing the audit trail")
+	}
+}
@@ -0,0 +1,267 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/OmerMohideen/minibase/models"
+)
+
+// WriteKind represents the kind of an operation of a bulk write.
+type WriteKind int
+
+const (
+	// The record is inserted with a new id.
+	WriteInsert WriteKind = iota
+	// The record with the id is replaced.
+	WriteUpdate
+	// The patch is applied to the record with the id.
+	WritePatch
+	// The record with the id is deleted.
+	WriteDelete
+	// The record with the id is replaced or inserted.
+	WriteUpsert
+)
+
+// This function returns the name of the write kind.
+func (k WriteKind) String() string {
+	switch k {
+	case WriteInsert:
+		return "insert"
+	case WriteUpdate:
+		return "update"
+	case WritePatch:
+		return "patch"
+	case WriteDelete:
+		return "delete"
+	case WriteUpsert:
+		return "upsert"
+	}
+	return fmt.Sprintf("WriteKind(%d)", int(k))
+}
+
+// WriteOperation represents an operation of a bulk write.
+// Record is used by inserts, updates and upserts and Patch by
+// patches.
+type WriteOperation struct {
+	Kind   WriteKind
+	ID     int
+	Record *models.Record
+	Patch  models.Patch
+}
+
+// WriteResult represents the outcome of an operation of a bulk
+// write. ID is the id of the written record and Created tells
+// if an upsert inserted it.
+type WriteResult struct {
+	ID      int
+	Created bool
+	Err     error
+}
+
+// This function applies the operations in order under one lock
+// so no other writer sees the batch half done. An operation
+// which fails doesn't stop the others, its error is kept in its
+// result and an error counting the failures is returned.
+// Like the other writes the changes are saved by the next flush.
+func (c *Collection) BulkWrite(operations []WriteOperation) ([]WriteResult, error) {
+	return c.BulkWriteContext(context.Background(), operations)
+}
+
+// This function is BulkWrite with a context. When the context
+// is done or the collection is closed the remaining operations
+// are not applied and their results hold the error.
+func (c *Collection) BulkWriteContext(ctx context.Context, operations []WriteOperation) ([]WriteResult, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	results := make([]WriteResult, len(operations))
+	var firstErr error
+	failed := 0
+	for i, operation := range operations {
+		if err := c.ready(ctx); err != nil {
+			for j := i; j < len(operations); j++ {
+				results[j] = WriteResult{ID: operations[j].ID, Err: err}
+			}
+			return results, err
+		}
+		result := c.write(ctx, operation)
+		if result.Err != nil {
+			result.Err = fmt.Errorf("operation %d (%s): %w", i, operation.Kind, result.Err)
+			if failed == 0 {
+				firstErr = result.Err
+			}
+			failed++
+		}
+		results[i] = result
+	}
+	if failed > 0 {
+		return results, fmt.Errorf("%d of %d operations failed: %w", failed, len(operations), firstErr)
+	}
+	return results, nil
+}
+
+// This function applies an operation of a bulk write.
+// The caller must hold the lock.
+func (c *Collection) write(ctx context.Context, operation WriteOperation) WriteResult {
+	result := WriteResult{ID: operation.ID}
+	if operation.Record == nil && (operation.Kind == WriteInsert || operation.Kind == WriteUpdate || operation.Kind == WriteUpsert) {
+		result.Err = invalid(fmt.Errorf("no record given"))
+		return result
+	}
+
+	switch operation.Kind {
+	case WriteInsert:
+		result.Err = c.insertRecord(ctx, c.nextID, operation.Record)
+		result.ID = operation.Record.ID
+	case WriteUpdate:
+		if err := operation.Record.Normalize(); err != nil {
+			result.Err = invalid(err)
+		} else {
+			result.Err = c.updateRecord(ctx, operation.ID, operation.Record)
+		}
+	case WritePatch:
+		_, result.Err = c.patchRecord(ctx, operation.ID, operation.Patch)
+	case WriteDelete:
+		result.Err = c.deleteRecord(ctx, operation.ID)
+	case WriteUpsert:
+		result.Created, result.Err = c.upsertRecord(ctx, operation.ID, operation.Record)
+		result.ID = operation.Record.ID
+	default:
+		result.Err = invalid(fmt.Errorf("unknown write kind %s", operation.Kind))
+	}
+	return result
+}
+
+// This function replaces the record with the id or inserts the
+// record with the id when there is none. An id which is not
+// positive always inserts with a new id. The id of the record is
+// set and whether it was inserted is returned.
+func (c *Collection) Upsert(id int, record *models.Record) (bool, error) {
+	return c.UpsertContext(context.Background(), id, record)
+}
+
+// This function is Upsert with a context.
+func (c *Collection) UpsertContext(ctx context.Context, id int, record *models.Record) (bool, error) {
+	if err := c.ready(ctx); err != nil {
+		return false, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.upsertRecord(ctx, id, record)
+}
+
+// This function replaces the record whose field holds the same
+// value as the field of the record or inserts the record when
+// there is none. The value must be unique in the collection.
+// Every record is scanned to find it. Whether the record was
+// inserted is returned.
+func (c *Collection) UpsertBy(field string, record *models.Record) (bool, error) {
+	return c.UpsertByContext(context.Background(), field, record)
+}
+
+// This function is UpsertBy with a context.
+func (c *Collection) UpsertByContext(ctx context.Context, field string, record *models.Record) (bool, error) {
+	if err := c.ready(ctx); err != nil {
+		return false, err
+	}
+	if err := record.Normalize(); err != nil {
+		return false, invalid(err)
+	}
+	value, err := record.GetField(field)
+	if err != nil {
+		return false, err
+	}
+	encoded, err := models.EncodeValue(value)
+	if err != nil {
+		return false, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	matches, err := c.recordsWith(field, encoded)
+	if err != nil {
+		return false, err
+	}
+	switch len(matches) {
+	case 0:
+		return true, c.insertRecord(ctx, c.nextID, record)
+	case 1:
+		return c.upsertRecord(ctx, matches[0], record)
+	}
+	return false, fmt.Errorf("field '%s' is not unique, %d records hold %v: %w", field, len(matches), value, ErrConflict)
+}
+
+// This function gets the ids of the records whose field holds the
+// encoded value. Records in the memory are preferred over their
+// saved version and expired records are skipped.
+// The caller must hold the lock.
+func (c *Collection) recordsWith(field string, encoded []byte) ([]int, error) {
+	now := c.now()
+	holds := func(record *models.Record) bool {
+		if record.Expired(now) {
+			return false
+		}
+		current, err := record.GetField(field)
+		if err != nil {
+			return false
+		}
+		data, err := models.EncodeValue(current)
+		return err == nil && bytes.Equal(data, encoded)
+	}
+
+	target := c.targetVersion()
+	var ids []int
+	for id, record := range c.records {
+		if holds(record) {
+			ids = append(ids, id)
+		}
+	}
+	err := c.store.scan(1, math.MaxInt, func(record *models.Record) error {
+		if _, ok := c.records[record.ID]; ok {
+			return nil
+		}
+		if record.SchemaVersion < target {
+			if err := upgradeRecord(c.migrations, target, record); err != nil {
+				return err
+			}
+		}
+		if holds(record) {
+			ids = append(ids, record.ID)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error scanning records: %w", err)
+	}
+	sort.Ints(ids)
+	return ids, nil
+}
+
+// This function replaces or inserts the record with the id.
+// The caller must hold the lock.
+func (c *Collection) upsertRecord(ctx context.Context, id int, record *models.Record) (bool, error) {
+	if id <= 0 {
+		return true, c.insertRecord(ctx, c.nextID, record)
+	}
+	existing, err := c.liveRecord(ctx, id)
+	if err != nil {
+		return false, err
+	}
+	if existing == nil {
+		if err := c.checkTrash(id); err != nil {
+			return false, err
+		}
+		// An expired record is overwritten by the insert.
+		delete(c.records, id)
+		return true, c.insertRecord(ctx, id, record)
+	}
+	if err := record.Normalize(); err != nil {
+		return false, invalid(err)
+	}
+	return false, c.replaceRecord(ctx, ChangeUpdate, existing, record)
+}
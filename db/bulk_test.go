@@ -0,0 +1,121 @@
+package db
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/OmerMohideen/minibase/logger"
+	"github.com/OmerMohideen/minibase/models"
+)
+
+func TestCollection_Upsert(t *testing.T) {
+	logger, tempDir := logger.New(nil, nil), t.TempDir()
+	collection := NewCollection("test_collection", logger)
+	collection.SetDir(tempDir)
+	defer collection.Close()
+
+	collection.InsertRecord(&models.Record{Fields: map[string]interface{}{"name": "Sajith"}})
+	if err := collection.FlushRecords(); err != nil {
+		t.Fatalf("FlushRecords() failed: %v", err)
+	}
+	collection.records = make(map[int]*models.Record)
+
+	created, err := collection.Upsert(1, &models.Record{Fields: map[string]interface{}{"name": "Anura"}})
+	if err != nil || created {
+		t.Errorf("Upsert() failed: Expected record 1 to be replaced, got %v, %v", created, err)
+	}
+	record := &models.Record{Fields: map[string]interface{}{"name": "Mahinda"}}
+	created, err = collection.Upsert(5, record)
+	if err != nil || !created || record.ID != 5 {
+		t.Errorf("Upsert() failed: Expected record 5 to be inserted, got %v, %v", created, err)
+	}
+	collection.InsertRecord(models.NewRecord())
+	if collection.nextID != 7 {
+		t.Errorf("Upsert() failed: Expected next ID 7, got %d", collection.nextID)
+	}
+	if record, _ := collection.GetRecordByID(1); record.Fields["name"] != "Anura" {
+		t.Errorf("Upsert() failed: Record 1 was not replaced")
+	}
+
+	record = &models.Record{Fields: map[string]interface{}{"email": "a@example.com", "name": "Sajith"}}
+	created, err = collection.UpsertBy("email", record)
+	if err != nil || !created {
+		t.Errorf("UpsertBy() failed: Expected a record to be inserted, got %v, %v", created, err)
+	}
+	id := record.ID
+	if err := collection.FlushRecords(); err != nil {
+		t.Fatalf("FlushRecords() failed: %v", err)
+	}
+	collection.records = make(map[int]*models.Record)
+	record = &models.Record{Fields: map[string]interface{}{"email": "a@example.com", "name": "Ranil"}}
+	created, err = collection.UpsertBy("email", record)
+	if err != nil || created || record.ID != id {
+		t.Errorf("UpsertBy() failed: Expected record %d to be replaced, got %d, %v, %v", id, record.ID, created, err)
+	}
+
+	collection.InsertRecord(&models.Record{Fields: map[string]interface{}{"email": "a@example.com"}})
+	if _, err := collection.UpsertBy("email", &models.Record{Fields: map[string]interface{}{"email": "a@example.com"}}); err == nil {
+		t.Errorf("UpsertBy() failed: Expected an error for a value which is not unique")
+	}
+
+	if err := collection.SetSoftDelete(SoftDeleteOptions{Enabled: true}); err != nil {
+		t.Fatalf("SetSoftDelete() failed: %v", err)
+	}
+	if err := collection.DeleteRecord(1); err != nil {
+		t.Fatalf("DeleteRecord() failed: %v", err)
+	}
+	collection.FlushRecords()
+	collection.records = make(map[int]*models.Record)
+	if _, err := collection.Upsert(1, &models.Record{Fields: map[string]interface{}{"name": "Namal"}}); !errors.Is(err, ErrConflict) {
+		t.Errorf("Upsert() failed: Expected ErrConflict for a record in the trash, got %v", err)
+	}
+	if deleted, err := collection.ListDeleted(); err != nil || len(deleted) != 1 || deleted[0].Fields["name"] != "Anura" {
+		t.Errorf("Upsert() failed: Expected the record to stay in the trash, got %v, %v", deleted, err)
+	}
+}
+
+func TestCollection_BulkWrite(t *testing.T) {
+	logger := logger.New(nil, nil)
+	collection := NewCollection("test_collection", logger)
+	collection.SetDir(t.TempDir())
+	defer collection.Close()
+
+	collection.InsertRecord(&models.Record{Fields: map[string]interface{}{"name": "Sajith", "views": 1}})
+	collection.InsertRecord(&models.Record{Fields: map[string]interface{}{"name": "Mahinda"}})
+
+	results, err := collection.BulkWrite([]WriteOperation{
+		{Kind: WriteInsert, Record: &models.Record{Fields: map[string]interface{}{"name": "Anura"}}},
+		{Kind: WriteUpdate, ID: 2, Record: &models.Record{Fields: map[string]interface{}{"name": "Ranil"}}},
+		{Kind: WritePatch, ID: 1, Patch: models.Patch{"$inc": {"views": 1}}},
+		{Kind: WriteDelete, ID: 9},
+		{Kind: WriteUpsert, ID: 10, Record: &models.Record{Fields: map[string]interface{}{"name": "Gotabaya"}}},
+		{Kind: WriteDelete, ID: 3},
+	})
+	if err == nil {
+		t.Errorf("BulkWrite() failed: Expected an error for the failed delete")
+	}
+	if len(results) != 6 {
+		t.Fatalf("BulkWrite() failed: Expected 6 results, got %d", len(results))
+	}
+	for i, result := range results {
+		if (result.Err != nil) != (i == 3) {
+			t.Errorf("BulkWrite() failed: Unexpected result of operation %d: %v", i, result.Err)
+		}
+	}
+	if results[0].ID != 3 || results[4].ID != 10 || !results[4].Created {
+		t.Errorf("BulkWrite() failed: Unexpected results %+v", results)
+	}
+
+	if record, _ := collection.GetRecordByID(1); record.Fields["views"] != int64(2) {
+		t.Errorf("BulkWrite() failed: Record 1 was not patched")
+	}
+	if record, _ := collection.GetRecordByID(2); record.Fields["name"] != "Ranil" {
+		t.Errorf("BulkWrite() failed: Record 2 was not updated")
+	}
+	if _, err := collection.GetRecordByID(3); err == nil {
+		t.Errorf("BulkWrite() failed: Record 3 was not deleted")
+	}
+	if _, err := collection.GetRecordByID(10); err != nil {
+		t.Errorf("BulkWrite() failed: Record 10 was not inserted: %v", err)
+	}
+}
@@ -0,0 +1,709 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/OmerMohideen/minibase/models"
+	"github.com/OmerMohideen/minibase/utils"
+)
+
+// chunkEntry is the position of an encoded record inside a chunk file.
+type chunkEntry struct {
+	offset int64
+	length int64
+}
+
+// chunkIndex maps record ids to their position inside a chunk file.
+// The size and modification time of the file are kept so a stale
+// index can be detected when the file was rewritten.
+type chunkIndex struct {
+	size    int64
+	modTime time.Time
+	entries map[int]chunkEntry
+}
+
+// This function reports whether the index still describes the file.
+func (i *chunkIndex) valid(info os.FileInfo) bool {
+	return i.size == info.Size() && i.modTime.Equal(info.ModTime())
+}
+
+// Pattern of the chunk filenames, the groups are the id range.
+var chunkPattern = regexp.MustCompile(`^(\d+)-(\d+)\.json$`)
+
+// chunkStorage saves records in JSON chunk files partitioned by
+// their id using MAX_CHUNK records per file. Vacuum may merge
+// sparse chunks into a file covering several id ranges, so the
+// file of an id is resolved through a catalog of the chunk files.
+type chunkStorage struct {
+	mu      sync.Mutex
+	path    string
+	offsets map[string]*chunkIndex
+	chunks  []chunkFile
+	// Serializes the changes to the chunk files.
+	writeMu sync.Mutex
+	// Held by readers while they open a file, and exclusively
+	// while vacuum replaces files.
+	files sync.RWMutex
+}
+
+// This function creates a chunk storage in the directory.
+func newChunkStorage(path string) *chunkStorage {
+	return &chunkStorage{
+		path:    path,
+		offsets: make(map[string]*chunkIndex),
+	}
+}
+
+// This function finds the chunk file which covers the id.
+func (s *chunkStorage) locate(id int) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.chunks == nil {
+		chunks, err := listChunks(s.path)
+		if err != nil {
+			return chunkFilename(id)
+		}
+		s.chunks = append([]chunkFile{}, chunks...)
+	}
+	i := sort.Search(len(s.chunks), func(i int) bool { return s.chunks[i].end >= id })
+	if i < len(s.chunks) && s.chunks[i].start <= id {
+		return s.chunks[i].name
+	}
+	return chunkFilename(id)
+}
+
+// This function drops the catalog of the chunk files
+// after files were added or removed.
+func (s *chunkStorage) invalidate() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.chunks = nil
+}
+
+// This function reads the encoded record from its chunk file.
+// The offset index of the chunk is used to read only the requested
+// record, the whole chunk is read when the index is missing or
+// stale and its raw records are returned as well.
+func (s *chunkStorage) read(id int) ([]byte, map[int]json.RawMessage, error) {
+	s.files.RLock()
+	defer s.files.RUnlock()
+
+	filename := s.locate(id)
+	file, err := os.Open(filepath.Join(s.path, filename))
+	if os.IsNotExist(err) {
+		// The catalog may be stale when another process
+		// vacuumed the collection.
+		s.invalidate()
+		if located := s.locate(id); located != filename {
+			filename = located
+			file, err = os.Open(filepath.Join(s.path, filename))
+		}
+	}
+	if os.IsNotExist(err) {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("error opening file: %w", err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	s.mu.Lock()
+	index := s.offsets[filename]
+	s.mu.Unlock()
+
+	if index != nil && index.valid(info) {
+		entry, ok := index.entries[id]
+		if !ok {
+			return nil, nil, nil
+		}
+		data := make([]byte, entry.length)
+		if _, err := file.ReadAt(data, entry.offset); err != nil {
+			return nil, nil, fmt.Errorf("error reading file: %w", err)
+		}
+		return data, nil, nil
+	}
+
+	raws, index, err := readChunk(file)
+	if err != nil {
+		return nil, nil, err
+	}
+	s.mu.Lock()
+	s.offsets[filename] = index
+	s.mu.Unlock()
+	return raws[id], raws, nil
+}
+
+// This function loads the record from its chunk file.
+// Other records of the chunk are passed to warm when
+// the whole chunk had to be read.
+func (s *chunkStorage) load(id int, warm func(*models.Record)) (*models.Record, error) {
+	data, raws, err := s.read(id)
+	if err != nil {
+		return nil, err
+	}
+	if warm != nil {
+		for recordID, raw := range raws {
+			if recordID == id {
+				continue
+			}
+			record, err := decodeRecord(raw)
+			if err != nil {
+				return nil, err
+			}
+			warm(record)
+		}
+	}
+	if data == nil {
+		return nil, nil
+	}
+	return decodeRecord(data)
+}
+
+// This function loads the records from their chunk files.
+// The ids are grouped by their chunk range so every file is
+// opened and decoded at most once. Missing records are left out.
+func (s *chunkStorage) loadMany(ctx context.Context, ids []int) (map[int]*models.Record, error) {
+	s.files.RLock()
+	defer s.files.RUnlock()
+
+	files := make(map[string][]int)
+	ranges := make(map[int]string)
+	for _, id := range ids {
+		if id < 1 {
+			continue
+		}
+		start, _ := utils.GetChunkRange(id, MAX_CHUNK)
+		filename, ok := ranges[start]
+		if !ok {
+			filename = s.locate(id)
+			ranges[start] = filename
+		}
+		files[filename] = append(files[filename], id)
+	}
+
+	records := make(map[int]*models.Record, len(ids))
+	for filename, fileIDs := range files {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		raws, err := s.readMany(filename, fileIDs)
+		if err != nil {
+			return nil, err
+		}
+		for _, id := range fileIDs {
+			raw, ok := raws[id]
+			if !ok {
+				continue
+			}
+			record, err := decodeRecord(raw)
+			if err != nil {
+				return nil, err
+			}
+			records[id] = record
+		}
+	}
+	return records, nil
+}
+
+// This function reads the encoded records of the ids from the
+// chunk file. Only the requested records are read when the offset
+// index of the chunk is valid, otherwise every record of the chunk
+// is returned. The caller must hold the files lock.
+func (s *chunkStorage) readMany(filename string, ids []int) (map[int]json.RawMessage, error) {
+	file, err := os.Open(filepath.Join(s.path, filename))
+	if os.IsNotExist(err) {
+		s.invalidate()
+		if located := s.locate(ids[0]); located != filename {
+			filename = located
+			file, err = os.Open(filepath.Join(s.path, filename))
+		}
+	}
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error opening file: %w", err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	index := s.offsets[filename]
+	s.mu.Unlock()
+
+	if index != nil && index.valid(info) {
+		raws := make(map[int]json.RawMessage, len(ids))
+		for _, id := range ids {
+			entry, ok := index.entries[id]
+			if !ok {
+				continue
+			}
+			data := make([]byte, entry.length)
+			if _, err := file.ReadAt(data, entry.offset); err != nil {
+				return nil, fmt.Errorf("error reading file: %w", err)
+			}
+			raws[id] = data
+		}
+		return raws, nil
+	}
+
+	raws, index, err := readChunk(file)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	s.offsets[filename] = index
+	s.mu.Unlock()
+	return raws, nil
+}
+
+// This function reads the encoded record from its chunk file.
+func (s *chunkStorage) raw(id int) ([]byte, func(), error) {
+	data, _, err := s.read(id)
+	return data, nil, err
+}
+
+// This function saves the records into their chunk files.
+// Records of a chunk which are not in the memory are kept.
+// Cancellation is checked before every chunk file.
+func (s *chunkStorage) write(ctx context.Context, records map[int]*models.Record) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	chunks := make(map[string][]*models.Record)
+	for id, record := range records {
+		filename := s.locate(id)
+		chunks[filename] = append(chunks[filename], record)
+	}
+
+	for filename, chunk := range chunks {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		file := filepath.Join(s.path, filename)
+		_, err := os.Stat(file)
+		exists := err == nil
+		if exists {
+			raws, _, err := scanChunk(file)
+			if err != nil {
+				return err
+			}
+			for id, raw := range raws {
+				if _, ok := records[id]; ok {
+					continue
+				}
+				record, err := decodeRecord(raw)
+				if err != nil {
+					return err
+				}
+				chunk = append(chunk, record)
+			}
+		}
+
+		if err := s.replace(filename, chunk); err != nil {
+			return err
+		}
+		if !exists {
+			s.invalidate()
+		}
+	}
+	return nil
+}
+
+// This function writes the chunk file and keeps its offset index.
+func (s *chunkStorage) replace(filename string, records []*models.Record) error {
+	index, err := writeChunk(filepath.Join(s.path, filename), records)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.offsets[filename] = index
+	s.mu.Unlock()
+	return nil
+}
+
+// This function deletes the record from its chunk file
+// by rewriting the chunk without it.
+func (s *chunkStorage) remove(id int) (bool, error) {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	filename := s.locate(id)
+	file := filepath.Join(s.path, filename)
+	if _, err := os.Stat(file); os.IsNotExist(err) {
+		return false, nil
+	}
+
+	raws, _, err := scanChunk(file)
+	if err != nil {
+		return false, err
+	}
+	if _, found := raws[id]; !found {
+		return false, nil
+	}
+	delete(raws, id)
+
+	records, err := decodeRecords(raws)
+	if err != nil {
+		return false, err
+	}
+	return true, s.replace(filename, records)
+}
+
+// This function scans the chunk files which overlap the ids.
+// Vacuum waits for the scan to finish.
+func (s *chunkStorage) scan(from, to int, fn func(*models.Record) error) error {
+	s.files.RLock()
+	defer s.files.RUnlock()
+
+	chunks, err := listChunks(s.path)
+	if err != nil {
+		return err
+	}
+
+	for _, chunk := range chunks {
+		if chunk.end < from || chunk.start > to {
+			continue
+		}
+		raws, _, err := scanChunk(filepath.Join(s.path, chunk.name))
+		if err != nil {
+			return err
+		}
+		ids := make([]int, 0, len(raws))
+		for id := range raws {
+			if id >= from && id <= to {
+				ids = append(ids, id)
+			}
+		}
+		sort.Ints(ids)
+		for _, id := range ids {
+			record, err := decodeRecord(raws[id])
+			if err != nil {
+				return err
+			}
+			if err := fn(record); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// This function gets the highest id saved in the chunk files.
+func (s *chunkStorage) lastID() (int, error) {
+	chunks, err := listChunks(s.path)
+	if err != nil {
+		return 0, err
+	}
+
+	for i := len(chunks) - 1; i >= 0; i-- {
+		raws, _, err := scanChunk(filepath.Join(s.path, chunks[i].name))
+		if err != nil {
+			return 0, err
+		}
+		last := 0
+		for id := range raws {
+			if id > last {
+				last = id
+			}
+		}
+		if last > 0 {
+			return last, nil
+		}
+	}
+	return 0, nil
+}
+
+// This function removes the empty chunk files and merges runs of
+// neighbouring sparse chunks into a single file. The last chunk is
+// left alone as new records are added to it.
+// Readers are only blocked while the files are replaced.
+func (s *chunkStorage) vacuum(ctx context.Context) (VacuumStats, error) {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	var stats VacuumStats
+	chunks, err := listChunks(s.path)
+	if err != nil || len(chunks) == 0 {
+		return stats, err
+	}
+	before, err := chunkBytes(s.path, chunks)
+	if err != nil {
+		return stats, err
+	}
+
+	var group []chunkFile
+	var records []*models.Record
+	merge := func() error {
+		defer func() { group, records = nil, nil }()
+		if len(group) < 2 {
+			return nil
+		}
+		filename := fmt.Sprintf("%d-%d.json", group[0].start, group[len(group)-1].end)
+
+		s.files.Lock()
+		defer s.files.Unlock()
+		if err := s.replace(filename, records); err != nil {
+			return err
+		}
+		for _, chunk := range group {
+			if err := s.drop(chunk.name); err != nil {
+				return err
+			}
+			stats.FilesRemoved++
+		}
+		stats.FilesRewritten++
+		return nil
+	}
+
+	for _, chunk := range chunks[:len(chunks)-1] {
+		if err := ctx.Err(); err != nil {
+			return stats, err
+		}
+		raws, _, err := scanChunk(filepath.Join(s.path, chunk.name))
+		if err != nil {
+			return stats, err
+		}
+
+		if len(raws) == 0 {
+			s.files.Lock()
+			err := s.drop(chunk.name)
+			s.files.Unlock()
+			if err != nil {
+				return stats, err
+			}
+			stats.FilesRemoved++
+			continue
+		}
+
+		if len(raws) > MAX_CHUNK/2 || len(records)+len(raws) > MAX_CHUNK {
+			if err := merge(); err != nil {
+				return stats, err
+			}
+		}
+		if len(raws) > MAX_CHUNK/2 {
+			continue
+		}
+		decoded, err := decodeRecords(raws)
+		if err != nil {
+			return stats, err
+		}
+		group = append(group, chunk)
+		records = append(records, decoded...)
+	}
+	if err := merge(); err != nil {
+		return stats, err
+	}
+
+	chunks, err = listChunks(s.path)
+	if err != nil {
+		return stats, err
+	}
+	after, err := chunkBytes(s.path, chunks)
+	if err != nil {
+		return stats, err
+	}
+	stats.BytesReclaimed = before - after
+	return stats, nil
+}
+
+// This function removes a chunk file and its offset index.
+// The caller must hold the files lock.
+func (s *chunkStorage) drop(filename string) error {
+	if err := os.Remove(filepath.Join(s.path, filename)); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.offsets, filename)
+	s.chunks = nil
+	return nil
+}
+
+// This function releases the offset index of the chunks.
+func (s *chunkStorage) close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.offsets = make(map[string]*chunkIndex)
+	s.chunks = nil
+	return nil
+}
+
+// This function gets the total size of the chunk files.
+func chunkBytes(path string, chunks []chunkFile) (int64, error) {
+	var size int64
+	for _, chunk := range chunks {
+		info, err := os.Stat(filepath.Join(path, chunk.name))
+		if err != nil {
+			return 0, err
+		}
+		size += info.Size()
+	}
+	return size, nil
+}
+
+// This function decodes the raw records of a chunk.
+func decodeRecords(raws map[int]json.RawMessage) ([]*models.Record, error) {
+	records := make([]*models.Record, 0, len(raws))
+	for _, raw := range raws {
+		record, err := decodeRecord(raw)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+// chunkFile represents a chunk file and its id range.
+type chunkFile struct {
+	name       string
+	start, end int
+}
+
+// This function lists the chunk files of the directory
+// sorted by their id range.
+func listChunks(path string) ([]chunkFile, error) {
+	entries, err := os.ReadDir(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var chunks []chunkFile
+	for _, entry := range entries {
+		match := chunkPattern.FindStringSubmatch(entry.Name())
+		if match == nil || entry.IsDir() {
+			continue
+		}
+		start, _ := strconv.Atoi(match[1])
+		end, _ := strconv.Atoi(match[2])
+		chunks = append(chunks, chunkFile{name: entry.Name(), start: start, end: end})
+	}
+	sort.Slice(chunks, func(i, j int) bool { return chunks[i].start < chunks[j].start })
+	return chunks, nil
+}
+
+// This function returns the chunk filename which the id belongs.
+// example: ID: 3, MAX_CHUNK: 500 -> 1-500.json
+func chunkFilename(id int) string {
+	min, max := utils.GetChunkRange(id, MAX_CHUNK)
+	return fmt.Sprintf("%d-%d.json", min, max)
+}
+
+// This function writes the records to the chunk file as a JSON array
+// and returns the offset index of the written records.
+func writeChunk(path string, records []*models.Record) (*chunkIndex, error) {
+	sort.Slice(records, func(i, j int) bool { return records[i].ID < records[j].ID })
+
+	var buf bytes.Buffer
+	entries := make(map[int]chunkEntry, len(records))
+	buf.WriteByte('[')
+	for i, record := range records {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		data, err := json.Marshal(record)
+		if err != nil {
+			return nil, fmt.Errorf("error encoding data: %v", err)
+		}
+		entries[record.ID] = chunkEntry{offset: int64(buf.Len()), length: int64(len(data))}
+		buf.Write(data)
+	}
+	buf.WriteString("]\n")
+
+	// The chunk is replaced atomically so readers never
+	// see a partially written file.
+	if err := os.WriteFile(path+".tmp", buf.Bytes(), 0644); err != nil {
+		return nil, fmt.Errorf("error creating file: %w", err)
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return nil, fmt.Errorf("error creating file: %w", err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	return &chunkIndex{size: info.Size(), modTime: info.ModTime(), entries: entries}, nil
+}
+
+// This function reads the chunk file and splits it into the raw
+// encoded records without decoding their fields.
+// Returns the raw records by id and the offset index of the file.
+func scanChunk(path string) (map[int]json.RawMessage, *chunkIndex, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error opening file: %w", err)
+	}
+	defer file.Close()
+	return readChunk(file)
+}
+
+// This function splits the opened chunk file into its raw records.
+func readChunk(file *os.File) (map[int]json.RawMessage, *chunkIndex, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return nil, nil, err
+	}
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error reading file: %w", err)
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	if _, err := decoder.Token(); err != nil {
+		return nil, nil, corrupt(err)
+	}
+
+	raws := make(map[int]json.RawMessage)
+	index := &chunkIndex{size: info.Size(), modTime: info.ModTime(), entries: make(map[int]chunkEntry)}
+	for decoder.More() {
+		var raw json.RawMessage
+		if err := decoder.Decode(&raw); err != nil {
+			return nil, nil, corrupt(err)
+		}
+		var header struct {
+			ID int `json:"id"`
+		}
+		if err := json.Unmarshal(raw, &header); err != nil {
+			return nil, nil, corrupt(err)
+		}
+		end := decoder.InputOffset()
+		raws[header.ID] = raw
+		index.entries[header.ID] = chunkEntry{offset: end - int64(len(raw)), length: int64(len(raw))}
+	}
+	return raws, index, nil
+}
+
+// This function decodes an encoded record and marks it as flushed.
+func decodeRecord(data []byte) (*models.Record, error) {
+	var record models.Record
+	if err := json.Unmarshal(data, &record); err != nil {
+		return nil, corrupt(err)
+	}
+	record.Flushed = true
+	return &record, nil
+}
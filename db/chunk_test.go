@@ -0,0 +1,175 @@
+package db
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/OmerMohideen/minibase/logger"
+	"github.com/OmerMohideen/minibase/models"
+)
+
+func TestCollection_LoadRecordOffsetIndex(t *testing.T) {
+	logger, tempDir := logger.New(nil, nil), t.TempDir()
+	collection := NewCollection("test_collection", logger)
+	collection.SetDir(tempDir)
+
+	collection.InsertRecord(&models.Record{Fields: map[string]interface{}{"name": "Sajith", "age": 30}})
+	collection.InsertRecord(&models.Record{Fields: map[string]interface{}{"name": "Mahinda", "age": 35}})
+	collection.InsertRecord(&models.Record{Fields: map[string]interface{}{"name": "Anura", "age": 40}})
+	if err := collection.FlushRecords(); err != nil {
+		t.Fatalf("LoadRecord() failed: Error saving collection data to file: %v", err)
+	}
+
+	newcollection := NewCollection("test_collection", logger)
+	newcollection.SetDir(tempDir)
+
+	if err := newcollection.LoadRecord(2); err != nil {
+		t.Fatalf("LoadRecord() failed: %v", err)
+	}
+	if len(newcollection.records) != 1 {
+		t.Errorf("LoadRecord() failed: Expected only the requested record to be cached, got %d", len(newcollection.records))
+	}
+	if _, ok := newcollection.store.(*chunkStorage).offsets[chunkFilename(2)]; !ok {
+		t.Fatalf("LoadRecord() failed: Offset index of the chunk was not built")
+	}
+
+	if err := newcollection.LoadRecord(3); err != nil {
+		t.Fatalf("LoadRecord() failed: %v", err)
+	}
+	name, _ := newcollection.records[3].GetField("name")
+	if name != "Anura" {
+		t.Errorf("LoadRecord() failed: Expected name to be 'Anura', got '%v'", name)
+	}
+	if newcollection.records[2] == newcollection.records[3] {
+		t.Errorf("LoadRecord() failed: Cached records share the same address")
+	}
+}
+
+func TestCollection_SetPrefetch(t *testing.T) {
+	logger, tempDir := logger.New(nil, nil), t.TempDir()
+	collection := NewCollection("test_collection", logger)
+	collection.SetDir(tempDir)
+
+	for i := 0; i < 3; i++ {
+		collection.InsertRecord(&models.Record{Fields: map[string]interface{}{"age": i}})
+	}
+	if err := collection.FlushRecords(); err != nil {
+		t.Fatalf("SetPrefetch() failed: Error saving collection data to file: %v", err)
+	}
+
+	newcollection := NewCollection("test_collection", logger)
+	newcollection.SetDir(tempDir)
+	newcollection.SetPrefetch(true)
+
+	if err := newcollection.LoadRecord(1); err != nil {
+		t.Fatalf("SetPrefetch() failed: %v", err)
+	}
+	if len(newcollection.records) != 3 {
+		t.Errorf("SetPrefetch() failed: Expected the whole chunk to be cached, got %d records", len(newcollection.records))
+	}
+}
+
+func TestCollection_FlushRecordsKeepsChunk(t *testing.T) {
+	logger, tempDir := logger.New(nil, nil), t.TempDir()
+	collection := NewCollection("test_collection", logger)
+	collection.SetDir(tempDir)
+
+	collection.InsertRecord(&models.Record{Fields: map[string]interface{}{"name": "Sajith"}})
+	collection.InsertRecord(&models.Record{Fields: map[string]interface{}{"name": "Mahinda"}})
+	if err := collection.FlushRecords(); err != nil {
+		t.Fatalf("FlushRecords() failed: %v", err)
+	}
+
+	newcollection := NewCollection("test_collection", logger)
+	newcollection.SetDir(tempDir)
+	newcollection.InsertRecord(&models.Record{Fields: map[string]interface{}{"name": "Anura"}})
+	if err := newcollection.FlushRecords(); err != nil {
+		t.Fatalf("FlushRecords() failed: %v", err)
+	}
+
+	raws, _, err := scanChunk(filepath.Join(tempDir, "test_collection", chunkFilename(1)))
+	if err != nil {
+		t.Fatalf("FlushRecords() failed: %v", err)
+	}
+	if len(raws) != 3 {
+		t.Errorf("FlushRecords() failed: Expected 3 records in the chunk, got %d", len(raws))
+	}
+}
+
+func TestCollection_Vacuum(t *testing.T) {
+	logger, tempDir := logger.New(nil, nil), t.TempDir()
+	collection := NewCollection("test_collection", logger)
+	collection.SetDir(tempDir)
+
+	// Keep every 100th record of the first three chunks
+	// and leave the fourth chunk empty.
+	for i := 0; i < 5*MAX_CHUNK; i++ {
+		collection.InsertRecord(&models.Record{Fields: map[string]interface{}{"age": i}})
+	}
+	for id := 1; id <= 4*MAX_CHUNK; id++ {
+		if id > 3*MAX_CHUNK || id%100 != 0 {
+			delete(collection.records, id)
+		}
+	}
+	if err := collection.FlushRecords(); err != nil {
+		t.Fatalf("FlushRecords() failed: %v", err)
+	}
+	if _, err := writeChunk(filepath.Join(tempDir, "test_collection", chunkFilename(4*MAX_CHUNK)), nil); err != nil {
+		t.Fatalf("writeChunk() failed: %v", err)
+	}
+	collection.Close()
+
+	newcollection := NewCollection("test_collection", logger)
+	newcollection.SetDir(tempDir)
+	defer newcollection.Close()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 50; i++ {
+			if _, err := newcollection.View(1500); err != nil {
+				t.Errorf("View() failed: Record was not readable during vacuum: %v", err)
+				return
+			}
+		}
+	}()
+	stats, err := newcollection.Vacuum()
+	<-done
+	if err != nil {
+		t.Fatalf("Vacuum() failed: %v", err)
+	}
+	if stats.FilesRemoved != 4 || stats.FilesRewritten != 1 || stats.BytesReclaimed <= 0 {
+		t.Errorf("Vacuum() failed: Unexpected stats %+v", stats)
+	}
+
+	chunks, err := listChunks(filepath.Join(tempDir, "test_collection"))
+	if err != nil {
+		t.Fatalf("Vacuum() failed: %v", err)
+	}
+	if len(chunks) != 2 || chunks[0].name != "1-1500.json" {
+		t.Fatalf("Vacuum() failed: Unexpected chunk files %+v", chunks)
+	}
+
+	if err := newcollection.UpdateRecord(300, &models.Record{Fields: map[string]interface{}{"age": 1000}}); err != nil {
+		t.Fatalf("UpdateRecord() failed: %v", err)
+	}
+	if err := newcollection.FlushRecords(); err != nil {
+		t.Fatalf("FlushRecords() failed: %v", err)
+	}
+
+	reopened := NewCollection("test_collection", logger)
+	reopened.SetDir(tempDir)
+	defer reopened.Close()
+	if reopened.nextID != 5*MAX_CHUNK+1 {
+		t.Errorf("Vacuum() failed: Expected next id %d, got %d", 5*MAX_CHUNK+1, reopened.nextID)
+	}
+	for _, id := range []int{100, 1500, 2001} {
+		if _, err := reopened.GetRecordByID(id); err != nil {
+			t.Errorf("Vacuum() failed: Record %d is not readable: %v", id, err)
+		}
+	}
+	record, _ := reopened.GetRecordByID(300)
+	if age, _ := record.GetField("age"); age != int64(1000) {
+		t.Errorf("Vacuum() failed: Update of a merged chunk was lost, got age %v", age)
+	}
+}
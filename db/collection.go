@@ -4,17 +4,17 @@
 package db
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
 	"os"
 	"path/filepath"
-	"strconv"
+	"sort"
 	"sync"
 	"time"
 
+	"github.com/OmerMohideen/minibase/btree"
 	l "github.com/OmerMohideen/minibase/logger"
 	"github.com/OmerMohideen/minibase/models"
-	"github.com/OmerMohideen/minibase/utils"
 )
 
 const (
@@ -23,16 +23,54 @@ const (
 	MAX_CHUNK = 500
 	// Life span of the cached data stored
 	LIFE_SPAN = time.Second * 30
+	// Interval between the deletions of the expired records.
+	REAP_INTERVAL = time.Minute
 )
 
 // Collection represents a collection in the database.
 type Collection struct {
-	mu      sync.Mutex
-	name    string
-	dir     string
-	records map[int]*models.Record
-	logger  *l.Logger
-	nextID  int
+	mu         sync.Mutex
+	name       string
+	dir        string
+	records    map[int]*models.Record
+	logger     *l.Logger
+	nextID     int
+	mode       StorageMode
+	logOpts    LogOptions
+	treeOpts   btree.Options
+	store      storage
+	prefetch   bool
+	schema     *models.Schema
+	validation ValidationMode
+	migrations []Migration
+	clock      func() time.Time
+	// Keys of the records unless the key strategy is auto.
+	keyStrategy KeyStrategy
+	keys        *keyIndex
+	// Time and entropy of the last generated ULID.
+	ulidTime    int64
+	ulidEntropy [10]byte
+	// Version all saved records have been migrated to.
+	schemaVersion int
+	// Sequence of the last change, the changes waiting to be
+	// saved, the change feed and the watchers of the changes.
+	seq      uint64
+	pending  []pendingChange
+	feedOpts ChangeFeedOptions
+	feed     *changeFeed
+	watchers map[*Watcher]struct{}
+	// Audit trail of the changes.
+	auditOpts AuditOptions
+	audit     *auditLog
+	// Versions of the records.
+	historyOpts HistoryOptions
+	history     *historyLog
+	// Trash of the soft deleted records.
+	softDelete SoftDeleteOptions
+	// Hooks called before and after the operations.
+	beforeHooks map[HookEvent][]BeforeHook
+	afterHooks  map[HookEvent][]AfterHook
+	done        chan struct{}
 }
 
 // This function creates a new collection.
@@ -44,11 +82,16 @@ func NewCollection(name string, logger *l.Logger) *Collection {
 		records: make(map[int]*models.Record),
 		logger:  logger,
 		nextID:  1,
+		logOpts: DefaultLogOptions(),
+		clock:   time.Now,
+		keys:    newKeyIndex(),
+		done:    make(chan struct{}),
 	}
 	dir, _ := os.Getwd()
 	collection.SetDir(dir)
 
 	go collection.cleanCollection(LIFE_SPAN)
+	go collection.reapCollection(REAP_INTERVAL)
 
 	return &collection
 }
@@ -56,7 +99,12 @@ func NewCollection(name string, logger *l.Logger) *Collection {
 func (c *Collection) cleanCollection(interval time.Duration) {
 	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
-	for range ticker.C {
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+		}
 		c.mu.Lock()
 		for key, record := range c.records {
 			if time.Now().After(record.ExpiresAt) && record.Flushed {
@@ -71,43 +119,243 @@ func (c *Collection) cleanCollection(interval time.Duration) {
 // Use this function to open an existing collection or make one
 // in a specific directory.
 func (c *Collection) SetDir(dir string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
 	c.dir = dir
-	c.loadNextId()
+	if err := c.openStorage(); err != nil {
+		c.logger.Error("error opening collection '%s': %v", c.name, err)
+	}
 }
 
-// This function loads the next ID from
-// the latest JSON file in the collection directory.
-func (c *Collection) loadNextId() error {
+// This function changes how the collection saves its records.
+// An existing collection keeps the storage mode it was created
+// with, an error is returned when a different mode is requested.
+func (c *Collection) SetStorageMode(mode StorageMode) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
 	path := filepath.Join(c.dir, c.name)
-	files, err := os.ReadDir(path)
+	meta, err := readMetadata(path)
 	if err != nil {
 		return err
 	}
+	current := ChunkStorage
+	if meta != nil {
+		current = meta.Storage
+	}
+	if mode != current {
+		chunks, err := listChunks(path)
+		if err != nil {
+			return err
+		}
+		if meta != nil || len(chunks) > 0 {
+			return fmt.Errorf("collection '%s' already uses %s storage: %w", c.name, current, ErrConflict)
+		}
+	}
+
+	c.mode = mode
+	return c.openStorage()
+}
+
+// This function updates the settings of the log-structured storage.
+func (c *Collection) SetLogOptions(opts LogOptions) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 
-	if len(files) == 0 {
-		c.nextID = 1
+	c.logOpts = opts
+	if c.mode != LogStorage {
 		return nil
 	}
+	return c.openStorage()
+}
+
+// This function updates the settings of the B+tree storage.
+func (c *Collection) SetTreeOptions(opts btree.Options) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.treeOpts = opts
+	if c.mode != BTreeStorage {
+		return nil
+	}
+	return c.openStorage()
+}
+
+// This function opens the storage of the collection directory
+// and loads the next ID from it.
+// The caller must hold the lock.
+func (c *Collection) openStorage() error {
+	if c.store != nil {
+		c.store.close()
+	}
+	// Until the storage is open its operations fail with the
+	// error which kept it closed.
+	unavailable := func(err error) error {
+		c.store = unavailableStorage{fmt.Errorf("collection '%s' is not open: %w", c.name, err)}
+		return err
+	}
+	c.store = unavailableStorage{fmt.Errorf("collection '%s' is not open", c.name)}
+
+	path := filepath.Join(c.dir, c.name)
+	meta, err := readMetadata(path)
+	if err != nil {
+		return unavailable(err)
+	}
+	if meta != nil {
+		c.mode = meta.Storage
+		c.schema, c.validation = meta.Schema, meta.Validation
+		c.schemaVersion = meta.SchemaVersion
+		c.keyStrategy = meta.KeyStrategy
+		c.feedOpts = ChangeFeedOptions{}
+		if meta.ChangeFeed != nil {
+			c.feedOpts = *meta.ChangeFeed
+		}
+		c.auditOpts = AuditOptions{}
+		if meta.Audit != nil {
+			c.auditOpts = *meta.Audit
+		}
+		c.historyOpts = HistoryOptions{}
+		if meta.History != nil {
+			c.historyOpts = *meta.History
+		}
+		c.softDelete = SoftDeleteOptions{}
+		if meta.SoftDelete != nil {
+			c.softDelete = *meta.SoftDelete
+		}
+	}
 
-	file, err := os.Open(filepath.Join(path, files[len(files)-1].Name()))
+	store, err := openStorage(c.mode, path, c.logOpts, c.treeOpts, c.logger)
+	if err != nil {
+		return unavailable(err)
+	}
+	last, err := store.lastID()
 	if err != nil {
-		return fmt.Errorf("error opening file: %v", err)
+		store.close()
+		return unavailable(err)
 	}
-	defer file.Close()
+	c.store = store
+	c.nextID = last + 1
 
-	decoder := json.NewDecoder(file)
-	var records []models.Record
-	if err := decoder.Decode(&records); err != nil {
-		return fmt.Errorf("error decoding data: %v", err)
+	if err := c.openChangeFeed(); err != nil {
+		return err
+	}
+	if err := c.openAuditLog(); err != nil {
+		return err
+	}
+	if err := c.openHistory(); err != nil {
+		return err
 	}
 
-	if len(records) == 0 {
-		c.nextID = 1
+	// The key index is built by the first lookup of a key.
+	c.keys = newKeyIndex()
+	return nil
+}
+
+// This function sets the clock giving the timestamps of the
+// records. Tests can use it to control the time, nil restores
+// the system clock.
+func (c *Collection) SetClock(clock func() time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if clock == nil {
+		clock = time.Now
+	}
+	c.clock = clock
+}
+
+// This function gets the current time of the clock in UTC.
+// The caller must hold the lock.
+func (c *Collection) now() time.Time {
+	return c.clock().UTC().Round(0)
+}
+
+// This function enables warming the cache with the neighbouring
+// records of a chunk whenever the whole chunk had to be decoded.
+func (c *Collection) SetPrefetch(enabled bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.prefetch = enabled
+}
+
+// This function compacts the log segments whose reclaimable
+// ratio reached the compaction threshold and prunes the history
+// of the records by its retention.
+// Segments are only compacted when the collection uses log storage.
+func (c *Collection) Compact() error {
+	return c.CompactContext(context.Background())
+}
+
+// This function is Compact with a context, the compaction
+// stops between segments when the context is done.
+func (c *Collection) CompactContext(ctx context.Context) error {
+	if err := c.ready(ctx); err != nil {
+		return err
+	}
+	if _, err := c.PruneHistory(); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	store, ok := c.store.(*logStorage)
+	c.mu.Unlock()
+	if !ok {
 		return nil
 	}
+	_, _, err := store.compact(ctx, false)
+	return err
+}
 
-	c.nextID = records[len(records)-1].ID + 1
-	return nil
+// This function reclaims the space left by deleted records.
+// Chunk storage removes the empty chunk files and merges sparse
+// ones, log storage compacts every segment and B+tree storage
+// rebuilds its file. It can run while the collection serves reads,
+// records in the memory are not affected.
+func (c *Collection) Vacuum() (VacuumStats, error) {
+	return c.VacuumContext(context.Background())
+}
+
+// This function is Vacuum with a context, it stops between
+// files when the context is done. Files replaced before are kept.
+func (c *Collection) VacuumContext(ctx context.Context) (VacuumStats, error) {
+	if err := c.ready(ctx); err != nil {
+		return VacuumStats{}, err
+	}
+	c.mu.Lock()
+	store := c.store
+	c.mu.Unlock()
+	return store.vacuum(ctx)
+}
+
+// This function gets the statistics of the log compaction.
+func (c *Collection) CompactionStats() CompactionStats {
+	c.mu.Lock()
+	store, ok := c.store.(*logStorage)
+	c.mu.Unlock()
+	if !ok {
+		return CompactionStats{}
+	}
+	return store.compactionStats()
+}
+
+// This function stops the background work of the collection
+// and releases its files. Unflushed records are not saved.
+// Operations on a closed collection fail with ErrClosed.
+func (c *Collection) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	select {
+	case <-c.done:
+		return nil
+	default:
+		close(c.done)
+	}
+	err := c.store.close()
+	closed := fmt.Errorf("collection '%s': %w", c.name, ErrClosed)
+	c.store = unavailableStorage{closed}
+	c.closeChanges(closed)
+	return err
 }
 
 // This function gets all records from the collection
@@ -119,30 +367,132 @@ func (c *Collection) GetRecords() map[int]*models.Record {
 // This function inserts a record into the collection.
 // Note that this is saved in the memory and it is required
 // to flush the records in order to save them.
-func (c *Collection) InsertRecord(record *models.Record) {
+// Field values are converted to the types they are loaded as.
+// The record is checked against the schema of the collection.
+// A record with an ExpireAt is deleted once it has expired.
+func (c *Collection) InsertRecord(record *models.Record) error {
+	return c.InsertRecordContext(context.Background(), record)
+}
+
+// This function is InsertRecord with a context.
+func (c *Collection) InsertRecordContext(ctx context.Context, record *models.Record) error {
+	if err := c.ready(ctx); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.insertRecord(ctx, c.nextID, record)
+}
+
+// This function inserts a record with the id, later records get
+// ids after it.
+// The caller must hold the lock.
+func (c *Collection) insertRecord(ctx context.Context, id int, record *models.Record) error {
+	if err := record.Normalize(); err != nil {
+		return invalid(err)
+	}
+	record.ID = id
+	if err := c.runBefore(ctx, HookInsert, record); err != nil {
+		return err
+	}
+	if err := c.assignKey(ctx, record, nil); err != nil {
+		return err
+	}
+	if err := c.validate(id, record); err != nil {
+		return err
+	}
+	c.addRecord(ctx, id, record)
+	c.runAfter(ctx, HookInsert, record)
+	return nil
+}
+
+// This function inserts the records at once, every record is
+// checked before any is inserted so either all or none of them
+// are. The ids are set on the records.
+// Like InsertRecord they are saved by the next flush.
+func (c *Collection) InsertMany(records []*models.Record) error {
+	return c.InsertManyContext(context.Background(), records)
+}
+
+// This function is InsertMany with a context.
+func (c *Collection) InsertManyContext(ctx context.Context, records []*models.Record) error {
+	if err := c.ready(ctx); err != nil {
+		return err
+	}
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	record.ID = c.nextID
+	keys := make(map[string]bool)
+	for i, record := range records {
+		if err := record.Normalize(); err != nil {
+			return fmt.Errorf("record %d: %w", i, invalid(err))
+		}
+		record.ID = c.nextID + i
+		if err := c.runBefore(ctx, HookInsert, record); err != nil {
+			return fmt.Errorf("record %d: %w", i, err)
+		}
+		if err := c.assignKey(ctx, record, keys); err != nil {
+			return fmt.Errorf("record %d: %w", i, err)
+		}
+		if err := c.validate(c.nextID+i, record); err != nil {
+			return fmt.Errorf("record %d: %w", i, err)
+		}
+	}
+	for _, record := range records {
+		c.addRecord(ctx, c.nextID, record)
+	}
+	for _, record := range records {
+		c.runAfter(ctx, HookInsert, record)
+	}
+	return nil
+}
+
+// This function adds a checked record with the id to the memory.
+// The caller must hold the lock.
+func (c *Collection) addRecord(ctx context.Context, id int, record *models.Record) {
+	record.ID = id
+	record.SchemaVersion = c.targetVersion()
+	record.CreatedAt = c.now()
+	record.UpdatedAt = record.CreatedAt
+	if !record.ExpireAt.IsZero() {
+		record.ExpireAt = record.ExpireAt.UTC().Round(0)
+	}
 	record.ExpiresAt = time.Now().Add(LIFE_SPAN)
 	record.Flushed = false
-	c.records[c.nextID] = record
-	c.nextID++
+	c.records[id] = record
+	c.keys.add(record)
+	if id >= c.nextID {
+		c.nextID = id + 1
+	}
+	c.changed(ctx, ChangeInsert, id, nil, record)
 }
 
 // This function gets the record by its id if available
 // in the memory or pulls from the storage and caches it.
+// A deleted or expired record is not returned.
 func (c *Collection) GetRecordByID(id int) (*models.Record, error) {
+	return c.GetRecordByIDContext(context.Background(), id)
+}
+
+// This function is GetRecordByID with a context.
+func (c *Collection) GetRecordByIDContext(ctx context.Context, id int) (*models.Record, error) {
+	if err := c.ready(ctx); err != nil {
+		return nil, err
+	}
 	c.mu.Lock()
 	record, ok := c.records[id]
+	now := c.now()
 	c.mu.Unlock()
 	if ok {
+		if record.Hidden(now) {
+			return nil, hiddenError(record)
+		}
 		record.ExpiresAt = time.Now().Add(LIFE_SPAN)
 		return record, nil
 	}
 
-	if err := c.LoadRecord(id); err != nil {
-		return nil, fmt.Errorf("error loading record: %v", err)
+	if err := c.LoadRecordContext(ctx, id); err != nil {
+		return nil, fmt.Errorf("error loading record: %w", err)
 	}
 
 	c.mu.Lock()
@@ -150,105 +500,251 @@ func (c *Collection) GetRecordByID(id int) (*models.Record, error) {
 
 	record, ok = c.records[id]
 	if !ok {
-		return nil, fmt.Errorf("record with ID '%d' not found even after loading", id)
+		return nil, fmt.Errorf("record with ID '%d' %w even after loading", id, ErrNotFound)
+	}
+	if record.Hidden(c.now()) {
+		return nil, hiddenError(record)
 	}
 	record.ExpiresAt = time.Now().Add(LIFE_SPAN)
 	return record, nil
 }
 
+// This function gets the records with the ids in their order
+// and the ids which don't exist, are deleted or have expired. Records which
+// are not in the memory are loaded together, every chunk file
+// is read at most once, and cached.
+func (c *Collection) GetMany(ids []int) ([]*models.Record, []int, error) {
+	return c.GetManyContext(context.Background(), ids)
+}
+
+// This function is GetMany with a context, loading the
+// records stops between chunk files when the context is done.
+func (c *Collection) GetManyContext(ctx context.Context, ids []int) ([]*models.Record, []int, error) {
+	if err := c.ready(ctx); err != nil {
+		return nil, nil, err
+	}
+	c.mu.Lock()
+	store := c.store
+	var load []int
+	for _, id := range ids {
+		if _, ok := c.records[id]; !ok {
+			load = append(load, id)
+		}
+	}
+	c.mu.Unlock()
+
+	var loaded map[int]*models.Record
+	if len(load) > 0 {
+		var err error
+		if loaded, err = store.loadMany(ctx, load); err != nil {
+			return nil, nil, fmt.Errorf("error loading records: %w", err)
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, record := range loaded {
+		if err := c.cacheRecord(ctx, record); err != nil {
+			return nil, nil, err
+		}
+	}
+	now, expiresAt := c.now(), time.Now().Add(LIFE_SPAN)
+	records := make([]*models.Record, 0, len(ids))
+	var missing []int
+	for _, id := range ids {
+		record, ok := c.records[id]
+		if !ok || record.Hidden(now) {
+			missing = append(missing, id)
+			continue
+		}
+		record.ExpiresAt = expiresAt
+		records = append(records, record)
+	}
+	return records, missing, nil
+}
+
 // This function updates a record in the collection.
 // This only updates the record in memory and it is
 // required to flush the records in order to save.
+// The record keeps its expiry unless the new one has an ExpireAt.
 func (c *Collection) UpdateRecord(id int, newRecord *models.Record) error {
+	return c.UpdateRecordContext(context.Background(), id, newRecord)
+}
+
+// This function is UpdateRecord with a context.
+func (c *Collection) UpdateRecordContext(ctx context.Context, id int, newRecord *models.Record) error {
+	if err := c.ready(ctx); err != nil {
+		return err
+	}
+	if err := newRecord.Normalize(); err != nil {
+		return invalid(err)
+	}
+	newRecord.ID = id
+	newRecord.Flushed = false
+
 	c.mu.Lock()
 	_, ok := c.records[id]
 	c.mu.Unlock()
+	if !ok {
+		if err := c.LoadRecordContext(ctx, id); err != nil {
+			return fmt.Errorf("error loading record: %w", err)
+		}
+	}
 
-	newRecord.ID = id
-	if ok {
-		newRecord.ExpiresAt = time.Now().Add(LIFE_SPAN)
-		c.records[id] = newRecord
-		return nil
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.updateRecord(ctx, id, newRecord)
+}
+
+// This function replaces the record with the id.
+// The caller must hold the lock.
+func (c *Collection) updateRecord(ctx context.Context, id int, newRecord *models.Record) error {
+	oldRecord, err := c.liveRecord(ctx, id)
+	if err != nil {
+		return err
+	}
+	if oldRecord == nil {
+		return fmt.Errorf("record with ID '%d' does not exist: %w", id, ErrNotFound)
 	}
+	return c.replaceRecord(ctx, ChangeUpdate, oldRecord, newRecord)
+}
 
-	if err := c.LoadRecord(id); err != nil {
-		return fmt.Errorf("error loading record: %v", err)
+// This function gets the record with the id from the memory or
+// loads and caches it. Nil is returned when the record doesn't
+// exist, is deleted or has expired.
+// The caller must hold the lock.
+func (c *Collection) liveRecord(ctx context.Context, id int) (*models.Record, error) {
+	if _, ok := c.records[id]; !ok {
+		record, err := c.store.load(id, nil)
+		if err != nil {
+			return nil, fmt.Errorf("error loading record: %w", err)
+		}
+		if record == nil {
+			return nil, nil
+		}
+		if err := c.cacheRecord(ctx, record); err != nil {
+			return nil, err
+		}
+	}
+	record := c.records[id]
+	if record.Hidden(c.now()) {
+		return nil, nil
 	}
+	return record, nil
+}
 
-	c.mu.Lock()
-	defer c.mu.Unlock()
+// This function validates the new version of a record and
+// replaces the old one in the memory with it. The change is an
+// update or a patch, the new version keeps the expiry of the old
+// one unless it has an ExpireAt.
+// The caller must hold the lock.
+func (c *Collection) replaceRecord(ctx context.Context, kind ChangeKind, oldRecord, newRecord *models.Record) error {
+	if newRecord.ExpireAt.IsZero() {
+		newRecord.ExpireAt = oldRecord.ExpireAt
+	}
+	return c.reviseRecord(ctx, kind, oldRecord, newRecord)
+}
 
-	_, ok = c.records[id]
-	if !ok {
-		return fmt.Errorf("record with ID '%d' does not exist", id)
+// This function is replaceRecord where the new version has its
+// own expiry.
+// The caller must hold the lock.
+func (c *Collection) reviseRecord(ctx context.Context, kind ChangeKind, oldRecord, newRecord *models.Record) error {
+	newRecord.ID, newRecord.Key = oldRecord.ID, oldRecord.Key
+	if err := c.runBefore(ctx, HookUpdate, newRecord); err != nil {
+		return err
+	}
+	if err := c.validate(oldRecord.ID, newRecord); err != nil {
+		return err
+	}
+	newRecord.Flushed = false
+	newRecord.SchemaVersion = c.targetVersion()
+	newRecord.CreatedAt, newRecord.UpdatedAt = oldRecord.CreatedAt, c.now()
+	if !newRecord.ExpireAt.IsZero() {
+		newRecord.ExpireAt = newRecord.ExpireAt.UTC().Round(0)
 	}
 	newRecord.ExpiresAt = time.Now().Add(LIFE_SPAN)
-	c.records[id] = newRecord
+	c.records[newRecord.ID] = newRecord
+	c.changed(ctx, kind, newRecord.ID, oldRecord, newRecord)
+	c.runAfter(ctx, HookUpdate, newRecord)
 	return nil
 }
 
 // This function deletes a record from the collection.
 // It deletes the record from the cache if exists and
-// from the storage as well.
+// from the storage as well. With soft deletes the record
+// is moved to the trash instead, see SetSoftDelete.
 func (c *Collection) DeleteRecord(id int) error {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-	_, ok := c.records[id]
+	return c.DeleteRecordContext(context.Background(), id)
+}
 
-	if ok {
-		delete(c.records, id)
+// This function is DeleteRecord with a context.
+func (c *Collection) DeleteRecordContext(ctx context.Context, id int) error {
+	if err := c.ready(ctx); err != nil {
+		return err
 	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.deleteRecord(ctx, id)
+}
 
-	path := filepath.Join(c.dir, c.name)
-	if _, err := os.Stat(path); os.IsNotExist(err) {
-		return nil
+// This function deletes the record with the id.
+// The caller must hold the lock.
+func (c *Collection) deleteRecord(ctx context.Context, id int) error {
+	if c.softDelete.Enabled {
+		return c.trashRecord(ctx, id)
 	}
-
-	min, max := utils.GetChunkRange(id, MAX_CHUNK)
-	filename := fmt.Sprintf("%d-%d.json", min, max)
-	file, err := os.Open(filepath.Join(c.dir, c.name, filename))
-	if err != nil {
-		return fmt.Errorf("error opening file: %v", err)
-	}
-	defer file.Close()
-
-	decoder := json.NewDecoder(file)
-	var records []models.Record
-	if err := decoder.Decode(&records); err != nil {
-		return fmt.Errorf("error decoding data: %v", err)
+	before, ok := c.records[id]
+	if !ok && (c.watched() || c.hooked(HookDelete)) {
+		stored, err := c.store.load(id, nil)
+		if err != nil {
+			return fmt.Errorf("error loading record: %w", err)
+		}
+		before = stored
 	}
-
-	var updatedRecords []models.Record
-	found := false
-	for _, record := range records {
-		if record.ID == id {
-			found = true
-		} else {
-			updatedRecords = append(updatedRecords, record)
+	if before != nil {
+		if err := c.runBefore(ctx, HookDelete, before); err != nil {
+			return err
 		}
 	}
 
-	if !found {
-		return fmt.Errorf("record with ID %d not found", id)
+	if ok {
+		delete(c.records, id)
 	}
 
-	file, err = os.OpenFile(filepath.Join(c.dir, c.name, filename), os.O_WRONLY|os.O_TRUNC, 0777)
+	found, err := c.store.remove(id)
 	if err != nil {
-		return fmt.Errorf("error opening file: %v", err)
+		return err
 	}
-	defer file.Close()
-
-	encoder := json.NewEncoder(file)
-	if err := encoder.Encode(updatedRecords); err != nil {
-		return fmt.Errorf("error encoding data: %v", err)
+	c.keys.remove(id)
+	if !found && !ok {
+		return fmt.Errorf("record with ID %d %w", id, ErrNotFound)
 	}
-	return nil
+	// The record is removed from the storage so the change
+	// and the queued ones of the record are saved right away.
+	c.changed(ctx, ChangeDelete, id, before, nil)
+	committed := c.commitRecord(id)
+	if before != nil {
+		c.runAfter(ctx, HookDelete, before)
+	}
+	return committed
 }
 
 // This function saves the collection data to the storage.
 // It partitiones the record based on its id and uses MAX_CHUNK
 // as the maximum records limited to save per JSON file.
 func (c *Collection) FlushRecords() error {
+	return c.FlushRecordsContext(context.Background())
+}
+
+// This function is FlushRecords with a context. The flush
+// stops between chunk files when the context is done, records
+// saved before stay saved and all of them are saved again by
+// the next flush.
+func (c *Collection) FlushRecordsContext(ctx context.Context) error {
+	if err := c.ready(ctx); err != nil {
+		return err
+	}
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
@@ -261,85 +757,194 @@ func (c *Collection) FlushRecords() error {
 		}
 	}
 
-	chunks := utils.ChunkMap(c.records, MAX_CHUNK)
-	max := MAX_CHUNK
-	min := 1
-	for _, chunk := range chunks {
-		for _, record := range chunk {
-			record.Flushed = true
-		}
-		filename := fmt.Sprintf("%d-%d.json", min, max)
-		file, err := os.Create(filepath.Join(c.dir, c.name, filename))
-		if err != nil {
-			return fmt.Errorf("error creating file: %v", err)
+	meta, err := readMetadata(path)
+	if err != nil {
+		return err
+	}
+	if meta == nil {
+		if err := writeMetadata(path, c.metadata()); err != nil {
+			return err
 		}
-		defer file.Close()
+	}
 
-		encoder := json.NewEncoder(file)
-		if err := encoder.Encode(chunk); err != nil {
-			return fmt.Errorf("error encoding data: %v", err)
+	var changed []*models.Record
+	if c.hooked(HookFlush) {
+		for _, record := range c.records {
+			if !record.Flushed {
+				changed = append(changed, record)
+			}
+		}
+		sort.Slice(changed, func(i, j int) bool { return changed[i].ID < changed[j].ID })
+		for _, record := range changed {
+			if err := c.runBefore(ctx, HookFlush, record); err != nil {
+				return err
+			}
 		}
-
-		max += MAX_CHUNK
-		min += MAX_CHUNK
 	}
 
-	return nil
+	if err := c.store.write(ctx, c.records); err != nil {
+		return err
+	}
+	for _, record := range c.records {
+		record.Flushed = true
+	}
+	for _, record := range changed {
+		c.runAfter(ctx, HookFlush, record)
+	}
+	committed := c.commitChanges(func(ChangeEvent) bool { return true })
+	if c.feed != nil {
+		if err := c.feed.sync(); err != nil {
+			return err
+		}
+	}
+	if c.audit != nil {
+		if err := c.audit.sync(); err != nil {
+			return err
+		}
+	}
+	if c.history != nil {
+		if err := c.history.sync(); err != nil {
+			return err
+		}
+	}
+	return committed
 }
 
 // This function loads the specified record using its id
 // from the storage to the memory.
 func (c *Collection) LoadRecord(id int) error {
+	return c.LoadRecordContext(context.Background(), id)
+}
+
+// This function is LoadRecord with a context.
+func (c *Collection) LoadRecordContext(ctx context.Context, id int) error {
+	if err := c.ready(ctx); err != nil {
+		return err
+	}
 	c.mu.Lock()
 	_, exists := c.records[id]
+	store, prefetch := c.store, c.prefetch
 	c.mu.Unlock()
 	if exists {
 		return nil
 	}
 
-	path := filepath.Join(c.dir, c.name)
-	if _, err := os.Stat(path); os.IsNotExist(err) {
-		if err := os.Mkdir(path, 0755); err != nil {
-			return err
+	var neighbours []*models.Record
+	var warm func(*models.Record)
+	if prefetch {
+		warm = func(record *models.Record) {
+			neighbours = append(neighbours, record)
 		}
 	}
 
-	min, max := utils.GetChunkRange(id, MAX_CHUNK)
-	filename := fmt.Sprintf("%d-%d.json", min, max)
-	file, err := os.Open(filepath.Join(c.dir, c.name, filename))
+	record, err := store.load(id, warm)
 	if err != nil {
-		return fmt.Errorf("error opening file: %v", err)
+		return err
 	}
-	defer file.Close()
 
-	decoder := json.NewDecoder(file)
-	var records []models.Record
-	if err := decoder.Decode(&records); err != nil {
-		return fmt.Errorf("error decoding data: %v", err)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if record != nil {
+		if err := c.cacheRecord(ctx, record); err != nil {
+			return err
+		}
 	}
+	for _, neighbour := range neighbours {
+		if err := c.cacheRecord(ctx, neighbour); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// This function calls fn with the records whose id is between
+// from and to in order, both are inclusive. Records in the memory
+// are preferred over their saved version. Records read from the
+// storage are not cached but upgraded by the registered migrations.
+// Expired records are skipped. The scan stops when fn returns false.
+func (c *Collection) ScanRange(from, to int, fn func(*models.Record) bool) error {
+	return c.ScanRangeContext(context.Background(), from, to, fn)
+}
 
+// This function is ScanRange with a context, the scan
+// stops between records when the context is done.
+func (c *Collection) ScanRangeContext(ctx context.Context, from, to int, fn func(*models.Record) bool) error {
+	if err := c.ready(ctx); err != nil {
+		return err
+	}
 	c.mu.Lock()
-	defer c.mu.Unlock()
+	store := c.store
+	migrations, target := c.migrations, c.targetVersion()
+	now := c.now()
+	var cached []*models.Record
+	for id, record := range c.records {
+		if id >= from && id <= to {
+			cached = append(cached, record)
+		}
+	}
+	c.mu.Unlock()
+	sort.Slice(cached, func(i, j int) bool { return cached[i].ID < cached[j].ID })
 
-	// Convert float64 fields to integers
-	for _, record := range records {
-		record.Flushed = true
-		for name, value := range record.Fields {
-			if floatValue, ok := value.(float64); ok {
-				intValue, err := strconv.Atoi(fmt.Sprintf("%.0f", floatValue))
-				if err != nil {
-					return fmt.Errorf("error converting float64 to int for field %s: %v", name, err)
-				}
-				record.Fields[name] = intValue
-			}
+	emit := func(record *models.Record) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if record.Hidden(now) {
+			return nil
 		}
+		if !fn(record) {
+			return errStopScan
+		}
+		return nil
 	}
 
-	for _, r := range records {
-		if id == r.ID {
-			c.records[id] = &r
-			break
+	i := 0
+	err := store.scan(from, to, func(record *models.Record) error {
+		id := record.ID
+		for ; i < len(cached) && cached[i].ID <= id; i++ {
+			if cached[i].ID == id {
+				record = nil
+			}
+			if err := emit(cached[i]); err != nil {
+				return err
+			}
+		}
+		if record == nil {
+			return nil
 		}
+		if record.SchemaVersion < target {
+			if err := upgradeRecord(migrations, target, record); err != nil {
+				return err
+			}
+		}
+		return emit(record)
+	})
+	for ; err == nil && i < len(cached); i++ {
+		err = emit(cached[i])
+	}
+	if err == errStopScan {
+		return nil
 	}
+	return err
+}
+
+// This function caches a loaded record unless the
+// memory already holds a newer version of it.
+// Records older than the migrations are upgraded first.
+// The caller must hold the lock.
+func (c *Collection) cacheRecord(ctx context.Context, record *models.Record) error {
+	if _, ok := c.records[record.ID]; ok {
+		return nil
+	}
+	if err := c.upgradeLoaded(ctx, record); err != nil {
+		return err
+	}
+	if err := c.runBefore(ctx, HookLoad, record); err != nil {
+		return err
+	}
+	record.ExpiresAt = time.Now().Add(LIFE_SPAN)
+	c.records[record.ID] = record
+	c.runAfter(ctx, HookLoad, record)
 	return nil
 }
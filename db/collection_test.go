@@ -1,6 +1,8 @@
 package db
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"reflect"
 	"testing"
@@ -147,6 +149,231 @@ func TestCollection_LoadRecord(t *testing.T) {
 	}
 }
 
+func TestCollection_LoadRecordTypes(t *testing.T) {
+	created := time.Date(2024, 3, 1, 12, 30, 0, 0, time.UTC)
+	fields := map[string]interface{}{
+		"score":   3.75,
+		"whole":   2.0,
+		"big":     int64(9007199254740993),
+		"created": created,
+		"avatar":  []byte("avatar"),
+		"address": map[string]interface{}{"zip": 10100},
+	}
+	for _, mode := range []StorageMode{ChunkStorage, LogStorage, BTreeStorage} {
+		logger, tempDir := logger.New(nil, nil), t.TempDir()
+		collection := NewCollection("test_collection", logger)
+		collection.SetDir(tempDir)
+		collection.SetStorageMode(mode)
+		collection.InsertRecord(&models.Record{Fields: fields})
+		if err := collection.FlushRecords(); err != nil {
+			t.Fatalf("FlushRecords() failed: %v", err)
+		}
+		collection.Close()
+
+		newcollection := NewCollection("test_collection", logger)
+		newcollection.SetDir(tempDir)
+		record, err := newcollection.GetRecordByID(1)
+		if err != nil {
+			t.Fatalf("GetRecordByID() failed: %v", err)
+		}
+		expected := map[string]interface{}{
+			"score":   3.75,
+			"whole":   2.0,
+			"big":     int64(9007199254740993),
+			"created": created,
+			"avatar":  []byte("avatar"),
+			"address": map[string]interface{}{"zip": int64(10100)},
+		}
+		if !reflect.DeepEqual(record.Fields, expected) {
+			t.Errorf("GetRecordByID() failed: %s storage loaded %#v", mode, record.Fields)
+		}
+		newcollection.Close()
+	}
+}
+
+func TestCollection_Timestamps(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	clock := func() time.Time { return now }
+	logger, tempDir := logger.New(nil, nil), t.TempDir()
+	collection := NewCollection("test_collection", logger)
+	collection.SetDir(tempDir)
+	collection.SetClock(clock)
+
+	created := now
+	collection.InsertRecord(&models.Record{Fields: map[string]interface{}{"name": "Sajith"}})
+	if err := collection.FlushRecords(); err != nil {
+		t.Fatalf("FlushRecords() failed: %v", err)
+	}
+	collection.Close()
+
+	newcollection := NewCollection("test_collection", logger)
+	newcollection.SetDir(tempDir)
+	newcollection.SetClock(clock)
+	defer newcollection.Close()
+	record, err := newcollection.GetRecordByID(1)
+	if err != nil {
+		t.Fatalf("GetRecordByID() failed: %v", err)
+	}
+	if !record.CreatedAt.Equal(created) || !record.UpdatedAt.Equal(created) {
+		t.Errorf("GetRecordByID() failed: got timestamps %v and %v", record.CreatedAt, record.UpdatedAt)
+	}
+
+	now = now.Add(time.Hour)
+	if err := newcollection.UpdateRecord(1, &models.Record{Fields: map[string]interface{}{"name": "Anura"}}); err != nil {
+		t.Fatalf("UpdateRecord() failed: %v", err)
+	}
+	record, _ = newcollection.GetRecordByID(1)
+	if !record.CreatedAt.Equal(created) || !record.UpdatedAt.Equal(now) {
+		t.Errorf("UpdateRecord() failed: got timestamps %v and %v", record.CreatedAt, record.UpdatedAt)
+	}
+
+	for name, expected := range map[string]time.Time{models.CREATED_AT_FIELD: created, models.UPDATED_AT_FIELD: now} {
+		value, err := record.GetField(name)
+		if date, ok := value.(time.Time); err != nil || !ok || !date.Equal(expected) {
+			t.Errorf("GetField() failed: Expected %s to be %v, got %v: %v", name, expected, value, err)
+		}
+	}
+}
+
+func TestCollection_InsertMany(t *testing.T) {
+	logger := logger.New(nil, nil)
+	collection := NewCollection("test_collection", logger)
+	collection.SetDir(t.TempDir())
+	defer collection.Close()
+
+	records := []*models.Record{
+		{Fields: map[string]interface{}{"name": "Sajith", "age": 30}},
+		{Fields: map[string]interface{}{"name": "Mahinda", "age": 35}},
+	}
+	if err := collection.InsertMany(records); err != nil {
+		t.Fatalf("InsertMany() failed: %v", err)
+	}
+	if records[0].ID != 1 || records[1].ID != 2 || len(collection.records) != 2 {
+		t.Errorf("InsertMany() failed: Records were not inserted")
+	}
+
+	schema := &models.Schema{Fields: map[string]*models.FieldSchema{"age": {Type: models.KindInt, Required: true}}}
+	if err := collection.SetSchema(schema, ValidationStrict); err != nil {
+		t.Fatalf("SetSchema() failed: %v", err)
+	}
+	err := collection.InsertMany([]*models.Record{
+		{Fields: map[string]interface{}{"name": "Anura", "age": 40}},
+		{Fields: map[string]interface{}{"name": "Ranil"}},
+	})
+	if err == nil {
+		t.Errorf("InsertMany() failed: Expected an error for the invalid record")
+	}
+	if len(collection.records) != 2 || collection.nextID != 3 {
+		t.Errorf("InsertMany() failed: Records were inserted although one is invalid")
+	}
+}
+
+func TestCollection_GetMany(t *testing.T) {
+	for _, mode := range []StorageMode{ChunkStorage, LogStorage, BTreeStorage} {
+		logger, tempDir := logger.New(nil, nil), t.TempDir()
+		collection := NewCollection("test_collection", logger)
+		collection.SetDir(tempDir)
+		collection.SetStorageMode(mode)
+		for i := 0; i < MAX_CHUNK+10; i++ {
+			collection.InsertRecord(&models.Record{Fields: map[string]interface{}{"n": i}})
+		}
+		if err := collection.FlushRecords(); err != nil {
+			t.Fatalf("FlushRecords() failed: %v", err)
+		}
+		collection.Close()
+
+		newcollection := NewCollection("test_collection", logger)
+		newcollection.SetDir(tempDir)
+		newcollection.GetRecordByID(2)
+		ids := []int{MAX_CHUNK + 5, 2, 9999, 1, 3}
+		records, missing, err := newcollection.GetMany(ids)
+		if err != nil {
+			t.Fatalf("GetMany() failed: %v", err)
+		}
+		var got []int
+		for _, record := range records {
+			got = append(got, record.ID)
+		}
+		if !reflect.DeepEqual(got, []int{MAX_CHUNK + 5, 2, 1, 3}) || !reflect.DeepEqual(missing, []int{9999}) {
+			t.Errorf("GetMany() failed: %s storage got %v, missing %v", mode, got, missing)
+		}
+		if records[0].Fields["n"] != int64(MAX_CHUNK+4) {
+			t.Errorf("GetMany() failed: %s storage loaded %v", mode, records[0].Fields)
+		}
+		if len(newcollection.records) != 4 {
+			t.Errorf("GetMany() failed: %s storage cached %d records", mode, len(newcollection.records))
+		}
+		newcollection.Close()
+	}
+}
+
+func TestCollection_Context(t *testing.T) {
+	logger, tempDir := logger.New(nil, nil), t.TempDir()
+	collection := NewCollection("test_collection", logger)
+	collection.SetDir(tempDir)
+	defer collection.Close()
+	for i := 0; i < 10; i++ {
+		collection.InsertRecord(&models.Record{Fields: map[string]interface{}{"n": i}})
+	}
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := collection.FlushRecordsContext(cancelled); !errors.Is(err, context.Canceled) {
+		t.Errorf("FlushRecordsContext() failed: Expected context.Canceled, got %v", err)
+	}
+	if collection.records[1].Flushed {
+		t.Errorf("FlushRecordsContext() failed: Cancelled flush marked the records as flushed")
+	}
+	if err := collection.FlushRecordsContext(context.Background()); err != nil {
+		t.Fatalf("FlushRecordsContext() failed: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	seen := 0
+	err := collection.ScanRangeContext(ctx, 1, 10, func(record *models.Record) bool {
+		seen++
+		if seen == 3 {
+			cancel()
+		}
+		return true
+	})
+	if !errors.Is(err, context.Canceled) || seen != 3 {
+		t.Errorf("ScanRangeContext() failed: Expected the scan to stop after 3 records, got %d, %v", seen, err)
+	}
+
+	if _, err := collection.ViewContext(cancelled, 1); !errors.Is(err, context.Canceled) {
+		t.Errorf("ViewContext() failed: Expected context.Canceled, got %v", err)
+	}
+	if _, err := collection.GetRecordByIDContext(cancelled, 1); !errors.Is(err, context.Canceled) {
+		t.Errorf("GetRecordByIDContext() failed: Expected context.Canceled, got %v", err)
+	}
+	if _, err := collection.VacuumContext(cancelled); !errors.Is(err, context.Canceled) {
+		t.Errorf("VacuumContext() failed: Expected context.Canceled, got %v", err)
+	}
+	results, err := collection.BulkWriteContext(cancelled, []WriteOperation{{Kind: WriteDelete, ID: 1}})
+	if !errors.Is(err, context.Canceled) || len(results) != 1 || !errors.Is(results[0].Err, context.Canceled) {
+		t.Errorf("BulkWriteContext() failed: Expected context.Canceled, got %v", err)
+	}
+	if _, err := collection.GetRecordByID(1); err != nil {
+		t.Errorf("BulkWriteContext() failed: Cancelled write deleted record 1")
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	collection.RegisterMigration(Migration{Version: 1, Up: func(record *models.Record) error {
+		cancel()
+		return record.SetField("migrated", true)
+	}})
+	collection.records = make(map[int]*models.Record)
+	report, err := collection.MigrateContext(ctx, false)
+	if !errors.Is(err, context.Canceled) || report.Upgraded != 1 {
+		t.Errorf("MigrateContext() failed: Expected the migration to stop after 1 record, got %d, %v", report.Upgraded, err)
+	}
+	if collection.SchemaVersion() != 0 {
+		t.Errorf("MigrateContext() failed: Cancelled migration changed the version")
+	}
+}
+
 func TestCollection_SetDir(t *testing.T) {
 	logger, tempDir := logger.New(nil, nil), t.TempDir()
 	collection := NewCollection("test_collection", logger)
@@ -0,0 +1,49 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// Errors of the collection. They are wrapped with the details of
+// the failure, test them with errors.Is.
+var (
+	// The record, its key or its field does not exist or the
+	// record has expired.
+	ErrNotFound = errors.New("not found")
+	// Saved data could not be decoded or failed its checksum.
+	ErrCorrupt = errors.New("corrupt data")
+	// The collection has been closed.
+	ErrClosed = errors.New("collection is closed")
+	// The change conflicts with the saved records or settings,
+	// like a duplicate key.
+	ErrConflict = errors.New("conflict")
+	// The record does not match the schema or a value or
+	// operation given is invalid.
+	ErrValidation = errors.New("validation failed")
+	// The watcher fell too far behind the changes of the
+	// collection and was stopped.
+	ErrLagging = errors.New("watcher is lagging")
+)
+
+// This function wraps an error decoding saved data.
+func corrupt(err error) error {
+	return fmt.Errorf("error decoding data: %w: %v", ErrCorrupt, err)
+}
+
+// This function wraps an error about an invalid value or operation.
+func invalid(err error) error {
+	return fmt.Errorf("%w: %w", ErrValidation, err)
+}
+
+// This function checks that the collection is open and the
+// context is not done before an operation starts.
+func (c *Collection) ready(ctx context.Context) error {
+	select {
+	case <-c.done:
+		return fmt.Errorf("collection '%s': %w", c.name, ErrClosed)
+	default:
+	}
+	return ctx.Err()
+}
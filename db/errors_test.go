@@ -0,0 +1,103 @@
+package db
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/OmerMohideen/minibase/logger"
+	"github.com/OmerMohideen/minibase/models"
+)
+
+func TestCollection_Errors(t *testing.T) {
+	logger, tempDir := logger.New(nil, nil), t.TempDir()
+	collection := NewCollection("test_collection", logger)
+	collection.SetDir(tempDir)
+	for i := 0; i < 2; i++ {
+		record := &models.Record{Fields: map[string]interface{}{"email": "a@b.c"}}
+		if err := collection.InsertRecord(record); err != nil {
+			t.Fatalf("InsertRecord() failed: %v", err)
+		}
+	}
+
+	if _, err := collection.GetRecordByID(10); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetRecordByID() failed: Expected ErrNotFound, got %v", err)
+	}
+	if err := collection.UpdateRecord(10, &models.Record{Fields: map[string]interface{}{}}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("UpdateRecord() failed: Expected ErrNotFound, got %v", err)
+	}
+	if err := collection.DeleteRecord(10); !errors.Is(err, ErrNotFound) {
+		t.Errorf("DeleteRecord() failed: Expected ErrNotFound, got %v", err)
+	}
+	if _, err := collection.Patch(10, models.Patch{"$set": {"n": 1}}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Patch() failed: Expected ErrNotFound, got %v", err)
+	}
+	if _, err := collection.GetRecordByKey("10"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetRecordByKey() failed: Expected ErrNotFound, got %v", err)
+	}
+	if _, err := collection.View(10); !errors.Is(err, ErrNotFound) {
+		t.Errorf("View() failed: Expected ErrNotFound, got %v", err)
+	}
+	view, err := collection.View(1)
+	if err != nil {
+		t.Fatalf("View() failed: %v", err)
+	}
+	if _, err := view.Field("phone"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Field() failed: Expected ErrNotFound, got %v", err)
+	}
+	if _, err := view.Field("email["); !errors.Is(err, ErrValidation) {
+		t.Errorf("Field() failed: Expected ErrValidation, got %v", err)
+	}
+
+	if _, err := collection.Patch(1, models.Patch{"$inc": {"email": 1}}); !errors.Is(err, ErrValidation) {
+		t.Errorf("Patch() failed: Expected ErrValidation, got %v", err)
+	}
+	if err := collection.InsertRecord(&models.Record{Fields: map[string]interface{}{"bad": make(chan int)}}); !errors.Is(err, ErrValidation) {
+		t.Errorf("InsertRecord() failed: Expected ErrValidation, got %v", err)
+	}
+	schema := &models.Schema{Fields: map[string]*models.FieldSchema{"email": {Type: models.KindString, Required: true}}}
+	if err := collection.SetSchema(schema, ValidationStrict); err != nil {
+		t.Fatalf("SetSchema() failed: %v", err)
+	}
+	err = collection.InsertRecord(&models.Record{Fields: map[string]interface{}{}})
+	var validationErrors models.ValidationErrors
+	if !errors.Is(err, ErrValidation) || !errors.As(err, &validationErrors) {
+		t.Errorf("InsertRecord() failed: Expected ErrValidation and ValidationErrors, got %v", err)
+	}
+
+	if _, err := collection.UpsertBy("email", &models.Record{Fields: map[string]interface{}{"email": "a@b.c"}}); !errors.Is(err, ErrConflict) {
+		t.Errorf("UpsertBy() failed: Expected ErrConflict, got %v", err)
+	}
+	if err := collection.SetKeyStrategy(KeyUUID); !errors.Is(err, ErrConflict) {
+		t.Errorf("SetKeyStrategy() failed: Expected ErrConflict, got %v", err)
+	}
+	results, err := collection.BulkWrite([]WriteOperation{{Kind: WriteDelete, ID: 10}})
+	if !errors.Is(err, ErrNotFound) || !errors.Is(results[0].Err, ErrNotFound) {
+		t.Errorf("BulkWrite() failed: Expected ErrNotFound, got %v", err)
+	}
+
+	if err := collection.FlushRecords(); err != nil {
+		t.Fatalf("FlushRecords() failed: %v", err)
+	}
+	collection.Close()
+	if _, err := collection.GetRecordByID(1); !errors.Is(err, ErrClosed) {
+		t.Errorf("GetRecordByID() failed: Expected ErrClosed, got %v", err)
+	}
+	if err := collection.InsertRecord(&models.Record{Fields: map[string]interface{}{}}); !errors.Is(err, ErrClosed) {
+		t.Errorf("InsertRecord() failed: Expected ErrClosed, got %v", err)
+	}
+	if _, err := collection.BulkWrite([]WriteOperation{{Kind: WriteDelete, ID: 1}}); !errors.Is(err, ErrClosed) {
+		t.Errorf("BulkWrite() failed: Expected ErrClosed, got %v", err)
+	}
+
+	path := filepath.Join(tempDir, "test_collection", chunkFilename(1))
+	if err := os.WriteFile(path, []byte(`[{"id":1,`), 0644); err != nil {
+		t.Fatalf("WriteFile() failed: %v", err)
+	}
+	newcollection := NewCollection("test_collection", logger)
+	newcollection.SetDir(tempDir)
+	if _, err := newcollection.GetRecordByID(1); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("GetRecordByID() failed: Expected ErrCorrupt, got %v", err)
+	}
+}
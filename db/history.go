@@ -0,0 +1,372 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/OmerMohideen/minibase/models"
+)
+
+// Name of the history file inside the collection directory.
+const HISTORY_FILE = "history.log"
+
+// HistoryOptions represents the settings of the history of the
+// records. The retention is applied by PruneHistory, the latest
+// version of a record is kept unless it is a delete older than MaxAge.
+type HistoryOptions struct {
+	Enabled bool `json:"enabled"`
+	// Number of versions kept per record, zero keeps all of them.
+	MaxVersions int `json:"maxVersions,omitempty"`
+	// Age after which versions are pruned, zero keeps all of them.
+	MaxAge time.Duration `json:"maxAge,omitempty"`
+}
+
+// RecordVersion represents a version of a record kept by the
+// history. Versions of a record are numbered from 1 in the order
+// of its changes. Record is nil when the version is a delete.
+type RecordVersion struct {
+	Version int            `json:"version"`
+	Kind    ChangeKind     `json:"kind"`
+	Time    time.Time      `json:"time"`
+	Record  *models.Record `json:"record,omitempty"`
+}
+
+// This function enables or disables the history of the records.
+// The setting is saved in the metadata of the collection. Records
+// saved before the history was enabled get their first version
+// when they are changed.
+func (c *Collection) SetHistory(opts HistoryOptions) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.historyOpts = opts
+	if err := c.openHistory(); err != nil {
+		return err
+	}
+	return c.saveMetadata()
+}
+
+// This function lists the versions of the record with the id
+// from the oldest to the latest.
+func (c *Collection) ListVersions(id int) ([]RecordVersion, error) {
+	return c.ListVersionsContext(context.Background(), id)
+}
+
+// This function is ListVersions with a context.
+func (c *Collection) ListVersionsContext(ctx context.Context, id int) ([]RecordVersion, error) {
+	if err := c.ready(ctx); err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.hasHistory(); err != nil {
+		return nil, err
+	}
+	refs := c.history.versions[id]
+	versions := make([]RecordVersion, 0, len(refs))
+	for _, ref := range refs {
+		version, err := c.history.read(ref)
+		if err != nil {
+			return nil, err
+		}
+		versions = append(versions, version)
+	}
+	return versions, nil
+}
+
+// This function gets the version of the record with the id.
+func (c *Collection) GetRecordVersion(id, version int) (*models.Record, error) {
+	return c.GetRecordVersionContext(context.Background(), id, version)
+}
+
+// This function is GetRecordVersion with a context.
+func (c *Collection) GetRecordVersionContext(ctx context.Context, id, version int) (*models.Record, error) {
+	if err := c.ready(ctx); err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.recordVersion(id, func(ref versionRef) bool { return ref.version <= version }, version)
+}
+
+// This function gets the record with the id as it was at the
+// time, that is its latest version made at or before it.
+func (c *Collection) GetRecordAt(id int, at time.Time) (*models.Record, error) {
+	return c.GetRecordAtContext(context.Background(), id, at)
+}
+
+// This function is GetRecordAt with a context.
+func (c *Collection) GetRecordAtContext(ctx context.Context, id int, at time.Time) (*models.Record, error) {
+	if err := c.ready(ctx); err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.recordVersion(id, func(ref versionRef) bool { return !ref.time.After(at) }, 0)
+}
+
+// This function makes the version of the record with the id its
+// current version. A deleted record is inserted again with its id,
+// a record in the trash must be restored from it first.
+// Like an update the change is saved by the next flush and becomes
+// a new version.
+func (c *Collection) RestoreVersion(id, version int) error {
+	return c.RestoreVersionContext(context.Background(), id, version)
+}
+
+// This function is RestoreVersion with a context.
+func (c *Collection) RestoreVersionContext(ctx context.Context, id, version int) error {
+	if err := c.ready(ctx); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	record, err := c.recordVersion(id, func(ref versionRef) bool { return ref.version <= version }, version)
+	if err != nil {
+		return err
+	}
+	record.ExpireAt = time.Time{}
+	record.Flushed = false
+
+	existing, err := c.liveRecord(ctx, id)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		return c.replaceRecord(ctx, ChangeUpdate, existing, record)
+	}
+	if err := c.checkTrash(id); err != nil {
+		return err
+	}
+	// An expired record is overwritten by the insert.
+	delete(c.records, id)
+	return c.insertRecord(ctx, id, record)
+}
+
+// This function removes the versions outside of the retention of
+// the history and gets how many were removed. It is called by the
+// collection every REAP_INTERVAL and by Compact.
+func (c *Collection) PruneHistory() (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.history == nil {
+		return 0, nil
+	}
+	return c.history.prune(c.historyOpts, c.now())
+}
+
+// This function gets the record of the latest version of the
+// record with the id which is selected. With a version it must
+// be the selected version.
+// The caller must hold the lock.
+func (c *Collection) recordVersion(id int, selected func(versionRef) bool, version int) (*models.Record, error) {
+	if err := c.hasHistory(); err != nil {
+		return nil, err
+	}
+	refs := c.history.versions[id]
+	i := len(refs) - 1
+	for i >= 0 && !selected(refs[i]) {
+		i--
+	}
+	if i < 0 || (version > 0 && refs[i].version != version) {
+		return nil, fmt.Errorf("version of record with ID '%d' %w", id, ErrNotFound)
+	}
+	if refs[i].kind == ChangeDelete {
+		return nil, fmt.Errorf("record with ID '%d' was deleted in version %d: %w", id, refs[i].version, ErrNotFound)
+	}
+	found, err := c.history.read(refs[i])
+	if err != nil {
+		return nil, err
+	}
+	return found.Record, nil
+}
+
+// This function checks that the collection keeps the history.
+// The caller must hold the lock.
+func (c *Collection) hasHistory() error {
+	if c.history == nil {
+		return fmt.Errorf("collection '%s' has no history: %w", c.name, ErrNotFound)
+	}
+	return nil
+}
+
+// This function closes the history and opens it again when
+// it is enabled.
+// The caller must hold the lock.
+func (c *Collection) openHistory() error {
+	if c.history != nil {
+		c.history.close()
+		c.history = nil
+	}
+	if !c.historyOpts.Enabled {
+		return nil
+	}
+	history, err := openHistory(filepath.Join(c.dir, c.name))
+	if err != nil {
+		return err
+	}
+	c.history = history
+	return nil
+}
+
+// versionRef locates a version of a record in the history.
+type versionRef struct {
+	version int
+	kind    ChangeKind
+	time    time.Time
+	offset  int64
+	size    int64
+}
+
+// historyLog is the journal of the versions of the records. The
+// entries have the kind of the change as operation and the id of
+// the record as id. The versions of every record are indexed in
+// the memory.
+type historyLog struct {
+	*journal
+	versions map[int][]versionRef
+}
+
+// This function opens the history of the collection directory.
+func openHistory(dir string) (*historyLog, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	history := &historyLog{}
+	j, err := openJournal(filepath.Join(dir, HISTORY_FILE), func(byte, uint64, []byte) {})
+	if err != nil {
+		return nil, err
+	}
+	history.journal = j
+	if err := history.index(); err != nil {
+		j.close()
+		return nil, err
+	}
+	return history, nil
+}
+
+// This function indexes the versions of the journal.
+func (h *historyLog) index() error {
+	h.versions = make(map[int][]versionRef)
+	var offset int64
+	return readJournal(h.reader(), func(op byte, id uint64, payload []byte) error {
+		var version struct {
+			Version int       `json:"version"`
+			Time    time.Time `json:"time"`
+		}
+		if err := json.Unmarshal(payload, &version); err != nil {
+			return corrupt(err)
+		}
+		size := int64(logHeaderSize + len(payload))
+		h.versions[int(id)] = append(h.versions[int(id)], versionRef{
+			version: version.Version,
+			kind:    ChangeKind(op),
+			time:    version.Time,
+			offset:  offset,
+			size:    size,
+		})
+		offset += size
+		return nil
+	})
+}
+
+// This function adds the versions of a change. A record changed
+// for the first time since the history was enabled gets its
+// previous state as its first version.
+func (h *historyLog) record(event ChangeEvent) error {
+	if len(h.versions[event.ID]) == 0 && event.Before != nil {
+		kind := ChangeUpdate
+		if event.Before.UpdatedAt.Equal(event.Before.CreatedAt) {
+			kind = ChangeInsert
+		}
+		err := h.add(event.ID, RecordVersion{Kind: kind, Time: event.Before.UpdatedAt, Record: event.Before})
+		if err != nil {
+			return err
+		}
+	}
+	return h.add(event.ID, RecordVersion{Kind: event.Kind, Time: event.Time, Record: event.After})
+}
+
+// This function appends the next version of the record with the id.
+func (h *historyLog) add(id int, version RecordVersion) error {
+	refs := h.versions[id]
+	version.Version = 1
+	if len(refs) > 0 {
+		version.Version = refs[len(refs)-1].version + 1
+	}
+	payload, err := json.Marshal(version)
+	if err != nil {
+		return fmt.Errorf("error encoding data: %v", err)
+	}
+	offset := h.size
+	if err := h.append(byte(version.Kind), uint64(id), payload); err != nil {
+		return err
+	}
+	h.versions[id] = append(refs, versionRef{
+		version: version.Version,
+		kind:    version.Kind,
+		time:    version.Time,
+		offset:  offset,
+		size:    h.size - offset,
+	})
+	return nil
+}
+
+// This function reads a version from the journal.
+func (h *historyLog) read(ref versionRef) (RecordVersion, error) {
+	entry := make([]byte, ref.size)
+	if _, err := h.file.ReadAt(entry, ref.offset); err != nil {
+		return RecordVersion{}, fmt.Errorf("error reading file: %w", err)
+	}
+	_, _, payload, err := readLogEntry(bytes.NewReader(entry))
+	if err != nil {
+		return RecordVersion{}, corrupt(err)
+	}
+	var version RecordVersion
+	if err := json.Unmarshal(payload, &version); err != nil {
+		return RecordVersion{}, corrupt(err)
+	}
+	return version, nil
+}
+
+// This function removes the versions outside of the retention
+// and gets how many were removed.
+func (h *historyLog) prune(opts HistoryOptions, now time.Time) (int, error) {
+	if opts.MaxVersions <= 0 && opts.MaxAge <= 0 {
+		return 0, nil
+	}
+	cutoff := now.Add(-opts.MaxAge)
+	pruned := make(map[int64]bool)
+	for _, refs := range h.versions {
+		last := len(refs) - 1
+		for i, ref := range refs {
+			tooMany := opts.MaxVersions > 0 && i < len(refs)-opts.MaxVersions
+			tooOld := opts.MaxAge > 0 && ref.time.Before(cutoff)
+			if tooMany || (tooOld && (i < last || ref.kind == ChangeDelete)) {
+				pruned[ref.offset] = true
+			}
+		}
+	}
+	if len(pruned) == 0 {
+		return 0, nil
+	}
+
+	var offset int64
+	err := h.rewrite(func(_ byte, _ uint64, payload []byte) bool {
+		keep := !pruned[offset]
+		offset += int64(logHeaderSize + len(payload))
+		return keep
+	})
+	if err != nil {
+		return 0, err
+	}
+	return len(pruned), h.index()
+}
@@ -0,0 +1,173 @@
+package db
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/OmerMohideen/minibase/logger"
+	"github.com/OmerMohideen/minibase/models"
+)
+
+func TestCollection_History(t *testing.T) {
+	logger, tempDir := logger.New(nil, nil), t.TempDir()
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	now := start
+	collection := NewCollection("test_collection", logger)
+	collection.SetDir(tempDir)
+	collection.SetClock(func() time.Time { return now })
+
+	if err := collection.InsertRecord(&models.Record{Fields: map[string]interface{}{"n": 0}}); err != nil {
+		t.Fatalf("InsertRecord() failed: %v", err)
+	}
+	if err := collection.SetHistory(HistoryOptions{Enabled: true}); err != nil {
+		t.Fatalf("SetHistory() failed: %v", err)
+	}
+	if err := collection.InsertRecord(&models.Record{Fields: map[string]interface{}{"n": 1}}); err != nil {
+		t.Fatalf("InsertRecord() failed: %v", err)
+	}
+	now = now.Add(time.Minute)
+	if err := collection.UpdateRecord(2, &models.Record{Fields: map[string]interface{}{"n": 2}}); err != nil {
+		t.Fatalf("UpdateRecord() failed: %v", err)
+	}
+	now = now.Add(time.Minute)
+	if _, err := collection.Patch(2, models.Patch{"$inc": {"n": 1}}); err != nil {
+		t.Fatalf("Patch() failed: %v", err)
+	}
+	now = now.Add(time.Minute)
+	if err := collection.DeleteRecord(2); err != nil {
+		t.Fatalf("DeleteRecord() failed: %v", err)
+	}
+	if err := collection.UpdateRecord(1, &models.Record{Fields: map[string]interface{}{"n": 10}}); err != nil {
+		t.Fatalf("UpdateRecord() failed: %v", err)
+	}
+	if versions, err := collection.ListVersions(1); err != nil || len(versions) != 0 {
+		t.Errorf("ListVersions() failed: Expected no version before the flush, got %+v, %v", versions, err)
+	}
+	if err := collection.FlushRecords(); err != nil {
+		t.Fatalf("FlushRecords() failed: %v", err)
+	}
+
+	versions, err := collection.ListVersions(2)
+	if err != nil {
+		t.Fatalf("ListVersions() failed: %v", err)
+	}
+	kinds := []ChangeKind{ChangeInsert, ChangeUpdate, ChangePatch, ChangeDelete}
+	if len(versions) != len(kinds) {
+		t.Fatalf("ListVersions() failed: Expected %d versions, got %d", len(kinds), len(versions))
+	}
+	for i, version := range versions {
+		if version.Version != i+1 || version.Kind != kinds[i] || !version.Time.Equal(start.Add(time.Duration(i)*time.Minute)) {
+			t.Errorf("ListVersions() failed: Expected version %d to be the %s, got %+v", i+1, kinds[i], version)
+		}
+	}
+	if versions[2].Record.Fields["n"] != int64(3) || versions[3].Record != nil {
+		t.Errorf("ListVersions() failed: Unexpected records %v, %v", versions[2].Record, versions[3].Record)
+	}
+
+	versions, err = collection.ListVersions(1)
+	if err != nil || len(versions) != 2 || versions[0].Kind != ChangeInsert || versions[0].Record.Fields["n"] != int64(0) {
+		t.Errorf("ListVersions() failed: Expected the version before the history was enabled, got %+v, %v", versions, err)
+	}
+
+	record, err := collection.GetRecordAt(2, start.Add(90*time.Second))
+	if err != nil || record.Fields["n"] != int64(2) {
+		t.Errorf("GetRecordAt() failed: Expected the update, got %v, %v", record, err)
+	}
+	if _, err := collection.GetRecordAt(2, start.Add(-time.Second)); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetRecordAt() failed: Expected ErrNotFound before the insert, got %v", err)
+	}
+	if _, err := collection.GetRecordAt(2, now); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetRecordAt() failed: Expected ErrNotFound after the delete, got %v", err)
+	}
+	record, err = collection.GetRecordVersion(2, 3)
+	if err != nil || record.Fields["n"] != int64(3) {
+		t.Errorf("GetRecordVersion() failed: Expected the patch, got %v, %v", record, err)
+	}
+	if _, err := collection.GetRecordVersion(2, 9); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetRecordVersion() failed: Expected ErrNotFound, got %v", err)
+	}
+
+	if err := collection.RestoreVersion(2, 2); err != nil {
+		t.Fatalf("RestoreVersion() failed: %v", err)
+	}
+	record, err = collection.GetRecordByID(2)
+	if err != nil || record.Fields["n"] != int64(2) {
+		t.Errorf("RestoreVersion() failed: Expected the record of version 2, got %v, %v", record, err)
+	}
+	if err := collection.RestoreVersion(2, 4); !errors.Is(err, ErrNotFound) {
+		t.Errorf("RestoreVersion() failed: Expected ErrNotFound restoring a delete, got %v", err)
+	}
+	if err := collection.FlushRecords(); err != nil {
+		t.Fatalf("FlushRecords() failed: %v", err)
+	}
+	collection.Close()
+
+	newcollection := NewCollection("test_collection", logger)
+	newcollection.SetDir(tempDir)
+	newcollection.SetClock(func() time.Time { return now })
+	defer newcollection.Close()
+	versions, err = newcollection.ListVersions(2)
+	if err != nil || len(versions) != 5 || versions[4].Kind != ChangeInsert || versions[4].Record.Fields["n"] != int64(2) {
+		t.Fatalf("ListVersions() failed: Expected 5 versions after opening again, got %+v, %v", versions, err)
+	}
+
+	if err := newcollection.SetHistory(HistoryOptions{Enabled: true, MaxVersions: 2}); err != nil {
+		t.Fatalf("SetHistory() failed: %v", err)
+	}
+	if err := newcollection.Compact(); err != nil {
+		t.Fatalf("Compact() failed: %v", err)
+	}
+	versions, err = newcollection.ListVersions(2)
+	if err != nil || len(versions) != 2 || versions[0].Version != 4 || versions[1].Version != 5 {
+		t.Errorf("Compact() failed: Expected versions 4 and 5, got %+v, %v", versions, err)
+	}
+
+	now = now.Add(time.Hour)
+	if err := newcollection.DeleteRecord(1); err != nil {
+		t.Fatalf("DeleteRecord() failed: %v", err)
+	}
+	if err := newcollection.SetHistory(HistoryOptions{Enabled: true, MaxAge: 30 * time.Minute}); err != nil {
+		t.Fatalf("SetHistory() failed: %v", err)
+	}
+	now = now.Add(time.Hour)
+	if err := newcollection.Compact(); err != nil {
+		t.Fatalf("Compact() failed: %v", err)
+	}
+	if versions, err := newcollection.ListVersions(1); err != nil || len(versions) != 0 {
+		t.Errorf("Compact() failed: Expected the history of the old delete to be pruned, got %+v, %v", versions, err)
+	}
+	if versions, err := newcollection.ListVersions(2); err != nil || len(versions) != 1 || versions[0].Version != 5 {
+		t.Errorf("Compact() failed: Expected the latest version to be kept, got %+v, %v", versions, err)
+	}
+
+	if err := newcollection.SetSoftDelete(SoftDeleteOptions{Enabled: true}); err != nil {
+		t.Fatalf("SetSoftDelete() failed: %v", err)
+	}
+	if err := newcollection.DeleteRecord(2); err != nil {
+		t.Fatalf("DeleteRecord() failed: %v", err)
+	}
+	if err := newcollection.RestoreVersion(2, 5); !errors.Is(err, ErrConflict) {
+		t.Errorf("RestoreVersion() failed: Expected ErrConflict for a record in the trash, got %v", err)
+	}
+	if deleted, err := newcollection.ListDeleted(); err != nil || len(deleted) != 1 || deleted[0].ID != 2 {
+		t.Errorf("RestoreVersion() failed: Expected the record to stay in the trash, got %v, %v", deleted, err)
+	}
+
+	record = &models.Record{Fields: map[string]interface{}{"n": 1}}
+	if err := newcollection.InsertRecord(record); err != nil {
+		t.Fatalf("InsertRecord() failed: %v", err)
+	}
+	if err := newcollection.UpdateRecord(record.ID, &models.Record{Fields: map[string]interface{}{"n": 2}}); err != nil {
+		t.Fatalf("UpdateRecord() failed: %v", err)
+	}
+	if err := newcollection.FlushRecords(); err != nil {
+		t.Fatalf("FlushRecords() failed: %v", err)
+	}
+	if err := newcollection.SetHistory(HistoryOptions{Enabled: true, MaxVersions: 1}); err != nil {
+		t.Fatalf("SetHistory() failed: %v", err)
+	}
+	if pruned, err := newcollection.PruneHistory(); err != nil || pruned != 2 {
+		t.Errorf("PruneHistory() failed: Expected 2 pruned, got %d, %v", pruned, err)
+	}
+}
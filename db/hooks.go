@@ -0,0 +1,117 @@
+package db
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/OmerMohideen/minibase/models"
+)
+
+// HookEvent represents the operation a hook is called for.
+type HookEvent int
+
+const (
+	// A record is inserted, including the inserts of upserts.
+	HookInsert HookEvent = iota
+	// A record is replaced or patched.
+	HookUpdate
+	// A record is deleted.
+	HookDelete
+	// A record is read from the storage into the memory.
+	HookLoad
+	// A changed record is saved to the storage.
+	HookFlush
+)
+
+// This function returns the name of the hook event.
+func (e HookEvent) String() string {
+	switch e {
+	case HookInsert:
+		return "insert"
+	case HookUpdate:
+		return "update"
+	case HookDelete:
+		return "delete"
+	case HookLoad:
+		return "load"
+	case HookFlush:
+		return "flush"
+	}
+	return fmt.Sprintf("HookEvent(%d)", int(e))
+}
+
+// BeforeHook is called with the record before the operation.
+// It may change the fields of the record and an error cancels the
+// operation and is returned by it. The id of the record is kept and
+// its key only set by inserts.
+type BeforeHook func(ctx context.Context, record *models.Record) error
+
+// AfterHook is called with the final record once the operation
+// is done.
+type AfterHook func(ctx context.Context, record *models.Record)
+
+// This function adds a hook called before the operations of the
+// event. Hooks are called in the order they were added, before
+// the record is checked against the schema. They run while the
+// collection is locked and must not use the collection.
+func (c *Collection) AddBeforeHook(event HookEvent, hook BeforeHook) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.beforeHooks == nil {
+		c.beforeHooks = make(map[HookEvent][]BeforeHook)
+	}
+	c.beforeHooks[event] = append(c.beforeHooks[event], hook)
+}
+
+// This function adds a hook called after the operations of the
+// event. Hooks are called in the order they were added, after the
+// change is made and sent to the watchers. They run while the
+// collection is locked and must not use the collection.
+func (c *Collection) AddAfterHook(event HookEvent, hook AfterHook) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.afterHooks == nil {
+		c.afterHooks = make(map[HookEvent][]AfterHook)
+	}
+	c.afterHooks[event] = append(c.afterHooks[event], hook)
+}
+
+// This function tells if the event has hooks.
+// The caller must hold the lock.
+func (c *Collection) hooked(event HookEvent) bool {
+	return len(c.beforeHooks[event]) > 0 || len(c.afterHooks[event]) > 0
+}
+
+// This function calls the before hooks of the event with the
+// record. The fields the hooks set are normalized.
+// The caller must hold the lock.
+func (c *Collection) runBefore(ctx context.Context, event HookEvent, record *models.Record) error {
+	hooks := c.beforeHooks[event]
+	if len(hooks) == 0 {
+		return nil
+	}
+	id, key := record.ID, record.Key
+	for _, hook := range hooks {
+		if err := hook(ctx, record); err != nil {
+			return fmt.Errorf("%s of record %d cancelled by a hook: %w", event, id, err)
+		}
+	}
+	record.ID = id
+	if event != HookInsert {
+		record.Key = key
+	}
+	if err := record.Normalize(); err != nil {
+		return invalid(err)
+	}
+	return nil
+}
+
+// This function calls the after hooks of the event with the record.
+// The caller must hold the lock.
+func (c *Collection) runAfter(ctx context.Context, event HookEvent, record *models.Record) {
+	for _, hook := range c.afterHooks[event] {
+		hook(ctx, record)
+	}
+}
@@ -0,0 +1,97 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/OmerMohideen/minibase/logger"
+	"github.com/OmerMohideen/minibase/models"
+)
+
+func TestCollection_Hooks(t *testing.T) {
+	logger, tempDir := logger.New(nil, nil), t.TempDir()
+	collection := NewCollection("test_collection", logger)
+	collection.SetDir(tempDir)
+	defer collection.Close()
+
+	errBanned := errors.New("banned")
+	var calls []string
+	for _, event := range []HookEvent{HookInsert, HookUpdate, HookDelete, HookLoad, HookFlush} {
+		event := event
+		for i := 1; i <= 2; i++ {
+			i := i
+			collection.AddBeforeHook(event, func(ctx context.Context, record *models.Record) error {
+				calls = append(calls, fmt.Sprintf("before %s %d #%d", event, record.ID, i))
+				if record.Fields["name"] == "banned" {
+					return errBanned
+				}
+				if i == 1 && (event == HookInsert || event == HookUpdate) {
+					record.Fields["upper"] = strings.ToUpper(record.Fields["name"].(string))
+				}
+				return nil
+			})
+			collection.AddAfterHook(event, func(ctx context.Context, record *models.Record) {
+				calls = append(calls, fmt.Sprintf("after %s %d #%d %v", event, record.ID, i, record.Fields["upper"]))
+			})
+		}
+	}
+	expectCalls := func(name string, expected ...string) {
+		t.Helper()
+		if strings.Join(calls, ", ") != strings.Join(expected, ", ") {
+			t.Errorf("%s() failed: Expected hooks %q, got %q", name, expected, calls)
+		}
+		calls = nil
+	}
+
+	if err := collection.InsertRecord(&models.Record{Fields: map[string]interface{}{"name": "a"}}); err != nil {
+		t.Fatalf("InsertRecord() failed: %v", err)
+	}
+	expectCalls("InsertRecord", "before insert 1 #1", "before insert 1 #2", "after insert 1 #1 A", "after insert 1 #2 A")
+
+	err := collection.InsertRecord(&models.Record{Fields: map[string]interface{}{"name": "banned"}})
+	if !errors.Is(err, errBanned) {
+		t.Errorf("InsertRecord() failed: Expected the hook error, got %v", err)
+	}
+	if _, err := collection.GetRecordByID(2); !errors.Is(err, ErrNotFound) {
+		t.Errorf("InsertRecord() failed: Expected the insert to be cancelled, got %v", err)
+	}
+	expectCalls("InsertRecord", "before insert 2 #1")
+
+	if _, err := collection.Patch(1, models.Patch{"$set": {"name": "b"}}); err != nil {
+		t.Fatalf("Patch() failed: %v", err)
+	}
+	expectCalls("Patch", "before update 1 #1", "before update 1 #2", "after update 1 #1 B", "after update 1 #2 B")
+
+	if err := collection.FlushRecords(); err != nil {
+		t.Fatalf("FlushRecords() failed: %v", err)
+	}
+	expectCalls("FlushRecords", "before flush 1 #1", "before flush 1 #2", "after flush 1 #1 B", "after flush 1 #2 B")
+	if err := collection.FlushRecords(); err != nil {
+		t.Fatalf("FlushRecords() failed: %v", err)
+	}
+	expectCalls("FlushRecords")
+
+	collection.records = make(map[int]*models.Record)
+	record, err := collection.GetRecordByID(1)
+	if err != nil || record.Fields["upper"] != "B" {
+		t.Fatalf("GetRecordByID() failed: %v, %v", record, err)
+	}
+	expectCalls("GetRecordByID", "before load 1 #1", "before load 1 #2", "after load 1 #1 B", "after load 1 #2 B")
+
+	if err := collection.UpdateRecord(1, &models.Record{Fields: map[string]interface{}{"name": "banned"}}); !errors.Is(err, errBanned) {
+		t.Errorf("UpdateRecord() failed: Expected the hook error, got %v", err)
+	}
+	calls = nil
+	record.Fields["name"] = "banned"
+	if err := collection.DeleteRecord(1); !errors.Is(err, errBanned) {
+		t.Errorf("DeleteRecord() failed: Expected the hook error, got %v", err)
+	}
+	record.Fields["name"] = "b"
+	if err := collection.DeleteRecord(1); err != nil {
+		t.Fatalf("DeleteRecord() failed: %v", err)
+	}
+	expectCalls("DeleteRecord", "before delete 1 #1", "before delete 1 #1", "before delete 1 #2", "after delete 1 #1 B", "after delete 1 #2 B")
+}
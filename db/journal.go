@@ -0,0 +1,133 @@
+package db
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+)
+
+// journal is an append-only file of entries in the format of the
+// log storage. It keeps the change feed and the audit trail.
+type journal struct {
+	path string
+	file *os.File
+	// Size of the complete entries of the file.
+	size int64
+}
+
+// This function opens the journal file and calls fn with its
+// entries in order. A torn entry at the end of the file is dropped.
+func openJournal(path string, fn func(op byte, id uint64, payload []byte)) (*journal, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("error opening file: %w", err)
+	}
+
+	j := &journal{path: path, file: file}
+	err = readJournal(file, func(op byte, id uint64, payload []byte) error {
+		j.size += int64(logHeaderSize + len(payload))
+		fn(op, id, payload)
+		return nil
+	})
+	if err != nil {
+		if err := file.Truncate(j.size); err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+	return j, nil
+}
+
+// This function appends an entry to the journal.
+func (j *journal) append(op byte, id uint64, payload []byte) error {
+	entry := encodeLogEntry(op, int(id), payload)
+	if _, err := j.file.Write(entry); err != nil {
+		return fmt.Errorf("error writing file: %w", err)
+	}
+	j.size += int64(len(entry))
+	return nil
+}
+
+// This function gets a reader of the entries appended so far.
+// Later appends are not read.
+func (j *journal) reader() io.Reader {
+	return io.NewSectionReader(j.file, 0, j.size)
+}
+
+// This function rewrites the journal with the entries keep
+// returns true for. The file is replaced atomically.
+func (j *journal) rewrite(keep func(op byte, id uint64, payload []byte) bool) error {
+	tmp := j.path + ".tmp"
+	out, err := os.Create(tmp)
+	if err != nil {
+		return fmt.Errorf("error creating file: %w", err)
+	}
+	defer os.Remove(tmp)
+
+	var size int64
+	writer := bufio.NewWriter(out)
+	err = readJournal(j.reader(), func(op byte, id uint64, payload []byte) error {
+		if !keep(op, id, payload) {
+			return nil
+		}
+		entry := encodeLogEntry(op, int(id), payload)
+		if _, err := writer.Write(entry); err != nil {
+			return fmt.Errorf("error writing file: %w", err)
+		}
+		size += int64(len(entry))
+		return nil
+	})
+	if err == nil {
+		err = writer.Flush()
+	}
+	if err == nil {
+		err = out.Sync()
+	}
+	if err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, j.path); err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(j.path, os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("error opening file: %w", err)
+	}
+	j.file.Close()
+	j.file, j.size = file, size
+	return nil
+}
+
+// This function saves the appended entries to the disk.
+func (j *journal) sync() error {
+	return j.file.Sync()
+}
+
+// This function closes the file of the journal.
+func (j *journal) close() error {
+	return j.file.Close()
+}
+
+// This function calls fn with the entries of a journal in order.
+// Returns the error of fn or of a damaged entry.
+func readJournal(r io.Reader, fn func(op byte, id uint64, payload []byte) error) error {
+	reader := bufio.NewReader(r)
+	for {
+		op, id, payload, err := readLogEntry(reader)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return corrupt(err)
+		}
+		if err := fn(op, uint64(id), payload); err != nil {
+			return err
+		}
+	}
+}
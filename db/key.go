@@ -0,0 +1,321 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/OmerMohideen/minibase/models"
+	"github.com/OmerMohideen/minibase/utils"
+)
+
+// KeyStrategy represents how the records of a collection get
+// their primary keys. Records always have an id numbering them
+// inside the collection, keys identify them across collections
+// and machines.
+type KeyStrategy int
+
+const (
+	// Records are identified by their auto-incremented id.
+	KeyAuto KeyStrategy = iota
+	// Records get a random version 4 UUID.
+	KeyUUID
+	// Records get a ULID, which sorts by the time of the insert.
+	KeyULID
+	// Records are inserted with a key chosen by the caller.
+	KeyString
+)
+
+// This function returns the name of the key strategy.
+func (k KeyStrategy) String() string {
+	switch k {
+	case KeyAuto:
+		return "auto"
+	case KeyUUID:
+		return "uuid"
+	case KeyULID:
+		return "ulid"
+	case KeyString:
+		return "string"
+	}
+	return fmt.Sprintf("KeyStrategy(%d)", int(k))
+}
+
+// This function encodes the key strategy by its name.
+func (k KeyStrategy) MarshalText() ([]byte, error) {
+	return []byte(k.String()), nil
+}
+
+// This function decodes the key strategy from its name.
+func (k *KeyStrategy) UnmarshalText(text []byte) error {
+	strategy, err := ParseKeyStrategy(string(text))
+	if err != nil {
+		return err
+	}
+	*k = strategy
+	return nil
+}
+
+// This function parses the name of a key strategy.
+func ParseKeyStrategy(name string) (KeyStrategy, error) {
+	for _, strategy := range []KeyStrategy{KeyAuto, KeyUUID, KeyULID, KeyString} {
+		if strategy.String() == name {
+			return strategy, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown key strategy '%s'", name)
+}
+
+// keyIndex maps the keys of the records to their ids. It is kept
+// in the memory and built from the saved records the first time a
+// key is looked up after the storage is opened, so a collection
+// only read by ids never scans its records. Until then changes are
+// not tracked since the build sees them.
+type keyIndex struct {
+	built bool
+	ids   map[string]int
+	keys  map[int]string
+}
+
+func newKeyIndex() *keyIndex {
+	return &keyIndex{
+		ids:  make(map[string]int),
+		keys: make(map[int]string),
+	}
+}
+
+// This function adds the key of the record replacing the key
+// it was indexed with before.
+func (i *keyIndex) add(record *models.Record) {
+	if !i.built {
+		return
+	}
+	i.remove(record.ID)
+	if record.Key == "" {
+		return
+	}
+	i.ids[record.Key] = record.ID
+	i.keys[record.ID] = record.Key
+}
+
+// This function removes the key of the record.
+func (i *keyIndex) remove(id int) {
+	if !i.built {
+		return
+	}
+	key, ok := i.keys[id]
+	if !ok {
+		return
+	}
+	delete(i.keys, id)
+	if i.ids[key] == id {
+		delete(i.ids, key)
+	}
+}
+
+// This function sets how the records of the collection get their
+// keys. The strategy is saved in the metadata of the collection and
+// can't be changed once the collection has records.
+func (c *Collection) SetKeyStrategy(strategy KeyStrategy) error {
+	if _, err := ParseKeyStrategy(strategy.String()); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if strategy == c.keyStrategy {
+		return nil
+	}
+	if c.nextID > 1 {
+		return fmt.Errorf("collection '%s' already uses %s keys: %w", c.name, c.keyStrategy, ErrConflict)
+	}
+	c.keyStrategy = strategy
+	return c.saveMetadata()
+}
+
+// This function gets how the records of the collection get
+// their keys.
+func (c *Collection) KeyStrategy() KeyStrategy {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.keyStrategy
+}
+
+// This function gets the id of the record with the key.
+// With auto keys the key is the id itself.
+func (c *Collection) RecordID(key string) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.keyStrategy == KeyAuto {
+		id, err := strconv.Atoi(key)
+		if err != nil || id < 1 {
+			return 0, fmt.Errorf("record with key '%s' does not exist: %w", key, ErrNotFound)
+		}
+		return id, nil
+	}
+	id, ok, err := c.keyID(key)
+	if err != nil {
+		return 0, err
+	}
+	if !ok {
+		return 0, fmt.Errorf("record with key '%s' does not exist: %w", key, ErrNotFound)
+	}
+	return id, nil
+}
+
+// This function gets the record by its key, see GetRecordByID.
+func (c *Collection) GetRecordByKey(key string) (*models.Record, error) {
+	return c.GetRecordByKeyContext(context.Background(), key)
+}
+
+// This function is GetRecordByKey with a context.
+func (c *Collection) GetRecordByKeyContext(ctx context.Context, key string) (*models.Record, error) {
+	if err := c.ready(ctx); err != nil {
+		return nil, err
+	}
+	id, err := c.RecordID(key)
+	if err != nil {
+		return nil, err
+	}
+	return c.GetRecordByIDContext(ctx, id)
+}
+
+// This function deletes the record by its key, see DeleteRecord.
+func (c *Collection) DeleteRecordByKey(key string) error {
+	return c.DeleteRecordByKeyContext(context.Background(), key)
+}
+
+// This function is DeleteRecordByKey with a context.
+func (c *Collection) DeleteRecordByKeyContext(ctx context.Context, key string) error {
+	if err := c.ready(ctx); err != nil {
+		return err
+	}
+	id, err := c.RecordID(key)
+	if err != nil {
+		return err
+	}
+	return c.DeleteRecordContext(ctx, id)
+}
+
+// This function gives the record a key by the strategy of the
+// collection. UUID and ULID keys are generated unless the record
+// has one, for example when it is copied from another machine.
+// The key must not be used by another record or by the keys of
+// the batch. With auto keys the key is cleared.
+// The caller must hold the lock.
+func (c *Collection) assignKey(ctx context.Context, record *models.Record, batch map[string]bool) error {
+	var err error
+	switch c.keyStrategy {
+	case KeyAuto:
+		record.Key = ""
+		return nil
+	case KeyString:
+		if record.Key == "" {
+			return invalid(fmt.Errorf("record has no key"))
+		}
+	case KeyUUID:
+		if record.Key == "" {
+			record.Key, err = utils.NewUUID()
+		}
+	case KeyULID:
+		if record.Key == "" {
+			record.Key, err = c.newULID()
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	if batch[record.Key] {
+		return fmt.Errorf("key '%s' is used twice: %w", record.Key, ErrConflict)
+	}
+	id, ok, err := c.keyID(record.Key)
+	if err != nil {
+		return err
+	}
+	if ok {
+		existing, err := c.liveRecord(ctx, id)
+		if err != nil {
+			return err
+		}
+		if existing != nil {
+			return fmt.Errorf("key '%s' already exists: %w", record.Key, ErrConflict)
+		}
+	}
+	if batch != nil {
+		batch[record.Key] = true
+	}
+	return nil
+}
+
+// This function generates a ULID. ULIDs of the same millisecond
+// increment the entropy of the previous one so they keep the order
+// of the inserts.
+// The caller must hold the lock.
+func (c *Collection) newULID() (string, error) {
+	ms := c.now().UnixMilli()
+	if ms > c.ulidTime {
+		entropy, err := utils.NewULIDEntropy()
+		if err != nil {
+			return "", err
+		}
+		c.ulidTime, c.ulidEntropy = ms, entropy
+	} else {
+		i := len(c.ulidEntropy) - 1
+		for ; i >= 0; i-- {
+			c.ulidEntropy[i]++
+			if c.ulidEntropy[i] != 0 {
+				break
+			}
+		}
+		if i < 0 {
+			return "", fmt.Errorf("error generating ulid: too many keys in a millisecond")
+		}
+	}
+	return utils.ULID(time.UnixMilli(c.ulidTime), c.ulidEntropy), nil
+}
+
+// This function gets the id of the record with the key, the
+// key index is built when it is used for the first time.
+// The caller must hold the lock.
+func (c *Collection) keyID(key string) (int, bool, error) {
+	if !c.keys.built {
+		if err := c.buildKeyIndex(); err != nil {
+			return 0, false, fmt.Errorf("error building the key index: %w", err)
+		}
+	}
+	id, ok := c.keys.ids[key]
+	return id, ok, nil
+}
+
+// This function fills the key index with the saved records and
+// the records in the memory.
+// The caller must hold the lock.
+func (c *Collection) buildKeyIndex() error {
+	keys := newKeyIndex()
+	keys.built = true
+	if c.keyStrategy != KeyAuto {
+		err := c.store.scan(1, math.MaxInt, func(record *models.Record) error {
+			if !record.Deleted() {
+				keys.add(record)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, record := range c.records {
+			if record.Deleted() {
+				keys.remove(record.ID)
+			} else {
+				keys.add(record)
+			}
+		}
+	}
+	c.keys = keys
+	return nil
+}
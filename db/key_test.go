@@ -0,0 +1,124 @@
+package db
+
+import (
+	"regexp"
+	"sort"
+	"testing"
+
+	"github.com/OmerMohideen/minibase/logger"
+	"github.com/OmerMohideen/minibase/models"
+)
+
+func TestCollection_KeyStrategy(t *testing.T) {
+	patterns := map[KeyStrategy]*regexp.Regexp{
+		KeyUUID: regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`),
+		KeyULID: regexp.MustCompile(`^[0-9A-HJKMNP-TV-Z]{26}$`),
+	}
+	for strategy, pattern := range patterns {
+		logger, tempDir := logger.New(nil, nil), t.TempDir()
+		collection := NewCollection("test_collection", logger)
+		collection.SetDir(tempDir)
+		if err := collection.SetKeyStrategy(strategy); err != nil {
+			t.Fatalf("SetKeyStrategy() failed: %v", err)
+		}
+
+		var keys []string
+		for i := 0; i < 100; i++ {
+			record := &models.Record{Fields: map[string]interface{}{"n": i}}
+			if err := collection.InsertRecord(record); err != nil {
+				t.Fatalf("InsertRecord() failed: %v", err)
+			}
+			if !pattern.MatchString(record.Key) {
+				t.Errorf("InsertRecord() failed: Invalid %s key '%s'", strategy, record.Key)
+			}
+			keys = append(keys, record.Key)
+		}
+		if strategy == KeyULID && !sort.StringsAreSorted(keys) {
+			t.Errorf("InsertRecord() failed: ULID keys are not in insert order")
+		}
+		if err := collection.SetKeyStrategy(KeyAuto); err == nil {
+			t.Errorf("SetKeyStrategy() failed: Expected an error changing the keys of a collection with records")
+		}
+		if err := collection.UpdateRecord(5, &models.Record{Fields: map[string]interface{}{"n": -1}}); err != nil {
+			t.Fatalf("UpdateRecord() failed: %v", err)
+		}
+		if err := collection.FlushRecords(); err != nil {
+			t.Fatalf("FlushRecords() failed: %v", err)
+		}
+		collection.Close()
+
+		newcollection := NewCollection("test_collection", logger)
+		newcollection.SetDir(tempDir)
+		if newcollection.KeyStrategy() != strategy {
+			t.Errorf("KeyStrategy() failed: Expected %s, got %s", strategy, newcollection.KeyStrategy())
+		}
+		if newcollection.keys.built {
+			t.Errorf("SetDir() failed: Expected the key index to be built by the first lookup")
+		}
+		record, err := newcollection.GetRecordByKey(keys[4])
+		if err != nil || record.ID != 5 || record.Fields["n"] != int64(-1) {
+			t.Errorf("GetRecordByKey() failed: %v, %v", record, err)
+		}
+		if !newcollection.keys.built || len(newcollection.keys.ids) != len(keys) {
+			t.Errorf("GetRecordByKey() failed: Expected %d keys to be indexed, got %d", len(keys), len(newcollection.keys.ids))
+		}
+		if err := newcollection.DeleteRecordByKey(keys[4]); err != nil {
+			t.Errorf("DeleteRecordByKey() failed: %v", err)
+		}
+		if _, err := newcollection.GetRecordByKey(keys[4]); err == nil {
+			t.Errorf("DeleteRecordByKey() failed: Record still exists")
+		}
+		newcollection.Close()
+	}
+}
+
+func TestCollection_StringKeys(t *testing.T) {
+	logger := logger.New(nil, nil)
+	collection := NewCollection("test_collection", logger)
+	collection.SetDir(t.TempDir())
+	defer collection.Close()
+	collection.SetKeyStrategy(KeyString)
+
+	if err := collection.InsertRecord(models.NewRecord()); err == nil {
+		t.Errorf("InsertRecord() failed: Expected an error for a record without a key")
+	}
+	if err := collection.InsertRecord(&models.Record{Key: "sajith", Fields: map[string]interface{}{}}); err != nil {
+		t.Fatalf("InsertRecord() failed: %v", err)
+	}
+	if err := collection.InsertRecord(&models.Record{Key: "sajith", Fields: map[string]interface{}{}}); err == nil {
+		t.Errorf("InsertRecord() failed: Expected an error for a duplicate key")
+	}
+	err := collection.InsertMany([]*models.Record{
+		{Key: "anura", Fields: map[string]interface{}{}},
+		{Key: "anura", Fields: map[string]interface{}{}},
+	})
+	if err == nil {
+		t.Errorf("InsertMany() failed: Expected an error for a duplicate key")
+	}
+	if id, err := collection.RecordID("sajith"); err != nil || id != 1 {
+		t.Errorf("RecordID() failed: got %d, %v", id, err)
+	}
+	record, _ := collection.GetRecordByID(1)
+	if key, err := record.GetField(models.KEY_FIELD); err != nil || key != "sajith" {
+		t.Errorf("GetField() failed: got %v, %v", key, err)
+	}
+}
+
+func TestCollection_AutoKeys(t *testing.T) {
+	logger := logger.New(nil, nil)
+	collection := NewCollection("test_collection", logger)
+	collection.SetDir(t.TempDir())
+	defer collection.Close()
+
+	record := &models.Record{Key: "ignored", Fields: map[string]interface{}{}}
+	collection.InsertRecord(record)
+	if record.Key != "" {
+		t.Errorf("InsertRecord() failed: Expected the key to be cleared, got '%s'", record.Key)
+	}
+	if got, err := collection.GetRecordByKey("1"); err != nil || got != record {
+		t.Errorf("GetRecordByKey() failed: %v", err)
+	}
+	if _, err := collection.GetRecordByKey("x"); err == nil {
+		t.Errorf("GetRecordByKey() failed: Expected an error for an invalid key")
+	}
+}
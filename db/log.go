@@ -0,0 +1,718 @@
+package db
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	l "github.com/OmerMohideen/minibase/logger"
+	"github.com/OmerMohideen/minibase/models"
+)
+
+const (
+	// Maximum size of a log segment before a new one is started.
+	SEGMENT_SIZE = 4 << 20
+	// Ratio of reclaimable bytes in a segment which makes
+	// it eligible for compaction.
+	COMPACTION_THRESHOLD = 0.5
+	// Interval of the background compaction.
+	COMPACTION_INTERVAL = time.Minute
+)
+
+const (
+	logPut    byte = 1
+	logDelete byte = 2
+	// crc32 (4) + payload length (4) + operation (1) + id (8)
+	logHeaderSize = 17
+)
+
+// Pattern of the log segment filenames, the group is the segment id.
+var segmentPattern = regexp.MustCompile(`^(\d+)\.log$`)
+
+// LogOptions represents the settings of the log-structured storage.
+type LogOptions struct {
+	// Maximum size of a segment before a new one is started.
+	SegmentSize int64
+	// Ratio of reclaimable bytes which makes a segment eligible
+	// for compaction, between 0 and 1.
+	CompactionThreshold float64
+	// Interval of the background compaction, zero disables it.
+	CompactionInterval time.Duration
+	// Read the sealed segments through memory maps, regular
+	// reads are used when mapping is unavailable. Only the log
+	// storage maps its files, the other storages read copies.
+	MemoryMap bool
+}
+
+// This function returns the default log-structured storage settings.
+func DefaultLogOptions() LogOptions {
+	return LogOptions{
+		SegmentSize:         SEGMENT_SIZE,
+		CompactionThreshold: COMPACTION_THRESHOLD,
+		CompactionInterval:  COMPACTION_INTERVAL,
+	}
+}
+
+// CompactionStats represents the work done by the compaction
+// of a log-structured collection.
+type CompactionStats struct {
+	Runs              int
+	SegmentsCompacted int
+	BytesReclaimed    int64
+	LastRun           time.Time
+}
+
+// logLocation is the position of an entry inside the log.
+type logLocation struct {
+	segment int
+	offset  int64
+	size    int64
+}
+
+// logSegment represents a segment file of the log.
+// Sealed segments never change, so they can be memory mapped.
+type logSegment struct {
+	id     int
+	file   *os.File
+	size   int64
+	dead   int64
+	mapped *mapping
+}
+
+// mapping is the memory map of a sealed segment. It counts the
+// segment and the views pointing into it, and it is released when
+// the last of them is done with it, so views outlive compaction
+// and the close of the storage.
+type mapping struct {
+	data []byte
+	refs atomic.Int32
+}
+
+// This function creates the mapping of the data held by its segment.
+func newMapping(data []byte) *mapping {
+	m := &mapping{data: data}
+	m.refs.Store(1)
+	return m
+}
+
+// This function adds a reference to the mapping.
+func (m *mapping) acquire() {
+	m.refs.Add(1)
+}
+
+// This function removes a reference to the mapping and unmaps
+// it when it was the last one.
+func (m *mapping) release() {
+	if m.refs.Add(-1) == 0 {
+		munmapFile(m.data)
+	}
+}
+
+// logStorage saves records by appending new versions and
+// tombstones to log segments. A key directory in the memory
+// points to the latest version of every record.
+type logStorage struct {
+	mu       sync.RWMutex
+	path     string
+	opts     LogOptions
+	segments map[int]*logSegment
+	active   *logSegment
+	keydir   map[int]logLocation
+	maxID    int
+	stats    CompactionStats
+	logger   *l.Logger
+	err      error
+	done     chan struct{}
+}
+
+// This function opens the log storage in the directory and
+// rebuilds the key directory by replaying its segments.
+// Errors of the background compaction are logged with the logger.
+func openLogStorage(path string, opts LogOptions, logger *l.Logger) (*logStorage, error) {
+	if opts.SegmentSize <= 0 {
+		opts.SegmentSize = SEGMENT_SIZE
+	}
+	if opts.CompactionThreshold <= 0 {
+		opts.CompactionThreshold = COMPACTION_THRESHOLD
+	}
+	s := &logStorage{
+		path:     path,
+		opts:     opts,
+		segments: make(map[int]*logSegment),
+		keydir:   make(map[int]logLocation),
+		logger:   logger,
+		done:     make(chan struct{}),
+	}
+
+	entries, err := os.ReadDir(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	var ids []int
+	for _, entry := range entries {
+		match := segmentPattern.FindStringSubmatch(entry.Name())
+		if match == nil || entry.IsDir() {
+			continue
+		}
+		id, _ := strconv.Atoi(match[1])
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+
+	for i, id := range ids {
+		file, err := os.OpenFile(filepath.Join(path, segmentName(id)), os.O_RDWR, 0644)
+		if err != nil {
+			s.close()
+			return nil, fmt.Errorf("error opening file: %w", err)
+		}
+		segment := &logSegment{id: id, file: file}
+		s.segments[id] = segment
+		if err := s.replay(segment, i == len(ids)-1); err != nil {
+			s.close()
+			return nil, err
+		}
+		if s.active != nil {
+			s.seal(s.active)
+		}
+		s.active = segment
+	}
+
+	if opts.CompactionInterval > 0 {
+		go s.compactLoop(opts.CompactionInterval)
+	}
+	return s, nil
+}
+
+// This function returns the filename of the segment.
+func segmentName(id int) string {
+	return fmt.Sprintf("%06d.log", id)
+}
+
+// This function reads the entries of the segment into the key
+// directory. A torn entry at the end of the last segment is
+// truncated, anywhere else it is reported as corruption.
+func (s *logStorage) replay(segment *logSegment, last bool) error {
+	info, err := segment.file.Stat()
+	if err != nil {
+		return err
+	}
+
+	reader := bufio.NewReader(io.NewSectionReader(segment.file, 0, info.Size()))
+	var offset int64
+	for {
+		op, id, payload, err := readLogEntry(reader)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			if !last {
+				return fmt.Errorf("segment %s: %w", segmentName(segment.id), corrupt(err))
+			}
+			if err := segment.file.Truncate(offset); err != nil {
+				return err
+			}
+			break
+		}
+		size := int64(logHeaderSize + len(payload))
+		s.apply(segment, op, id, logLocation{segment: segment.id, offset: offset, size: size})
+		offset += size
+	}
+	segment.size = offset
+	return nil
+}
+
+// This function reads the next entry of the log.
+// Returns io.EOF when there are no more entries.
+func readLogEntry(reader io.Reader) (byte, int, []byte, error) {
+	header := make([]byte, logHeaderSize)
+	if n, err := io.ReadFull(reader, header); err != nil {
+		if err == io.EOF && n == 0 {
+			return 0, 0, nil, io.EOF
+		}
+		return 0, 0, nil, fmt.Errorf("truncated entry")
+	}
+	payload := make([]byte, binary.LittleEndian.Uint32(header[4:8]))
+	if _, err := io.ReadFull(reader, payload); err != nil {
+		return 0, 0, nil, fmt.Errorf("truncated entry")
+	}
+	if checksum(header[4:], payload) != binary.LittleEndian.Uint32(header[0:4]) {
+		return 0, 0, nil, fmt.Errorf("checksum mismatch")
+	}
+	return header[8], int(int64(binary.LittleEndian.Uint64(header[9:17]))), payload, nil
+}
+
+// This function encodes an entry of the log.
+func encodeLogEntry(op byte, id int, payload []byte) []byte {
+	entry := make([]byte, logHeaderSize+len(payload))
+	binary.LittleEndian.PutUint32(entry[4:8], uint32(len(payload)))
+	entry[8] = op
+	binary.LittleEndian.PutUint64(entry[9:17], uint64(id))
+	copy(entry[logHeaderSize:], payload)
+	binary.LittleEndian.PutUint32(entry[0:4], checksum(entry[4:logHeaderSize], payload))
+	return entry
+}
+
+// This function calculates the checksum of an entry.
+func checksum(header, payload []byte) uint32 {
+	sum := crc32.ChecksumIEEE(header)
+	return crc32.Update(sum, crc32.IEEETable, payload)
+}
+
+// This function applies an entry to the key directory and
+// accounts the bytes which became reclaimable.
+// The caller must hold the lock.
+func (s *logStorage) apply(segment *logSegment, op byte, id int, location logLocation) {
+	if id > s.maxID {
+		s.maxID = id
+	}
+	if previous, ok := s.keydir[id]; ok {
+		s.segments[previous.segment].dead += previous.size
+	}
+	switch op {
+	case logPut:
+		s.keydir[id] = location
+	case logDelete:
+		delete(s.keydir, id)
+		segment.dead += location.size
+	}
+}
+
+// This function maps the segment which stopped receiving
+// appends when memory mapping is enabled.
+// The caller must hold the lock.
+func (s *logStorage) seal(segment *logSegment) {
+	if !s.opts.MemoryMap || segment.mapped != nil || segment.size == 0 {
+		return
+	}
+	data, err := mmapFile(segment.file, segment.size)
+	if err != nil {
+		return
+	}
+	segment.mapped = newMapping(data)
+}
+
+// This function appends an entry to the active segment and
+// starts a new segment when the active one is full.
+// The caller must hold the lock.
+func (s *logStorage) append(op byte, id int, payload []byte) (logLocation, error) {
+	if s.active == nil || s.active.size >= s.opts.SegmentSize {
+		next := 1
+		for id := range s.segments {
+			if id >= next {
+				next = id + 1
+			}
+		}
+		file, err := os.OpenFile(filepath.Join(s.path, segmentName(next)), os.O_RDWR|os.O_CREATE|os.O_EXCL, 0644)
+		if err != nil {
+			return logLocation{}, fmt.Errorf("error creating file: %w", err)
+		}
+		if s.active != nil {
+			s.seal(s.active)
+		}
+		s.active = &logSegment{id: next, file: file}
+		s.segments[next] = s.active
+	}
+
+	entry := encodeLogEntry(op, id, payload)
+	if _, err := s.active.file.WriteAt(entry, s.active.size); err != nil {
+		return logLocation{}, fmt.Errorf("error writing file: %w", err)
+	}
+	location := logLocation{segment: s.active.id, offset: s.active.size, size: int64(len(entry))}
+	s.active.size += location.size
+	s.apply(s.active, op, id, location)
+	return location, nil
+}
+
+// This function reads the payload of the entry at the location.
+// The payload points into the memory map of a sealed segment
+// when it is mapped.
+// The caller must hold the lock.
+func (s *logStorage) read(location logLocation) ([]byte, error) {
+	segment, ok := s.segments[location.segment]
+	if !ok {
+		return nil, fmt.Errorf("error opening file: segment %s does not exist", segmentName(location.segment))
+	}
+	var entry []byte
+	if segment.mapped != nil {
+		entry = segment.mapped.data[location.offset : location.offset+location.size]
+	} else {
+		entry = make([]byte, location.size)
+		if _, err := segment.file.ReadAt(entry, location.offset); err != nil {
+			return nil, fmt.Errorf("error reading file: %w", err)
+		}
+	}
+	if checksum(entry[4:logHeaderSize], entry[logHeaderSize:]) != binary.LittleEndian.Uint32(entry[0:4]) {
+		return nil, fmt.Errorf("segment %s: %w", segmentName(location.segment), corrupt(fmt.Errorf("checksum mismatch")))
+	}
+	return entry[logHeaderSize:], nil
+}
+
+// This function loads the latest version of the record.
+func (s *logStorage) load(id int, warm func(*models.Record)) (*models.Record, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	location, ok := s.keydir[id]
+	if !ok {
+		return nil, nil
+	}
+	payload, err := s.read(location)
+	if err != nil {
+		return nil, err
+	}
+	return decodeRecord(payload)
+}
+
+// This function loads the latest version of the records
+// under one lock. Missing records are left out.
+func (s *logStorage) loadMany(ctx context.Context, ids []int) (map[int]*models.Record, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	records := make(map[int]*models.Record, len(ids))
+	for _, id := range ids {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		location, ok := s.keydir[id]
+		if !ok {
+			continue
+		}
+		payload, err := s.read(location)
+		if err != nil {
+			return nil, err
+		}
+		record, err := decodeRecord(payload)
+		if err != nil {
+			return nil, err
+		}
+		records[id] = record
+	}
+	return records, nil
+}
+
+// This function gets the encoded latest version of the record
+// without copying it when the segment is memory mapped. The
+// mapping is then kept until release is called.
+func (s *logStorage) raw(id int) ([]byte, func(), error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	location, ok := s.keydir[id]
+	if !ok {
+		return nil, nil, nil
+	}
+	data, err := s.read(location)
+	if err != nil {
+		return nil, nil, err
+	}
+	mapped := s.segments[location.segment].mapped
+	if mapped == nil {
+		return data, nil, nil
+	}
+	mapped.acquire()
+	return data, mapped.release, nil
+}
+
+// This function scans the latest version of the records between
+// the ids. Every record is read under its own lock so fn can run
+// concurrently with writes and compaction.
+func (s *logStorage) scan(from, to int, fn func(*models.Record) error) error {
+	s.mu.RLock()
+	ids := make([]int, 0, len(s.keydir))
+	for id := range s.keydir {
+		if id >= from && id <= to {
+			ids = append(ids, id)
+		}
+	}
+	s.mu.RUnlock()
+	sort.Ints(ids)
+
+	for _, id := range ids {
+		record, err := s.load(id, nil)
+		if err != nil {
+			return err
+		}
+		if record == nil {
+			continue
+		}
+		if err := fn(record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// This function appends a new version of every record which
+// changed since it was flushed.
+func (s *logStorage) write(ctx context.Context, records map[int]*models.Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ids := make([]int, 0, len(records))
+	for id, record := range records {
+		if !record.Flushed {
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	sort.Ints(ids)
+
+	var cancelled error
+	for _, id := range ids {
+		if cancelled = ctx.Err(); cancelled != nil {
+			break
+		}
+		payload, err := json.Marshal(records[id])
+		if err != nil {
+			return fmt.Errorf("error encoding data: %v", err)
+		}
+		if _, err := s.append(logPut, id, payload); err != nil {
+			return err
+		}
+	}
+	// Entries appended before a cancellation are kept.
+	if s.active != nil {
+		if err := s.active.file.Sync(); err != nil {
+			return err
+		}
+	}
+	return cancelled
+}
+
+// This function appends a tombstone for the record.
+func (s *logStorage) remove(id int) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.keydir[id]; !ok {
+		return false, nil
+	}
+	if _, err := s.append(logDelete, id, nil); err != nil {
+		return false, err
+	}
+	return true, s.active.file.Sync()
+}
+
+// This function gets the highest id ever written to the log.
+func (s *logStorage) lastID() (int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.maxID, nil
+}
+
+// This function stops the background compaction and
+// closes the segment files. The last error of the background
+// compaction is returned when closing succeeded.
+func (s *logStorage) close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	select {
+	case <-s.done:
+	default:
+		close(s.done)
+	}
+
+	var err error
+	for _, segment := range s.segments {
+		if segment.mapped != nil {
+			segment.mapped.release()
+		}
+		if closeErr := segment.file.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}
+	s.segments = make(map[int]*logSegment)
+	s.active = nil
+	if err == nil {
+		err = s.err
+	}
+	s.err = nil
+	return err
+}
+
+// This function runs the compaction every interval
+// until the storage is closed. A failed run is logged and
+// kept to be returned by close.
+func (s *logStorage) compactLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			if _, _, err := s.compact(context.Background(), false); err != nil {
+				s.logger.Warn("error compacting log '%s': %v", s.path, err)
+				s.mu.Lock()
+				s.err = err
+				s.mu.Unlock()
+			}
+		}
+	}
+}
+
+// This function rewrites the live entries of the sealed segments
+// whose reclaimable ratio reached the threshold and removes them.
+// When all is set the active segment is sealed and every segment
+// is compacted regardless of the threshold. The lock is taken for
+// one segment at a time so reads continue during the compaction.
+// Returns the number of segments compacted and bytes reclaimed.
+func (s *logStorage) compact(ctx context.Context, all bool) (int, int64, error) {
+	s.mu.Lock()
+	if all && s.active != nil {
+		s.seal(s.active)
+		s.active = nil
+	}
+
+	var ids []int
+	for id, segment := range s.segments {
+		if segment == s.active {
+			continue
+		}
+		if all || (segment.size > 0 && float64(segment.dead) >= s.opts.CompactionThreshold*float64(segment.size)) {
+			ids = append(ids, id)
+		}
+	}
+	s.mu.Unlock()
+	sort.Ints(ids)
+
+	var compacted int
+	var reclaimed int64
+	for _, id := range ids {
+		if err := ctx.Err(); err != nil {
+			return compacted, reclaimed, err
+		}
+		s.mu.Lock()
+		segment, ok := s.segments[id]
+		if !ok {
+			// Compacted by a concurrent run.
+			s.mu.Unlock()
+			continue
+		}
+		bytes, err := s.rewrite(segment)
+		if err == nil {
+			compacted++
+			reclaimed += bytes
+			s.stats.SegmentsCompacted++
+			s.stats.BytesReclaimed += bytes
+		}
+		s.mu.Unlock()
+		if err != nil {
+			return compacted, reclaimed, err
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stats.Runs++
+	s.stats.LastRun = time.Now()
+	return compacted, reclaimed, nil
+}
+
+// This function compacts every segment of the log. The last
+// error of the background compaction is returned with its own.
+func (s *logStorage) vacuum(ctx context.Context) (VacuumStats, error) {
+	s.mu.RLock()
+	before := len(s.segments)
+	s.mu.RUnlock()
+
+	compacted, reclaimed, err := s.compact(ctx, true)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	err = errors.Join(err, s.err)
+	s.err = nil
+	rewritten := len(s.segments) - (before - compacted)
+	if rewritten < 0 {
+		rewritten = 0
+	}
+	return VacuumStats{FilesRemoved: compacted, FilesRewritten: rewritten, BytesReclaimed: reclaimed}, err
+}
+
+// This function copies the live entries of the segment to the
+// active segment and removes the segment file.
+// Tombstones are kept while an older segment may still hold
+// a version of the deleted record.
+// Returns the number of bytes reclaimed.
+// The caller must hold the lock.
+func (s *logStorage) rewrite(segment *logSegment) (int64, error) {
+	oldest := true
+	for id := range s.segments {
+		if id < segment.id {
+			oldest = false
+		}
+	}
+
+	reader := bufio.NewReader(io.NewSectionReader(segment.file, 0, segment.size))
+	var offset, copied int64
+	for offset < segment.size {
+		op, id, payload, err := readLogEntry(reader)
+		if err != nil {
+			return 0, fmt.Errorf("segment %s: %w", segmentName(segment.id), corrupt(err))
+		}
+		size := int64(logHeaderSize + len(payload))
+
+		live := false
+		switch op {
+		case logPut:
+			location, ok := s.keydir[id]
+			live = ok && location.segment == segment.id && location.offset == offset
+		case logDelete:
+			_, exists := s.keydir[id]
+			live = !exists && !oldest
+		}
+		if live {
+			// The copied entry replaces the original one
+			// which must not be accounted as reclaimable.
+			if op == logPut {
+				delete(s.keydir, id)
+			}
+			if _, err := s.append(op, id, payload); err != nil {
+				return 0, err
+			}
+			copied += size
+		}
+		offset += size
+	}
+
+	if s.active != nil {
+		if err := s.active.file.Sync(); err != nil {
+			return 0, err
+		}
+	}
+	if segment.mapped != nil {
+		segment.mapped.release()
+	}
+	if err := segment.file.Close(); err != nil {
+		return 0, err
+	}
+	if err := os.Remove(filepath.Join(s.path, segmentName(segment.id))); err != nil {
+		return 0, err
+	}
+	delete(s.segments, segment.id)
+	return segment.size - copied, nil
+}
+
+// This function returns the statistics of the compaction.
+func (s *logStorage) compactionStats() CompactionStats {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.stats
+}
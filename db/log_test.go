@@ -0,0 +1,152 @@
+package db
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/OmerMohideen/minibase/logger"
+	"github.com/OmerMohideen/minibase/models"
+)
+
+func newLogCollection(t *testing.T, dir string) *Collection {
+	collection := NewCollection("test_collection", logger.New(nil, nil))
+	collection.SetDir(dir)
+	opts := DefaultLogOptions()
+	opts.SegmentSize = 256
+	opts.CompactionInterval = 0
+	if err := collection.SetLogOptions(opts); err != nil {
+		t.Fatalf("SetLogOptions() failed: %v", err)
+	}
+	if err := collection.SetStorageMode(LogStorage); err != nil {
+		t.Fatalf("SetStorageMode() failed: %v", err)
+	}
+	t.Cleanup(func() { collection.Close() })
+	return collection
+}
+
+func TestCollection_LogStorage(t *testing.T) {
+	tempDir := t.TempDir()
+	collection := newLogCollection(t, tempDir)
+
+	collection.InsertRecord(&models.Record{Fields: map[string]interface{}{"name": "Sajith", "age": 30}})
+	collection.InsertRecord(&models.Record{Fields: map[string]interface{}{"name": "Mahinda", "age": 35}})
+	collection.InsertRecord(&models.Record{Fields: map[string]interface{}{"name": "Anura", "age": 40}})
+	if err := collection.FlushRecords(); err != nil {
+		t.Fatalf("FlushRecords() failed: %v", err)
+	}
+	if err := collection.UpdateRecord(2, &models.Record{Fields: map[string]interface{}{"name": "Ranil", "age": 45}}); err != nil {
+		t.Fatalf("UpdateRecord() failed: %v", err)
+	}
+	if err := collection.FlushRecords(); err != nil {
+		t.Fatalf("FlushRecords() failed: %v", err)
+	}
+	if err := collection.DeleteRecord(3); err != nil {
+		t.Fatalf("DeleteRecord() failed: %v", err)
+	}
+	collection.Close()
+
+	newcollection := NewCollection("test_collection", logger.New(nil, nil))
+	newcollection.SetDir(tempDir)
+	defer newcollection.Close()
+
+	if newcollection.mode != LogStorage {
+		t.Fatalf("SetDir() failed: Expected the stored storage mode to be used, got %s", newcollection.mode)
+	}
+	if newcollection.nextID != 4 {
+		t.Errorf("SetDir() failed: Expected next id 4, got %d", newcollection.nextID)
+	}
+	record, err := newcollection.GetRecordByID(2)
+	if err != nil {
+		t.Fatalf("GetRecordByID() failed: %v", err)
+	}
+	if name, _ := record.GetField("name"); name != "Ranil" {
+		t.Errorf("GetRecordByID() failed: Expected the latest version 'Ranil', got '%v'", name)
+	}
+	if _, err := newcollection.GetRecordByID(3); err == nil {
+		t.Errorf("GetRecordByID() failed: Deleted record was returned")
+	}
+}
+
+func TestCollection_Compact(t *testing.T) {
+	tempDir := t.TempDir()
+	collection := newLogCollection(t, tempDir)
+
+	for i := 0; i < 20; i++ {
+		collection.InsertRecord(&models.Record{Fields: map[string]interface{}{"age": i}})
+	}
+	if err := collection.FlushRecords(); err != nil {
+		t.Fatalf("FlushRecords() failed: %v", err)
+	}
+	for id := 1; id <= 15; id++ {
+		if err := collection.DeleteRecord(id); err != nil {
+			t.Fatalf("DeleteRecord() failed: %v", err)
+		}
+	}
+
+	before := segmentBytes(t, filepath.Join(tempDir, "test_collection"))
+	if err := collection.Compact(); err != nil {
+		t.Fatalf("Compact() failed: %v", err)
+	}
+	after := segmentBytes(t, filepath.Join(tempDir, "test_collection"))
+
+	stats := collection.CompactionStats()
+	if stats.Runs != 1 || stats.SegmentsCompacted == 0 {
+		t.Errorf("Compact() failed: Unexpected stats %+v", stats)
+	}
+	if stats.BytesReclaimed != before-after {
+		t.Errorf("Compact() failed: Expected %d bytes reclaimed, got %d", before-after, stats.BytesReclaimed)
+	}
+	collection.Close()
+
+	newcollection := newLogCollection(t, tempDir)
+	for id := 1; id <= 20; id++ {
+		err := newcollection.LoadRecord(id)
+		_, ok := newcollection.records[id]
+		if err != nil || ok != (id > 15) {
+			t.Errorf("Compact() failed: Unexpected state of record %d after reopening: %v", id, err)
+		}
+	}
+}
+
+func segmentBytes(t *testing.T, path string) int64 {
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var size int64
+	for _, entry := range entries {
+		if !segmentPattern.MatchString(entry.Name()) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			t.Fatal(err)
+		}
+		size += info.Size()
+	}
+	return size
+}
+
+func TestCollection_VacuumLog(t *testing.T) {
+	tempDir := t.TempDir()
+	collection := newLogCollection(t, tempDir)
+
+	for i := 0; i < 20; i++ {
+		collection.InsertRecord(&models.Record{Fields: map[string]interface{}{"age": i}})
+	}
+	collection.FlushRecords()
+	for id := 1; id <= 10; id++ {
+		collection.DeleteRecord(id)
+	}
+
+	before := segmentBytes(t, filepath.Join(tempDir, "test_collection"))
+	stats, err := collection.Vacuum()
+	if err != nil {
+		t.Fatalf("Vacuum() failed: %v", err)
+	}
+	after := segmentBytes(t, filepath.Join(tempDir, "test_collection"))
+	if stats.BytesReclaimed != before-after || stats.FilesRemoved == 0 {
+		t.Errorf("Vacuum() failed: Unexpected stats %+v, %d bytes reclaimed", stats, before-after)
+	}
+}
@@ -0,0 +1,60 @@
+package db
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/OmerMohideen/minibase/models"
+)
+
+// Name of the metadata file inside the collection directory.
+const META_FILE = "meta.json"
+
+// metadata represents the persisted settings of a collection.
+type metadata struct {
+	Storage     StorageMode        `json:"storage"`
+	Schema      *models.Schema     `json:"schema,omitempty"`
+	Validation  ValidationMode     `json:"validation,omitempty"`
+	KeyStrategy KeyStrategy        `json:"keyStrategy,omitempty"`
+	ChangeFeed  *ChangeFeedOptions `json:"changeFeed,omitempty"`
+	Audit       *AuditOptions      `json:"audit,omitempty"`
+	History     *HistoryOptions    `json:"history,omitempty"`
+	SoftDelete  *SoftDeleteOptions `json:"softDelete,omitempty"`
+	// Version all saved records have been migrated to.
+	SchemaVersion int `json:"schemaVersion,omitempty"`
+}
+
+// This function reads the metadata of the collection directory.
+// Returns nil if the collection has no metadata yet.
+func readMetadata(path string) (*metadata, error) {
+	data, err := os.ReadFile(filepath.Join(path, META_FILE))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error opening file: %w", err)
+	}
+
+	var meta metadata
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return nil, corrupt(err)
+	}
+	return &meta, nil
+}
+
+// This function saves the metadata to the collection directory.
+// The file is replaced atomically.
+func writeMetadata(path string, meta *metadata) error {
+	data, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding data: %v", err)
+	}
+
+	tmp := filepath.Join(path, META_FILE+".tmp")
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("error creating file: %w", err)
+	}
+	return os.Rename(tmp, filepath.Join(path, META_FILE))
+}
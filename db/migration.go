@@ -0,0 +1,199 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/OmerMohideen/minibase/models"
+)
+
+// Migration upgrades the fields of a record from the previous
+// version to Version. Versions start at 1, records saved before
+// any migration are at version 0.
+type Migration struct {
+	Version     int
+	Description string
+	Up          func(record *models.Record) error
+}
+
+// MigrationChange describes a record upgraded by a migration run.
+type MigrationChange struct {
+	ID      int
+	From    int
+	To      int
+	Changes []models.FieldChange
+}
+
+// MigrationReport represents the work done by Migrate.
+type MigrationReport struct {
+	Version  int
+	Scanned  int
+	Upgraded int
+	Changes  []MigrationChange
+}
+
+// This function registers a migration of the collection.
+// Records older than the migration are upgraded when they are
+// loaded, or all at once by Migrate.
+func (c *Collection) RegisterMigration(migration Migration) error {
+	if migration.Version < 1 {
+		return fmt.Errorf("migration version must be at least 1, got %d", migration.Version)
+	}
+	if migration.Up == nil {
+		return fmt.Errorf("migration %d has no upgrade function", migration.Version)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, registered := range c.migrations {
+		if registered.Version == migration.Version {
+			return fmt.Errorf("migration %d is already registered: %w", migration.Version, ErrConflict)
+		}
+	}
+	c.migrations = append(c.migrations, migration)
+	sort.Slice(c.migrations, func(i, j int) bool { return c.migrations[i].Version < c.migrations[j].Version })
+	return nil
+}
+
+// This function gets the version every saved record has been
+// migrated to by Migrate.
+func (c *Collection) SchemaVersion() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.schemaVersion
+}
+
+// This function upgrades every saved record older than the last
+// registered migration and saves it. With dryRun nothing is saved
+// and the report tells what would change. Writers are blocked while
+// the migration runs. Records in the memory are already upgraded
+// and are saved by the next flush.
+func (c *Collection) Migrate(dryRun bool) (MigrationReport, error) {
+	return c.MigrateContext(context.Background(), dryRun)
+}
+
+// This function is Migrate with a context. The migration
+// stops between records when the context is done, batches of
+// upgrades saved before are kept and the version of the
+// collection is left unchanged.
+func (c *Collection) MigrateContext(ctx context.Context, dryRun bool) (MigrationReport, error) {
+	if err := c.ready(ctx); err != nil {
+		return MigrationReport{}, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	report := MigrationReport{Version: c.targetVersion()}
+	var upgraded []*models.Record
+	before := make(map[int]*models.Record)
+	err := c.store.scan(1, math.MaxInt, func(record *models.Record) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		report.Scanned++
+		if _, ok := c.records[record.ID]; ok || record.SchemaVersion >= report.Version {
+			return nil
+		}
+		old := record.Copy()
+		if err := upgradeRecord(c.migrations, report.Version, record); err != nil {
+			return err
+		}
+		report.Upgraded++
+		report.Changes = append(report.Changes, MigrationChange{
+			ID:      record.ID,
+			From:    old.SchemaVersion,
+			To:      record.SchemaVersion,
+			Changes: models.Diff(old.Fields, record.Fields),
+		})
+		record.Flushed = false
+		upgraded = append(upgraded, record)
+		if c.watched() {
+			before[record.ID] = old
+		}
+		return nil
+	})
+	if err != nil || dryRun {
+		return report, err
+	}
+
+	// The records are saved once the scan is done, a write during
+	// the scan could wait for a vacuum which waits for the scan.
+	for start := 0; start < len(upgraded); start += MAX_CHUNK {
+		end := start + MAX_CHUNK
+		if end > len(upgraded) {
+			end = len(upgraded)
+		}
+		batch := make(map[int]*models.Record, end-start)
+		for _, record := range upgraded[start:end] {
+			batch[record.ID] = record
+		}
+		if err := c.store.write(ctx, batch); err != nil {
+			return report, err
+		}
+		for id, record := range batch {
+			c.changed(ctx, ChangeUpdate, id, before[id], record)
+		}
+		err := c.commitChanges(func(event ChangeEvent) bool { return batch[event.ID] != nil })
+		if err != nil {
+			return report, err
+		}
+	}
+
+	c.schemaVersion = report.Version
+	return report, c.saveMetadata()
+}
+
+// This function upgrades a record read from the storage.
+// A record which changed is marked as not flushed so the next
+// flush saves the upgrade and sends its change.
+// The caller must hold the lock.
+func (c *Collection) upgradeLoaded(ctx context.Context, record *models.Record) error {
+	target := c.targetVersion()
+	if record.SchemaVersion >= target {
+		return nil
+	}
+	var before *models.Record
+	if c.watched() {
+		before = record.Copy()
+	}
+	if err := upgradeRecord(c.migrations, target, record); err != nil {
+		return err
+	}
+	record.Flushed = false
+	c.changed(ctx, ChangeUpdate, record.ID, before, record)
+	return nil
+}
+
+// This function gets the version new records are saved with.
+// The caller must hold the lock.
+func (c *Collection) targetVersion() int {
+	version := c.schemaVersion
+	if n := len(c.migrations); n > 0 && c.migrations[n-1].Version > version {
+		version = c.migrations[n-1].Version
+	}
+	return version
+}
+
+// This function applies the migrations newer than the record up to
+// the target version.
+func upgradeRecord(migrations []Migration, target int, record *models.Record) error {
+	for _, migration := range migrations {
+		if migration.Version <= record.SchemaVersion || migration.Version > target {
+			continue
+		}
+		if err := migration.Up(record); err != nil {
+			return fmt.Errorf("error migrating record %d to version %d: %w", record.ID, migration.Version, err)
+		}
+		if err := record.Normalize(); err != nil {
+			return fmt.Errorf("error migrating record %d to version %d: %w", record.ID, migration.Version, err)
+		}
+		record.SchemaVersion = migration.Version
+	}
+	if record.SchemaVersion < target {
+		record.SchemaVersion = target
+	}
+	return nil
+}
@@ -0,0 +1,132 @@
+package db
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/OmerMohideen/minibase/logger"
+	"github.com/OmerMohideen/minibase/models"
+)
+
+func TestCollection_Migrate(t *testing.T) {
+	for _, mode := range []StorageMode{ChunkStorage, LogStorage, BTreeStorage} {
+		logger, tempDir := logger.New(nil, nil), t.TempDir()
+		collection := NewCollection("test_collection", logger)
+		collection.SetDir(tempDir)
+		collection.SetStorageMode(mode)
+		for i := 0; i < 3; i++ {
+			collection.InsertRecord(&models.Record{Fields: map[string]interface{}{"address_city": "Colombo", "age": "30"}})
+		}
+		collection.FlushRecords()
+		collection.Close()
+
+		newcollection := NewCollection("test_collection", logger)
+		newcollection.SetDir(tempDir)
+		newcollection.RegisterMigration(Migration{Version: 1, Description: "nest city", Up: func(record *models.Record) error {
+			city, _ := record.GetField("address_city")
+			record.UnsetField("address_city")
+			return record.SetField("address.city", city)
+		}})
+		newcollection.RegisterMigration(Migration{Version: 2, Description: "age as int", Up: func(record *models.Record) error {
+			record.SetField("age", 30)
+			return nil
+		}})
+		if err := newcollection.RegisterMigration(Migration{Version: 2, Up: func(*models.Record) error { return nil }}); err == nil {
+			t.Errorf("RegisterMigration() failed: Expected error for a duplicate version")
+		}
+
+		record, err := newcollection.GetRecordByID(1)
+		if err != nil {
+			t.Fatalf("GetRecordByID() failed: %v", err)
+		}
+		if city, _ := record.GetField("address.city"); city != "Colombo" || record.SchemaVersion != 2 || record.Flushed {
+			t.Errorf("GetRecordByID() failed: %s storage did not upgrade the record: %+v", mode, record)
+		}
+
+		report, err := newcollection.Migrate(true)
+		if err != nil {
+			t.Fatalf("Migrate() failed: %v", err)
+		}
+		if report.Scanned != 3 || report.Upgraded != 2 || len(report.Changes) != 2 || len(report.Changes[0].Changes) != 3 {
+			t.Errorf("Migrate() failed: %s storage returned unexpected dry run report %+v", mode, report)
+		}
+		if newcollection.SchemaVersion() != 0 {
+			t.Errorf("Migrate() failed: Dry run changed the schema version")
+		}
+
+		if _, err := newcollection.Migrate(false); err != nil {
+			t.Fatalf("Migrate() failed: %v", err)
+		}
+		newcollection.FlushRecords()
+		newcollection.Close()
+
+		reopened := NewCollection("test_collection", logger)
+		reopened.SetDir(tempDir)
+		if reopened.SchemaVersion() != 2 {
+			t.Errorf("Migrate() failed: %s storage did not save the schema version", mode)
+		}
+		report, _ = reopened.Migrate(true)
+		if report.Upgraded != 0 {
+			t.Errorf("Migrate() failed: %s storage left %d records behind", mode, report.Upgraded)
+		}
+		record, _ = reopened.GetRecordByID(3)
+		if age, _ := record.GetField("age"); age != int64(30) {
+			t.Errorf("Migrate() failed: %s storage did not save the upgrade, got %+v", mode, record)
+		}
+		reopened.Close()
+	}
+}
+
+func TestCollection_MigrateWithVacuum(t *testing.T) {
+	logger, tempDir := logger.New(nil, nil), t.TempDir()
+	collection := NewCollection("test_collection", logger)
+	collection.SetDir(tempDir)
+	defer collection.Close()
+	for i := 0; i < 2*MAX_CHUNK; i++ {
+		collection.InsertRecord(&models.Record{Fields: map[string]interface{}{"age": i}})
+	}
+	collection.FlushRecords()
+	collection.records = make(map[int]*models.Record)
+
+	// A vacuum holds the write lock and waits for the files
+	// while the migration scans them.
+	store := collection.store.(*chunkStorage)
+	var once sync.Once
+	collection.RegisterMigration(Migration{Version: 1, Up: func(record *models.Record) error {
+		once.Do(func() {
+			locked := make(chan struct{})
+			go func() {
+				store.writeMu.Lock()
+				close(locked)
+				store.files.Lock()
+				store.files.Unlock()
+				store.writeMu.Unlock()
+			}()
+			<-locked
+		})
+		return record.SetField("migrated", true)
+	}})
+
+	done := make(chan error)
+	go func() {
+		_, err := collection.Migrate(false)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Migrate() failed: %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatalf("Migrate() failed: Deadlocked with a vacuum")
+	}
+	collection.records = make(map[int]*models.Record)
+	record, err := collection.GetRecordByID(2 * MAX_CHUNK)
+	if err != nil {
+		t.Fatalf("GetRecordByID() failed: %v", err)
+	}
+	if migrated, _ := record.GetField("migrated"); migrated != true {
+		t.Errorf("Migrate() failed: Last record was not saved")
+	}
+}
@@ -0,0 +1,18 @@
+//go:build !unix
+
+package db
+
+import (
+	"errors"
+	"os"
+)
+
+// This function reports that memory mapping is unavailable,
+// segments are read with regular reads instead.
+func mmapFile(file *os.File, size int64) ([]byte, error) {
+	return nil, errors.New("memory mapping is not supported")
+}
+
+func munmapFile(data []byte) error {
+	return nil
+}
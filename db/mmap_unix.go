@@ -0,0 +1,18 @@
+//go:build unix
+
+package db
+
+import (
+	"os"
+	"syscall"
+)
+
+// This function maps the first size bytes of the file read-only.
+func mmapFile(file *os.File, size int64) ([]byte, error) {
+	return syscall.Mmap(int(file.Fd()), 0, int(size), syscall.PROT_READ, syscall.MAP_SHARED)
+}
+
+// This function releases a mapping made by mmapFile.
+func munmapFile(data []byte) error {
+	return syscall.Munmap(data)
+}
@@ -0,0 +1,55 @@
+package db
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/OmerMohideen/minibase/models"
+)
+
+// This function applies the update operators of the patch to
+// the record and gets its new version, see models.Patch.
+// The patch is applied under the lock of the collection so
+// concurrent patches don't lose each other's changes, and the
+// record is replaced once like UpdateRecord does. Nothing is
+// changed when an operator fails or the result is invalid.
+func (c *Collection) Patch(id int, patch models.Patch) (*models.Record, error) {
+	return c.PatchContext(context.Background(), id, patch)
+}
+
+// This function is Patch with a context.
+func (c *Collection) PatchContext(ctx context.Context, id int, patch models.Patch) (*models.Record, error) {
+	if err := c.ready(ctx); err != nil {
+		return nil, err
+	}
+	if err := c.LoadRecordContext(ctx, id); err != nil {
+		return nil, fmt.Errorf("error loading record: %w", err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.patchRecord(ctx, id, patch)
+}
+
+// This function applies the patch to the record with the id.
+// The caller must hold the lock.
+func (c *Collection) patchRecord(ctx context.Context, id int, patch models.Patch) (*models.Record, error) {
+	if err := patch.Check(); err != nil {
+		return nil, invalid(err)
+	}
+	oldRecord, err := c.liveRecord(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if oldRecord == nil {
+		return nil, fmt.Errorf("record with ID '%d' does not exist: %w", id, ErrNotFound)
+	}
+	record := oldRecord.Copy()
+	if err := record.Apply(patch); err != nil {
+		return nil, fmt.Errorf("error patching record %d: %w", id, invalid(err))
+	}
+	if err := c.replaceRecord(ctx, ChangePatch, oldRecord, record); err != nil {
+		return nil, err
+	}
+	return record, nil
+}
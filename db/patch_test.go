@@ -0,0 +1,71 @@
+package db
+
+import (
+	"errors"
+	"math"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/OmerMohideen/minibase/logger"
+	"github.com/OmerMohideen/minibase/models"
+)
+
+func TestCollection_Patch(t *testing.T) {
+	logger, tempDir := logger.New(nil, nil), t.TempDir()
+	collection := NewCollection("test_collection", logger)
+	collection.SetDir(tempDir)
+	defer collection.Close()
+
+	collection.InsertRecord(&models.Record{Fields: map[string]interface{}{"name": "Sajith", "views": 0}})
+	if err := collection.FlushRecords(); err != nil {
+		t.Fatalf("FlushRecords() failed: %v", err)
+	}
+	collection.records = make(map[int]*models.Record)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := collection.Patch(1, models.Patch{"$inc": {"views": 1}}); err != nil {
+				t.Errorf("Patch() failed: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	record, err := collection.Patch(1, models.Patch{"$set": {"name": "Anura"}, "$push": {"tags": "new"}})
+	if err != nil {
+		t.Fatalf("Patch() failed: %v", err)
+	}
+	expected := map[string]interface{}{"name": "Anura", "views": int64(50), "tags": []interface{}{"new"}}
+	if !reflect.DeepEqual(record.Fields, expected) {
+		t.Errorf("Patch() failed: Expected %#v, got %#v", expected, record.Fields)
+	}
+	if record.Flushed || record.UpdatedAt.Before(record.CreatedAt) {
+		t.Errorf("Patch() failed: Record was not updated")
+	}
+	if err := collection.FlushRecords(); err != nil {
+		t.Fatalf("FlushRecords() failed: %v", err)
+	}
+	collection.records = make(map[int]*models.Record)
+	if saved, _ := collection.GetRecordByID(1); saved == nil || !reflect.DeepEqual(saved.Fields, expected) {
+		t.Errorf("Patch() failed: Patched record was not saved")
+	}
+	record, _ = collection.GetRecordByID(1)
+
+	if _, err := collection.Patch(1, models.Patch{"$inc": {"name": 1}}); err == nil {
+		t.Errorf("Patch() failed: Expected an error incrementing a string")
+	}
+	_, err = collection.Patch(1, models.Patch{"$inc": {"views": int64(math.MaxInt64)}})
+	if !errors.Is(err, ErrValidation) || !errors.Is(err, models.ErrOverflow) {
+		t.Errorf("Patch() failed: Expected an overflow validation error, got %v", err)
+	}
+	if current, _ := collection.GetRecordByID(1); current != record {
+		t.Errorf("Patch() failed: Failed patch changed the record")
+	}
+	if _, err := collection.Patch(2, models.Patch{"$set": {"name": "Anura"}}); err == nil {
+		t.Errorf("Patch() failed: Expected an error patching a missing record")
+	}
+}
@@ -0,0 +1,144 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/OmerMohideen/minibase/btree"
+	l "github.com/OmerMohideen/minibase/logger"
+	"github.com/OmerMohideen/minibase/models"
+)
+
+// StorageMode represents how a collection saves its records.
+type StorageMode int
+
+const (
+	// Records are partitioned into JSON chunk files by their id.
+	ChunkStorage StorageMode = iota
+	// Records are appended to log segments as new versions and
+	// tombstones, which are compacted in the background.
+	LogStorage
+	// Records are saved in a B+tree file keyed by their id.
+	BTreeStorage
+)
+
+// This function returns the name of the storage mode.
+func (m StorageMode) String() string {
+	switch m {
+	case ChunkStorage:
+		return "chunk"
+	case LogStorage:
+		return "log"
+	case BTreeStorage:
+		return "btree"
+	}
+	return fmt.Sprintf("StorageMode(%d)", int(m))
+}
+
+// This function encodes the storage mode by its name.
+func (m StorageMode) MarshalText() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+// This function decodes the storage mode from its name.
+func (m *StorageMode) UnmarshalText(text []byte) error {
+	mode, err := ParseStorageMode(string(text))
+	if err != nil {
+		return err
+	}
+	*m = mode
+	return nil
+}
+
+// This function parses the name of a storage mode.
+func ParseStorageMode(name string) (StorageMode, error) {
+	for _, mode := range []StorageMode{ChunkStorage, LogStorage, BTreeStorage} {
+		if mode.String() == name {
+			return mode, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown storage mode '%s'", name)
+}
+
+// VacuumStats represents the work done by a vacuum.
+type VacuumStats struct {
+	FilesRemoved   int
+	FilesRewritten int
+	BytesReclaimed int64
+}
+
+// errStopScan is returned by a scan function to end the scan early.
+var errStopScan = errors.New("stop scan")
+
+// storage represents the on-disk layout of a collection.
+type storage interface {
+	// load returns the record with the id or nil if it doesn't exist.
+	// warm receives other decoded records which can be cached,
+	// it is nil when prefetching is disabled.
+	load(id int, warm func(*models.Record)) (*models.Record, error)
+	// loadMany returns the records with the ids which exist.
+	loadMany(ctx context.Context, ids []int) (map[int]*models.Record, error)
+	// raw returns the encoded record or nil if it doesn't exist.
+	// The data may point into a memory map and must not be modified,
+	// release is then called once it is not used anymore. Release is
+	// nil when the data is a copy.
+	raw(id int) (data []byte, release func(), err error)
+	// write saves the records of the memory. A cancelled write
+	// may have saved some of the records.
+	write(ctx context.Context, records map[int]*models.Record) error
+	// remove deletes the record and reports whether it was stored.
+	remove(id int) (bool, error)
+	// scan calls fn with the records between the ids in order,
+	// both ids are inclusive. The scan stops at the first error.
+	scan(from, to int, fn func(*models.Record) error) error
+	// vacuum reclaims the space left by deleted records.
+	vacuum(ctx context.Context) (VacuumStats, error)
+	// lastID returns the highest id ever saved.
+	lastID() (int, error)
+	// close releases the files held by the storage.
+	close() error
+}
+
+// This function creates the storage of the mode in the directory.
+func openStorage(mode StorageMode, path string, logOpts LogOptions, treeOpts btree.Options, logger *l.Logger) (storage, error) {
+	switch mode {
+	case ChunkStorage:
+		return newChunkStorage(path), nil
+	case LogStorage:
+		return openLogStorage(path, logOpts, logger)
+	case BTreeStorage:
+		return openTreeStorage(path, treeOpts)
+	}
+	return nil, fmt.Errorf("unknown storage mode %d", int(mode))
+}
+
+// unavailableStorage is used when the storage of a collection
+// could not be opened, every operation returns the error.
+type unavailableStorage struct {
+	err error
+}
+
+func (s unavailableStorage) load(int, func(*models.Record)) (*models.Record, error) {
+	return nil, s.err
+}
+
+func (s unavailableStorage) loadMany(context.Context, []int) (map[int]*models.Record, error) {
+	return nil, s.err
+}
+
+func (s unavailableStorage) raw(int) ([]byte, func(), error) { return nil, nil, s.err }
+
+func (s unavailableStorage) write(context.Context, map[int]*models.Record) error { return s.err }
+
+func (s unavailableStorage) remove(int) (bool, error) { return false, s.err }
+
+func (s unavailableStorage) scan(int, int, func(*models.Record) error) error { return s.err }
+
+func (s unavailableStorage) vacuum(context.Context) (VacuumStats, error) {
+	return VacuumStats{}, s.err
+}
+
+func (s unavailableStorage) lastID() (int, error) { return 0, s.err }
+
+func (s unavailableStorage) close() error { return nil }
@@ -0,0 +1,280 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/OmerMohideen/minibase/models"
+)
+
+// SoftDeleteOptions represents the settings of the trash. With soft
+// deletes a deleted record is hidden from reads and queries but kept
+// with its DeletedAt until it is restored or purged.
+type SoftDeleteOptions struct {
+	Enabled bool `json:"enabled"`
+	// Age after which deleted records are purged, zero keeps them.
+	PurgeAfter time.Duration `json:"purgeAfter,omitempty"`
+}
+
+// This function enables or disables soft deletes. The setting is
+// saved in the metadata of the collection. Records already in the
+// trash stay hidden when it is disabled until they are purged.
+func (c *Collection) SetSoftDelete(opts SoftDeleteOptions) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.softDelete = opts
+	return c.saveMetadata()
+}
+
+// This function restores the deleted record with the id from the
+// trash. The record keeps its id and key and goes through the insert
+// hooks and the schema like a new record, and it is reported as an
+// insert since its deletion was reported as a delete. Like an update
+// the change is saved by the next flush. A record whose key was taken
+// by another record can't be restored.
+func (c *Collection) Restore(id int) error {
+	return c.RestoreContext(context.Background(), id)
+}
+
+// This function is Restore with a context.
+func (c *Collection) RestoreContext(ctx context.Context, id int) error {
+	if err := c.ready(ctx); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.records[id]; !ok {
+		record, err := c.store.load(id, nil)
+		if err != nil {
+			return fmt.Errorf("error loading record: %w", err)
+		}
+		if record == nil {
+			return fmt.Errorf("record with ID '%d' %w", id, ErrNotFound)
+		}
+		if err := c.cacheRecord(ctx, record); err != nil {
+			return err
+		}
+	}
+	deleted := c.records[id]
+	if !deleted.Deleted() {
+		return fmt.Errorf("record with ID '%d' in the trash %w", id, ErrNotFound)
+	}
+	other, ok, err := c.keyID(deleted.Key)
+	if err != nil {
+		return err
+	}
+	if ok && other != id {
+		return fmt.Errorf("key '%s' of record %d is used by record %d: %w", deleted.Key, id, other, ErrConflict)
+	}
+
+	record := deleted.Copy()
+	record.DeletedAt = time.Time{}
+	if err := c.runBefore(ctx, HookInsert, record); err != nil {
+		return err
+	}
+	record.Key = deleted.Key
+	if err := c.validate(id, record); err != nil {
+		return err
+	}
+	record.UpdatedAt = c.now()
+	record.Flushed = false
+	record.ExpiresAt = time.Now().Add(LIFE_SPAN)
+	c.records[id] = record
+	c.keys.add(record)
+	c.changed(ctx, ChangeInsert, id, nil, record)
+	c.runAfter(ctx, HookInsert, record)
+	return nil
+}
+
+// This function permanently removes the record with the id from
+// the trash, whatever the settings of the soft deletes are.
+func (c *Collection) Purge(id int) error {
+	return c.PurgeContext(context.Background(), id)
+}
+
+// This function is Purge with a context.
+func (c *Collection) PurgeContext(ctx context.Context, id int) error {
+	if err := c.ready(ctx); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	record, ok := c.records[id]
+	if !ok {
+		var err error
+		if record, err = c.store.load(id, nil); err != nil {
+			return fmt.Errorf("error loading record: %w", err)
+		}
+	}
+	if record == nil || !record.Deleted() {
+		return fmt.Errorf("record with ID '%d' in the trash %w", id, ErrNotFound)
+	}
+	delete(c.records, id)
+	_, err := c.store.remove(id)
+	return err
+}
+
+// This function lists the records in the trash ordered by id.
+func (c *Collection) ListDeleted() ([]*models.Record, error) {
+	return c.ListDeletedContext(context.Background())
+}
+
+// This function is ListDeleted with a context, reading the
+// records stops between records when the context is done.
+func (c *Collection) ListDeletedContext(ctx context.Context) ([]*models.Record, error) {
+	if err := c.ready(ctx); err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	store := c.store
+	c.mu.Unlock()
+
+	deleted := make(map[int]*models.Record)
+	err := store.scan(1, math.MaxInt, func(record *models.Record) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if record.Deleted() {
+			deleted[record.ID] = record
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	// The memory holds the newer versions of the records.
+	for id, record := range c.records {
+		if record.Deleted() {
+			deleted[id] = record
+		} else {
+			delete(deleted, id)
+		}
+	}
+	c.mu.Unlock()
+
+	records := make([]*models.Record, 0, len(deleted))
+	for _, record := range deleted {
+		records = append(records, record)
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].ID < records[j].ID })
+	return records, nil
+}
+
+// This function permanently removes the records deleted at least
+// PurgeAfter ago from the memory and the storage and gets how many
+// were removed. It is called by the collection every REAP_INTERVAL.
+// Without a PurgeAfter nothing is removed, use Purge to remove the
+// records one by one.
+func (c *Collection) PurgeTrash() (int, error) {
+	return c.PurgeTrashContext(context.Background())
+}
+
+// This function is PurgeTrash with a context.
+func (c *Collection) PurgeTrashContext(ctx context.Context) (int, error) {
+	if err := c.ready(ctx); err != nil {
+		return 0, err
+	}
+	c.mu.Lock()
+	store, purgeAfter := c.store, c.softDelete.PurgeAfter
+	cutoff := c.now().Add(-purgeAfter)
+	c.mu.Unlock()
+	if purgeAfter <= 0 {
+		return 0, nil
+	}
+	due := func(record *models.Record) bool {
+		return record.Deleted() && !record.DeletedAt.After(cutoff)
+	}
+
+	purge := make(map[int]bool)
+	err := store.scan(1, math.MaxInt, func(record *models.Record) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if due(record) {
+			purge[record.ID] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.store != store {
+		return 0, nil
+	}
+	for id, record := range c.records {
+		if due(record) {
+			purge[id] = true
+		}
+	}
+
+	purged := 0
+	for id := range purge {
+		// The memory may hold a restored version.
+		if record, ok := c.records[id]; ok && !due(record) {
+			continue
+		}
+		delete(c.records, id)
+		if _, err := c.store.remove(id); err != nil {
+			return purged, err
+		}
+		purged++
+	}
+	return purged, nil
+}
+
+// This function moves the record with the id to the trash.
+// The caller must hold the lock.
+func (c *Collection) trashRecord(ctx context.Context, id int) error {
+	before, err := c.liveRecord(ctx, id)
+	if err != nil {
+		return err
+	}
+	if before == nil {
+		return fmt.Errorf("record with ID %d %w", id, ErrNotFound)
+	}
+	if err := c.runBefore(ctx, HookDelete, before); err != nil {
+		return err
+	}
+
+	deleted := before.Copy()
+	deleted.DeletedAt = c.now()
+	deleted.Flushed = false
+	deleted.ExpiresAt = time.Now().Add(LIFE_SPAN)
+	c.records[id] = deleted
+	c.keys.remove(id)
+	c.changed(ctx, ChangeDelete, id, before, nil)
+	c.runAfter(ctx, HookDelete, before)
+	return nil
+}
+
+// This function fails with ErrConflict when the record with the
+// id is in the trash, so it is not overwritten by an insert with
+// its id. liveRecord must have loaded the record before.
+// The caller must hold the lock.
+func (c *Collection) checkTrash(id int) error {
+	if record, ok := c.records[id]; ok && record.Deleted() {
+		return fmt.Errorf("record with ID '%d' is in the trash, restore or purge it first: %w", id, ErrConflict)
+	}
+	return nil
+}
+
+// This function gets the error of reading a record which is
+// deleted or has expired.
+func hiddenError(record *models.Record) error {
+	if record.Deleted() {
+		return fmt.Errorf("record with ID '%d' has been deleted: %w", record.ID, ErrNotFound)
+	}
+	return fmt.Errorf("record with ID '%d' has expired: %w", record.ID, ErrNotFound)
+}
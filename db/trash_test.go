@@ -0,0 +1,190 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/OmerMohideen/minibase/logger"
+	"github.com/OmerMohideen/minibase/models"
+)
+
+func TestCollection_SoftDelete(t *testing.T) {
+	logger, tempDir := logger.New(nil, nil), t.TempDir()
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	now := start
+	collection := NewCollection("test_collection", logger)
+	collection.SetDir(tempDir)
+	collection.SetClock(func() time.Time { return now })
+
+	if err := collection.SetSoftDelete(SoftDeleteOptions{Enabled: true, PurgeAfter: time.Hour}); err != nil {
+		t.Fatalf("SetSoftDelete() failed: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		if err := collection.InsertRecord(&models.Record{Fields: map[string]interface{}{"n": i}}); err != nil {
+			t.Fatalf("InsertRecord() failed: %v", err)
+		}
+	}
+	if err := collection.FlushRecords(); err != nil {
+		t.Fatalf("FlushRecords() failed: %v", err)
+	}
+	if err := collection.DeleteRecord(2); err != nil {
+		t.Fatalf("DeleteRecord() failed: %v", err)
+	}
+	if err := collection.DeleteRecord(2); !errors.Is(err, ErrNotFound) {
+		t.Errorf("DeleteRecord() failed: Expected ErrNotFound deleting twice, got %v", err)
+	}
+	if _, err := collection.GetRecordByID(2); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetRecordByID() failed: Expected ErrNotFound for a deleted record, got %v", err)
+	}
+	if err := collection.UpdateRecord(2, &models.Record{Fields: map[string]interface{}{"n": 9}}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("UpdateRecord() failed: Expected ErrNotFound for a deleted record, got %v", err)
+	}
+	if _, missing, err := collection.GetMany([]int{1, 2, 3}); err != nil || len(missing) != 1 || missing[0] != 2 {
+		t.Errorf("GetMany() failed: Expected 2 to be missing, got %v, %v", missing, err)
+	}
+	var ids []int
+	err := collection.ScanRange(1, 3, func(record *models.Record) bool {
+		ids = append(ids, record.ID)
+		return true
+	})
+	if err != nil || len(ids) != 2 || ids[0] != 1 || ids[1] != 3 {
+		t.Errorf("ScanRange() failed: Expected records 1 and 3, got %v, %v", ids, err)
+	}
+	if err := collection.Restore(1); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Restore() failed: Expected ErrNotFound for a record not in the trash, got %v", err)
+	}
+	if err := collection.FlushRecords(); err != nil {
+		t.Fatalf("FlushRecords() failed: %v", err)
+	}
+	collection.Close()
+
+	newcollection := NewCollection("test_collection", logger)
+	newcollection.SetDir(tempDir)
+	newcollection.SetClock(func() time.Time { return now })
+	defer newcollection.Close()
+
+	if _, err := newcollection.GetRecordByID(2); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetRecordByID() failed: Expected the record to stay deleted after opening again, got %v", err)
+	}
+	deleted, err := newcollection.ListDeleted()
+	if err != nil || len(deleted) != 1 || deleted[0].ID != 2 || !deleted[0].DeletedAt.Equal(start) {
+		t.Fatalf("ListDeleted() failed: Expected record 2 deleted at %v, got %v, %v", start, deleted, err)
+	}
+	if err := newcollection.Restore(2); err != nil {
+		t.Fatalf("Restore() failed: %v", err)
+	}
+	record, err := newcollection.GetRecordByID(2)
+	if err != nil || record.Fields["n"] != int64(1) || record.Deleted() {
+		t.Errorf("Restore() failed: Expected the restored record, got %v, %v", record, err)
+	}
+	if deleted, err := newcollection.ListDeleted(); err != nil || len(deleted) != 0 {
+		t.Errorf("ListDeleted() failed: Expected an empty trash, got %v, %v", deleted, err)
+	}
+
+	if err := newcollection.DeleteRecord(1); err != nil {
+		t.Fatalf("DeleteRecord() failed: %v", err)
+	}
+	now = now.Add(30 * time.Minute)
+	if err := newcollection.DeleteRecord(3); err != nil {
+		t.Fatalf("DeleteRecord() failed: %v", err)
+	}
+	if err := newcollection.FlushRecords(); err != nil {
+		t.Fatalf("FlushRecords() failed: %v", err)
+	}
+	now = now.Add(45 * time.Minute)
+	purged, err := newcollection.PurgeTrash()
+	if err != nil || purged != 1 {
+		t.Errorf("PurgeTrash() failed: Expected 1 purged, got %d, %v", purged, err)
+	}
+	if err := newcollection.Restore(1); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Restore() failed: Expected ErrNotFound for a purged record, got %v", err)
+	}
+	if deleted, err := newcollection.ListDeleted(); err != nil || len(deleted) != 1 || deleted[0].ID != 3 {
+		t.Errorf("ListDeleted() failed: Expected record 3 in the trash, got %v, %v", deleted, err)
+	}
+
+	if err := newcollection.SetSoftDelete(SoftDeleteOptions{}); err != nil {
+		t.Fatalf("SetSoftDelete() failed: %v", err)
+	}
+	if err := newcollection.Purge(2); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Purge() failed: Expected ErrNotFound for a record not in the trash, got %v", err)
+	}
+	if err := newcollection.Purge(3); err != nil {
+		t.Fatalf("Purge() failed: %v", err)
+	}
+	if deleted, err := newcollection.ListDeleted(); err != nil || len(deleted) != 0 {
+		t.Errorf("Purge() failed: Expected an empty trash, got %v, %v", deleted, err)
+	}
+}
+
+func TestCollection_RestoreHooks(t *testing.T) {
+	collection := NewCollection("test_collection", logger.New(nil, nil))
+	collection.SetDir(t.TempDir())
+	defer collection.Close()
+
+	if err := collection.SetSoftDelete(SoftDeleteOptions{Enabled: true}); err != nil {
+		t.Fatalf("SetSoftDelete() failed: %v", err)
+	}
+	for _, name := range []string{"Sajith", "Anura"} {
+		collection.InsertRecord(&models.Record{Fields: map[string]interface{}{"name": name}})
+		collection.DeleteRecord(collection.nextID - 1)
+	}
+	errBanned := errors.New("banned")
+	var restored []int
+	collection.AddBeforeHook(HookInsert, func(ctx context.Context, record *models.Record) error {
+		if record.Fields["name"] == "Anura" {
+			return errBanned
+		}
+		return nil
+	})
+	collection.AddAfterHook(HookInsert, func(ctx context.Context, record *models.Record) {
+		restored = append(restored, record.ID)
+	})
+	schema := &models.Schema{Fields: map[string]*models.FieldSchema{"email": {Type: models.KindString, Required: true}}}
+	if err := collection.SetSchema(schema, ValidationStrict); err != nil {
+		t.Fatalf("SetSchema() failed: %v", err)
+	}
+
+	if err := collection.Restore(2); !errors.Is(err, errBanned) {
+		t.Errorf("Restore() failed: Expected the error of the hook, got %v", err)
+	}
+	if err := collection.Restore(1); !errors.Is(err, ErrValidation) {
+		t.Errorf("Restore() failed: Expected ErrValidation for a record not matching the schema, got %v", err)
+	}
+	if err := collection.SetSchema(nil, ValidationOff); err != nil {
+		t.Fatalf("SetSchema() failed: %v", err)
+	}
+	if err := collection.Restore(1); err != nil || len(restored) != 1 {
+		t.Fatalf("Restore() failed: Expected the after hook to run, got %v, %v", restored, err)
+	}
+	if record, err := collection.GetRecordByID(1); err != nil || record.Fields["name"] != "Sajith" {
+		t.Errorf("Restore() failed: Expected the record to be readable again, got %v, %v", record, err)
+	}
+}
+
+func TestCollection_SoftDeleteKey(t *testing.T) {
+	collection := NewCollection("test_collection", logger.New(nil, nil))
+	collection.SetDir(t.TempDir())
+	defer collection.Close()
+
+	if err := collection.SetKeyStrategy(KeyString); err != nil {
+		t.Fatalf("SetKeyStrategy() failed: %v", err)
+	}
+	if err := collection.SetSoftDelete(SoftDeleteOptions{Enabled: true}); err != nil {
+		t.Fatalf("SetSoftDelete() failed: %v", err)
+	}
+	if err := collection.InsertRecord(&models.Record{Key: "a", Fields: map[string]interface{}{}}); err != nil {
+		t.Fatalf("InsertRecord() failed: %v", err)
+	}
+	if err := collection.DeleteRecord(1); err != nil {
+		t.Fatalf("DeleteRecord() failed: %v", err)
+	}
+	if err := collection.InsertRecord(&models.Record{Key: "a", Fields: map[string]interface{}{}}); err != nil {
+		t.Fatalf("InsertRecord() failed: Expected the key of a deleted record to be free, got %v", err)
+	}
+	if err := collection.Restore(1); !errors.Is(err, ErrConflict) {
+		t.Errorf("Restore() failed: Expected ErrConflict for a used key, got %v", err)
+	}
+}
@@ -0,0 +1,342 @@
+package db
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/OmerMohideen/minibase/btree"
+	"github.com/OmerMohideen/minibase/models"
+)
+
+// Name of the B+tree file inside the collection directory.
+const TREE_FILE = "records.db"
+
+// Number of records read from the tree before they are
+// handed to the scan function.
+const treeScanBatch = 100
+
+// treeStorage saves records in a B+tree file keyed by their id.
+// Unlike the chunk files it doesn't depend on dense ids. The tree
+// orders any byte keys, but records are only addressed by their
+// positive int id, so the keys are the encoded ids.
+type treeStorage struct {
+	// Held by every operation on the tree, and exclusively
+	// while the tree file is opened or replaced.
+	mu   sync.RWMutex
+	path string
+	opts btree.Options
+	tree *btree.Tree
+	// Serializes the changes to the tree.
+	writeMu sync.Mutex
+}
+
+// This function opens the B+tree file of the directory.
+// The file is created by the first write.
+func openTreeStorage(path string, opts btree.Options) (*treeStorage, error) {
+	s := &treeStorage{path: path, opts: opts}
+	if _, err := os.Stat(filepath.Join(path, TREE_FILE)); os.IsNotExist(err) {
+		return s, nil
+	}
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// This function opens the tree file unless it's already open.
+func (s *treeStorage) open() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.tree != nil {
+		return nil
+	}
+	tree, err := btree.Open(filepath.Join(s.path, TREE_FILE), &s.opts)
+	if err != nil {
+		return err
+	}
+	s.tree = tree
+	return nil
+}
+
+// This function encodes the id as a key ordered like the id.
+// The id must not be negative.
+func treeKey(id int) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, uint64(id))
+	return key
+}
+
+// This function loads the record from the tree.
+func (s *treeStorage) load(id int, warm func(*models.Record)) (*models.Record, error) {
+	data, _, err := s.raw(id)
+	if err != nil || data == nil {
+		return nil, err
+	}
+	return decodeRecord(data)
+}
+
+// This function loads the records from the tree under one
+// lock. Missing records are left out.
+func (s *treeStorage) loadMany(ctx context.Context, ids []int) (map[int]*models.Record, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	records := make(map[int]*models.Record, len(ids))
+	if s.tree == nil {
+		return records, nil
+	}
+	for _, id := range ids {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		data, _, err := s.tree.Get(treeKey(id))
+		if err != nil {
+			return nil, err
+		}
+		if data == nil {
+			continue
+		}
+		record, err := decodeRecord(data)
+		if err != nil {
+			return nil, err
+		}
+		records[id] = record
+	}
+	return records, nil
+}
+
+// This function gets the encoded record from the tree.
+func (s *treeStorage) raw(id int) ([]byte, func(), error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.tree == nil {
+		return nil, nil, nil
+	}
+	data, _, err := s.tree.Get(treeKey(id))
+	return data, nil, err
+}
+
+// This function saves every record which changed since it was flushed.
+func (s *treeStorage) write(ctx context.Context, records map[int]*models.Record) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	if err := s.open(); err != nil {
+		return err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	ids := make([]int, 0, len(records))
+	for id, record := range records {
+		if !record.Flushed {
+			ids = append(ids, id)
+		}
+	}
+	sort.Ints(ids)
+
+	for _, id := range ids {
+		if err := ctx.Err(); err != nil {
+			// Keep what was written so far.
+			if syncErr := s.tree.Sync(); syncErr != nil {
+				return syncErr
+			}
+			return err
+		}
+		data, err := json.Marshal(records[id])
+		if err != nil {
+			return fmt.Errorf("error encoding data: %v", err)
+		}
+		if err := s.tree.Put(treeKey(id), data); err != nil {
+			return err
+		}
+	}
+	return s.tree.Sync()
+}
+
+// This function deletes the record from the tree.
+func (s *treeStorage) remove(id int) (bool, error) {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.tree == nil {
+		return false, nil
+	}
+	found, err := s.tree.Delete(treeKey(id))
+	if err != nil || !found {
+		return found, err
+	}
+	return true, s.tree.Sync()
+}
+
+// This function scans the records between the ids in batches,
+// so fn runs without holding the lock of the tree.
+func (s *treeStorage) scan(from, to int, fn func(*models.Record) error) error {
+	// Ids start at 1, a negative id would be encoded as a huge key.
+	if from < 1 {
+		from = 1
+	}
+	var end []byte
+	if to < math.MaxInt {
+		end = treeKey(to + 1)
+	}
+	for from <= to {
+		var batch [][]byte
+		s.mu.RLock()
+		var err error
+		if s.tree != nil {
+			err = s.tree.Scan(treeKey(from), end, func(key, data []byte) bool {
+				batch = append(batch, data)
+				from = int(binary.BigEndian.Uint64(key)) + 1
+				return len(batch) < treeScanBatch
+			})
+		}
+		s.mu.RUnlock()
+		if err != nil {
+			return err
+		}
+
+		for _, data := range batch {
+			record, err := decodeRecord(data)
+			if err != nil {
+				return err
+			}
+			if err := fn(record); err != nil {
+				return err
+			}
+		}
+		if len(batch) < treeScanBatch {
+			return nil
+		}
+	}
+	return nil
+}
+
+// This function gets the greatest id in the tree.
+func (s *treeStorage) lastID() (int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.tree == nil {
+		return 0, nil
+	}
+	key, _, ok, err := s.tree.Last()
+	if err != nil || !ok {
+		return 0, err
+	}
+	return int(binary.BigEndian.Uint64(key)), nil
+}
+
+// This function rebuilds the tree into a new file without the
+// pages freed by deletions, and replaces the file. Readers are
+// only blocked while the file is replaced.
+func (s *treeStorage) vacuum(ctx context.Context) (VacuumStats, error) {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	var stats VacuumStats
+	path := filepath.Join(s.path, TREE_FILE)
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return stats, nil
+	}
+	if err != nil {
+		return stats, err
+	}
+
+	rebuilt, err := btree.Open(path+".tmp", &s.opts)
+	if err != nil {
+		return stats, err
+	}
+	var putErr error
+	s.mu.RLock()
+	err = s.tree.Scan(nil, nil, func(key, data []byte) bool {
+		if putErr = ctx.Err(); putErr != nil {
+			return false
+		}
+		putErr = rebuilt.Put(key, data)
+		return putErr == nil
+	})
+	s.mu.RUnlock()
+	if err == nil {
+		err = putErr
+	}
+	if closeErr := rebuilt.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path + ".tmp")
+		return stats, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	err = s.tree.Close()
+	s.tree = nil
+	if err == nil {
+		err = s.replace(path)
+	}
+	if err != nil {
+		// The original file is reopened, so the storage stays usable.
+		os.Remove(path + ".tmp")
+		tree, openErr := btree.Open(path, &s.opts)
+		if openErr != nil {
+			return stats, errors.Join(err, openErr)
+		}
+		s.tree = tree
+		return stats, err
+	}
+
+	after, err := os.Stat(path)
+	if err != nil {
+		return stats, err
+	}
+	stats.FilesRewritten = 1
+	stats.BytesReclaimed = info.Size() - after.Size()
+	return stats, nil
+}
+
+// This function replaces the tree file with the rebuilt one and
+// opens it. The original file is moved back when the rebuilt one
+// can't be moved or opened.
+// The caller must hold the lock.
+func (s *treeStorage) replace(path string) error {
+	if err := os.Rename(path, path+".old"); err != nil {
+		return err
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return errors.Join(err, os.Rename(path+".old", path))
+	}
+	tree, err := btree.Open(path, &s.opts)
+	if err != nil {
+		return errors.Join(err, os.Rename(path+".old", path))
+	}
+	s.tree = tree
+	os.Remove(path + ".old")
+	return nil
+}
+
+// This function closes the tree file.
+func (s *treeStorage) close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.tree == nil {
+		return nil
+	}
+	err := s.tree.Close()
+	s.tree = nil
+	return err
+}
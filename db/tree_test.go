@@ -0,0 +1,144 @@
+package db
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/OmerMohideen/minibase/logger"
+	"github.com/OmerMohideen/minibase/models"
+)
+
+func TestCollection_BTreeStorage(t *testing.T) {
+	logger, tempDir := logger.New(nil, nil), t.TempDir()
+	collection := NewCollection("test_collection", logger)
+	collection.SetDir(tempDir)
+	if err := collection.SetStorageMode(BTreeStorage); err != nil {
+		t.Fatalf("SetStorageMode() failed: %v", err)
+	}
+
+	for i := 0; i < 1200; i++ {
+		collection.InsertRecord(&models.Record{Fields: map[string]interface{}{"age": i}})
+	}
+	if err := collection.FlushRecords(); err != nil {
+		t.Fatalf("FlushRecords() failed: %v", err)
+	}
+	for id := 1; id <= 1000; id++ {
+		if err := collection.DeleteRecord(id); err != nil {
+			t.Fatalf("DeleteRecord() failed: %v", err)
+		}
+	}
+	collection.Close()
+
+	newcollection := NewCollection("test_collection", logger)
+	newcollection.SetDir(tempDir)
+	defer newcollection.Close()
+
+	if newcollection.nextID != 1201 {
+		t.Errorf("SetDir() failed: Expected next id 1201, got %d", newcollection.nextID)
+	}
+	record, err := newcollection.GetRecordByID(1100)
+	if err != nil {
+		t.Fatalf("GetRecordByID() failed: %v", err)
+	}
+	if age, _ := record.GetField("age"); age != int64(1099) {
+		t.Errorf("GetRecordByID() failed: Expected age 1099, got %v", age)
+	}
+	if _, err := newcollection.GetRecordByID(10); err == nil {
+		t.Errorf("GetRecordByID() failed: Deleted record was returned")
+	}
+}
+
+func TestCollection_ScanRange(t *testing.T) {
+	for _, mode := range []StorageMode{ChunkStorage, LogStorage, BTreeStorage} {
+		logger, tempDir := logger.New(nil, nil), t.TempDir()
+		collection := NewCollection("test_collection", logger)
+		collection.SetDir(tempDir)
+		collection.SetStorageMode(mode)
+
+		for i := 0; i < 10; i++ {
+			collection.InsertRecord(&models.Record{Fields: map[string]interface{}{"age": i}})
+		}
+		collection.FlushRecords()
+		collection.Close()
+
+		newcollection := NewCollection("test_collection", logger)
+		newcollection.SetDir(tempDir)
+		newcollection.InsertRecord(&models.Record{Fields: map[string]interface{}{"age": 10}})
+		newcollection.UpdateRecord(5, &models.Record{Fields: map[string]interface{}{"age": 50}})
+
+		var ids []int
+		err := newcollection.ScanRange(4, 11, func(record *models.Record) bool {
+			ids = append(ids, record.ID)
+			if record.ID == 5 {
+				if age, _ := record.GetField("age"); age != int64(50) {
+					t.Errorf("ScanRange() failed: %s storage returned the saved version of record 5", mode)
+				}
+			}
+			return true
+		})
+		if err != nil {
+			t.Fatalf("ScanRange() failed: %v", err)
+		}
+		expected := []int{4, 5, 6, 7, 8, 9, 10, 11}
+		if len(ids) != len(expected) {
+			t.Fatalf("ScanRange() failed: %s storage returned ids %v", mode, ids)
+		}
+		for i := range ids {
+			if ids[i] != expected[i] {
+				t.Errorf("ScanRange() failed: %s storage returned ids %v", mode, ids)
+				break
+			}
+		}
+
+		count := 0
+		if err := newcollection.ScanRange(-5, 3, func(*models.Record) bool { count++; return true }); err != nil {
+			t.Fatalf("ScanRange() failed: %v", err)
+		}
+		if count != 3 {
+			t.Errorf("ScanRange() failed: %s storage returned %d records from a negative id, expected 3", mode, count)
+		}
+		newcollection.Close()
+	}
+}
+
+func TestCollection_VacuumBTree(t *testing.T) {
+	logger, tempDir := logger.New(nil, nil), t.TempDir()
+	collection := NewCollection("test_collection", logger)
+	collection.SetDir(tempDir)
+	collection.SetStorageMode(BTreeStorage)
+	defer collection.Close()
+
+	for i := 0; i < 1000; i++ {
+		collection.InsertRecord(&models.Record{Fields: map[string]interface{}{"age": i}})
+	}
+	collection.FlushRecords()
+	for id := 1; id <= 900; id++ {
+		collection.DeleteRecord(id)
+	}
+
+	stats, err := collection.Vacuum()
+	if err != nil {
+		t.Fatalf("Vacuum() failed: %v", err)
+	}
+	if stats.FilesRewritten != 1 || stats.BytesReclaimed <= 0 {
+		t.Errorf("Vacuum() failed: Unexpected stats %+v", stats)
+	}
+
+	// A file which can't be replaced leaves the original open.
+	blocker := filepath.Join(tempDir, "test_collection", TREE_FILE+".old", "file")
+	if err := os.MkdirAll(blocker, 0755); err != nil {
+		t.Fatalf("MkdirAll() failed: %v", err)
+	}
+	if _, err := collection.Vacuum(); err == nil {
+		t.Errorf("Vacuum() failed: Expected an error replacing the file")
+	}
+	collection.records = make(map[int]*models.Record)
+	record, err := collection.GetRecordByID(950)
+	if err != nil {
+		t.Fatalf("GetRecordByID() failed: %v", err)
+	}
+	if age, _ := record.GetField("age"); age != int64(949) {
+		t.Errorf("GetRecordByID() failed: Expected age 949, got %v", age)
+	}
+}
@@ -0,0 +1,153 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/OmerMohideen/minibase/models"
+)
+
+// This function sets the record to expire after the ttl, a ttl
+// which is not positive removes its expiry. The record is replaced
+// by a copy with the new expiry like UpdateRecord does, running the
+// update hooks, and the change is saved by the next flush.
+func (c *Collection) SetTTL(id int, ttl time.Duration) error {
+	return c.SetTTLContext(context.Background(), id, ttl)
+}
+
+// This function is SetTTL with a context.
+func (c *Collection) SetTTLContext(ctx context.Context, id int, ttl time.Duration) error {
+	if err := c.ready(ctx); err != nil {
+		return err
+	}
+	if err := c.LoadRecordContext(ctx, id); err != nil {
+		return fmt.Errorf("error loading record: %w", err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	oldRecord, err := c.liveRecord(ctx, id)
+	if err != nil {
+		return err
+	}
+	if oldRecord == nil {
+		return fmt.Errorf("record with ID '%d' does not exist: %w", id, ErrNotFound)
+	}
+	record := oldRecord.Copy()
+	record.ExpireAt = time.Time{}
+	if ttl > 0 {
+		record.ExpireAt = c.now().Add(ttl)
+	}
+	return c.reviseRecord(ctx, ChangeUpdate, oldRecord, record)
+}
+
+// This function deletes the expired records from the memory and
+// the storage and gets how many were deleted. It is called by the
+// collection every REAP_INTERVAL. The delete hooks are called for
+// every record, a record whose before hook fails is kept and the
+// first error is returned once the others are deleted.
+func (c *Collection) ReapExpired() (int, error) {
+	return c.ReapExpiredContext(context.Background())
+}
+
+// This function is ReapExpired with a context.
+func (c *Collection) ReapExpiredContext(ctx context.Context) (int, error) {
+	if err := c.ready(ctx); err != nil {
+		return 0, err
+	}
+	c.mu.Lock()
+	store, now := c.store, c.now()
+	c.mu.Unlock()
+
+	expired := make(map[int]bool)
+	err := store.scan(1, math.MaxInt, func(record *models.Record) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if record.Expired(now) {
+			expired[record.ID] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.store != store {
+		return 0, nil
+	}
+	for id, record := range c.records {
+		if record.Expired(now) {
+			expired[id] = true
+		}
+	}
+
+	reaped := 0
+	var first error
+	for id := range expired {
+		// The memory may hold a newer version with another expiry.
+		record, ok := c.records[id]
+		if ok && !record.Expired(now) {
+			continue
+		}
+		if !ok && (c.watched() || c.hooked(HookDelete)) {
+			if record, err = c.store.load(id, nil); err != nil {
+				return reaped, fmt.Errorf("error loading record: %w", err)
+			}
+		}
+		// A record in the trash was already deleted.
+		live := record != nil && !record.Deleted()
+		if live {
+			if err := c.runBefore(ctx, HookDelete, record); err != nil {
+				if first == nil {
+					first = err
+				}
+				continue
+			}
+		}
+		delete(c.records, id)
+		if _, err := c.store.remove(id); err != nil {
+			return reaped, err
+		}
+		c.keys.remove(id)
+		if live {
+			c.changed(ctx, ChangeDelete, id, record, nil)
+			if err := c.commitRecord(id); err != nil && first == nil {
+				first = err
+			}
+			c.runAfter(ctx, HookDelete, record)
+		}
+		reaped++
+	}
+	return reaped, first
+}
+
+func (c *Collection) reapCollection(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+		}
+		if _, err := c.ReapExpired(); err != nil {
+			c.logger.Error("error deleting expired records of '%s': %v", c.name, err)
+		}
+		if _, err := c.PruneAuditLog(); err != nil {
+			c.logger.Error("error pruning the audit trail of '%s': %v", c.name, err)
+		}
+		if _, err := c.PruneHistory(); err != nil {
+			c.logger.Error("error pruning the history of '%s': %v", c.name, err)
+		}
+		if _, err := c.PurgeTrash(); err != nil {
+			c.logger.Error("error purging the trash of '%s': %v", c.name, err)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/OmerMohideen/minibase/logger"
+	"github.com/OmerMohideen/minibase/models"
+)
+
+func TestCollection_ExpireAt(t *testing.T) {
+	for _, mode := range []StorageMode{ChunkStorage, LogStorage, BTreeStorage} {
+		now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+		clock := func() time.Time { return now }
+		logger, tempDir := logger.New(nil, nil), t.TempDir()
+		collection := NewCollection("test_collection", logger)
+		collection.SetDir(tempDir)
+		collection.SetStorageMode(mode)
+		collection.SetClock(clock)
+
+		collection.InsertRecord(&models.Record{Fields: map[string]interface{}{"name": "Sajith"}, ExpireAt: now.Add(time.Minute)})
+		collection.InsertRecord(&models.Record{Fields: map[string]interface{}{"name": "Mahinda"}})
+		collection.InsertRecord(&models.Record{Fields: map[string]interface{}{"name": "Anura"}})
+		if err := collection.SetTTL(2, 2*time.Minute); err != nil {
+			t.Fatalf("SetTTL() failed: %v", err)
+		}
+		if err := collection.UpdateRecord(2, &models.Record{Fields: map[string]interface{}{"name": "Ranil"}}); err != nil {
+			t.Fatalf("UpdateRecord() failed: %v", err)
+		}
+		if err := collection.FlushRecords(); err != nil {
+			t.Fatalf("FlushRecords() failed: %v", err)
+		}
+		collection.Close()
+
+		newcollection := NewCollection("test_collection", logger)
+		newcollection.SetDir(tempDir)
+		newcollection.SetClock(clock)
+
+		now = now.Add(90 * time.Second)
+		if _, err := newcollection.GetRecordByID(1); err == nil {
+			t.Errorf("GetRecordByID() failed: %s storage returned an expired record", mode)
+		}
+		if _, err := newcollection.View(1); err == nil {
+			t.Errorf("View() failed: %s storage returned an expired record", mode)
+		}
+		count := 0
+		err := newcollection.ScanRange(1, math.MaxInt, func(*models.Record) bool { count++; return true })
+		if err != nil || count != 2 {
+			t.Errorf("ScanRange() failed: %s storage got %d records, %v", mode, count, err)
+		}
+
+		reaped, err := newcollection.ReapExpired()
+		if err != nil || reaped != 1 {
+			t.Errorf("ReapExpired() failed: %s storage deleted %d records, %v", mode, reaped, err)
+		}
+		now = now.Add(time.Minute)
+		reaped, err = newcollection.ReapExpired()
+		if err != nil || reaped != 1 {
+			t.Errorf("ReapExpired() failed: %s storage deleted %d records, %v", mode, reaped, err)
+		}
+		newcollection.Close()
+
+		newcollection = NewCollection("test_collection", logger)
+		newcollection.SetDir(tempDir)
+		newcollection.SetClock(func() time.Time { return time.Time{} })
+		for _, id := range []int{1, 2} {
+			if _, err := newcollection.GetRecordByID(id); err == nil {
+				t.Errorf("ReapExpired() failed: %s storage kept record %d", mode, id)
+			}
+		}
+		if _, err := newcollection.GetRecordByID(3); err != nil {
+			t.Errorf("GetRecordByID() failed: %v", err)
+		}
+		newcollection.Close()
+	}
+}
+
+func TestCollection_SetTTL(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	logger, tempDir := logger.New(nil, nil), t.TempDir()
+	collection := NewCollection("test_collection", logger)
+	collection.SetDir(tempDir)
+	collection.SetClock(func() time.Time { return now })
+	defer collection.Close()
+
+	collection.InsertRecord(&models.Record{Fields: map[string]interface{}{"name": "Sajith"}})
+	held, _ := collection.GetRecordByID(1)
+	now = now.Add(time.Hour)
+	if err := collection.SetTTL(1, time.Minute); err != nil {
+		t.Fatalf("SetTTL() failed: %v", err)
+	}
+	if !held.ExpireAt.IsZero() {
+		t.Errorf("SetTTL() failed: The record held by the caller was changed")
+	}
+	record, _ := collection.GetRecordByID(1)
+	if !record.ExpireAt.Equal(now.Add(time.Minute)) || !record.UpdatedAt.Equal(now) {
+		t.Errorf("SetTTL() failed: got expiry %v and update time %v", record.ExpireAt, record.UpdatedAt)
+	}
+
+	schema := &models.Schema{Fields: map[string]*models.FieldSchema{"age": {Type: models.KindInt, Required: true}}}
+	collection.SetSchema(schema, ValidationStrict)
+	if err := collection.SetTTL(1, time.Minute); err == nil {
+		t.Errorf("SetTTL() failed: Expected the schema to be checked")
+	}
+}
+
+func TestCollection_TTLHooks(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	collection := NewCollection("test_collection", logger.New(nil, nil))
+	collection.SetDir(t.TempDir())
+	collection.SetClock(func() time.Time { return now })
+	defer collection.Close()
+
+	errKeep := errors.New("keep")
+	var updates, deletes []int
+	collection.AddBeforeHook(HookUpdate, func(ctx context.Context, record *models.Record) error {
+		updates = append(updates, record.ID)
+		return nil
+	})
+	collection.AddBeforeHook(HookDelete, func(ctx context.Context, record *models.Record) error {
+		if record.Fields["keep"] == true {
+			return errKeep
+		}
+		return nil
+	})
+	collection.AddAfterHook(HookDelete, func(ctx context.Context, record *models.Record) {
+		deletes = append(deletes, record.ID)
+	})
+	collection.InsertRecord(&models.Record{Fields: map[string]interface{}{"keep": false}})
+	collection.InsertRecord(&models.Record{Fields: map[string]interface{}{"keep": true}})
+
+	for _, id := range []int{1, 2} {
+		if err := collection.SetTTL(id, time.Minute); err != nil {
+			t.Fatalf("SetTTL() failed: %v", err)
+		}
+	}
+	if len(updates) != 2 {
+		t.Errorf("SetTTL() failed: Expected the update hooks to run, got %v", updates)
+	}
+
+	now = now.Add(time.Hour)
+	reaped, err := collection.ReapExpired()
+	if reaped != 1 || !errors.Is(err, errKeep) || len(deletes) != 1 || deletes[0] != 1 {
+		t.Errorf("ReapExpired() failed: Expected record 1 reaped and 2 kept by the hook, got %d, %v, %v", reaped, deletes, err)
+	}
+}
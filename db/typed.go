@@ -0,0 +1,91 @@
+package db
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/OmerMohideen/minibase/models"
+)
+
+// This function converts the struct into a record and inserts it
+// into the collection. Returns the id of the new record.
+// See models.TAG for how the fields of the struct are mapped.
+func Insert[T any](c *Collection, value T) (int, error) {
+	record, err := models.Marshal(value)
+	if err != nil {
+		return 0, fmt.Errorf("error converting %T: %w", value, invalid(err))
+	}
+	if err := c.InsertRecord(record); err != nil {
+		return 0, err
+	}
+	return record.ID, nil
+}
+
+// This function gets the record by its id and converts it
+// into the struct. T can be a struct or a pointer to one.
+func Get[T any](c *Collection, id int) (T, error) {
+	var value T
+	record, err := c.GetRecordByID(id)
+	if err != nil {
+		return value, err
+	}
+	return value, unmarshalRecord(record, &value)
+}
+
+// This function converts the struct into a record and
+// replaces the record with the id.
+func Update[T any](c *Collection, id int, value T) error {
+	record, err := models.Marshal(value)
+	if err != nil {
+		return fmt.Errorf("error converting %T: %w", value, invalid(err))
+	}
+	return c.UpdateRecord(id, record)
+}
+
+// This function converts the record into the value
+// allocating it when T is a pointer.
+func unmarshalRecord[T any](record *models.Record, value *T) error {
+	target := reflect.ValueOf(value).Elem()
+	if target.Kind() == reflect.Pointer {
+		target.Set(reflect.New(target.Type().Elem()))
+		value := target.Interface()
+		if err := models.Unmarshal(record, value); err != nil {
+			return fmt.Errorf("error converting record %d to %T: %w", record.ID, value, invalid(err))
+		}
+		return nil
+	}
+	if err := models.Unmarshal(record, value); err != nil {
+		return fmt.Errorf("error converting record %d to %T: %w", record.ID, *value, invalid(err))
+	}
+	return nil
+}
+
+// TypedCollection wraps a collection whose records are all
+// converted from and into T.
+type TypedCollection[T any] struct {
+	*Collection
+}
+
+// This function wraps the collection after checking the
+// fields of T can be mapped.
+func NewTypedCollection[T any](c *Collection) (*TypedCollection[T], error) {
+	if _, err := models.StructFields(reflect.TypeOf((*T)(nil)).Elem()); err != nil {
+		return nil, invalid(err)
+	}
+	return &TypedCollection[T]{c}, nil
+}
+
+// This function inserts the value. Returns the id of the new record.
+func (c *TypedCollection[T]) Insert(value T) (int, error) {
+	return Insert(c.Collection, value)
+}
+
+// This function gets the value by its id.
+func (c *TypedCollection[T]) Get(id int) (T, error) {
+	return Get[T](c.Collection, id)
+}
+
+// This function replaces the value with the id.
+func (c *TypedCollection[T]) Update(id int, value T) error {
+	return Update(c.Collection, id, value)
+}
@@ -0,0 +1,68 @@
+package db
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/OmerMohideen/minibase/logger"
+)
+
+type person struct {
+	ID    int    `minibase:"id"`
+	Name  string `minibase:"name"`
+	City  string `minibase:"city,index"`
+	Age   int    `minibase:"age,omitempty"`
+	Email string `minibase:"email,omitempty"`
+}
+
+func TestTypedCollection(t *testing.T) {
+	logger, tempDir := logger.New(nil, nil), t.TempDir()
+	collection := NewCollection("test_collection", logger)
+	collection.SetDir(tempDir)
+	people, err := NewTypedCollection[person](collection)
+	if err != nil {
+		t.Fatalf("NewTypedCollection() failed: %v", err)
+	}
+
+	id, err := people.Insert(person{Name: "Sajith", City: "Colombo", Age: 30})
+	if err != nil {
+		t.Fatalf("Insert() failed: %v", err)
+	}
+	people.Insert(person{Name: "Mahinda", City: "Kandy"})
+	people.Insert(person{Name: "Anura", City: "Colombo"})
+	collection.FlushRecords()
+	collection.Close()
+
+	newcollection := NewCollection("test_collection", logger)
+	newcollection.SetDir(tempDir)
+	defer newcollection.Close()
+
+	value, err := Get[*person](newcollection, id)
+	if err != nil {
+		t.Fatalf("Get() failed: %v", err)
+	}
+	if *value != (person{ID: id, Name: "Sajith", City: "Colombo", Age: 30}) {
+		t.Errorf("Get() failed: Unexpected value %+v", *value)
+	}
+
+	people, err = NewTypedCollection[person](newcollection)
+	if err != nil {
+		t.Fatalf("NewTypedCollection() failed: %v", err)
+	}
+	if err := people.Update(2, person{Name: "Mahinda", City: "Colombo"}); err != nil {
+		t.Fatalf("Update() failed: %v", err)
+	}
+	updated, err := people.Get(2)
+	if err != nil || updated.City != "Colombo" {
+		t.Errorf("Update() failed: Unexpected value %+v: %v", updated, err)
+	}
+
+	record, _ := newcollection.GetRecordByID(3)
+	record.AddField("age", "old")
+	if _, err := people.Get(3); !errors.Is(err, ErrValidation) {
+		t.Errorf("Get() failed: Expected type mismatch error, got %v", err)
+	}
+	if _, err := Insert(newcollection, 3); !errors.Is(err, ErrValidation) {
+		t.Errorf("Insert() failed: Expected ErrValidation for a non struct, got %v", err)
+	}
+}
@@ -0,0 +1,223 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"path/filepath"
+
+	"github.com/OmerMohideen/minibase/models"
+)
+
+// ValidationMode represents how a collection enforces its schema.
+type ValidationMode int
+
+const (
+	// Records are not checked against the schema.
+	ValidationOff ValidationMode = iota
+	// Records not matching the schema are saved and logged.
+	ValidationWarn
+	// Records not matching the schema are rejected.
+	ValidationStrict
+)
+
+// This function returns the name of the validation mode.
+func (m ValidationMode) String() string {
+	switch m {
+	case ValidationOff:
+		return "off"
+	case ValidationWarn:
+		return "warn"
+	case ValidationStrict:
+		return "strict"
+	}
+	return fmt.Sprintf("ValidationMode(%d)", int(m))
+}
+
+// This function encodes the validation mode by its name.
+func (m ValidationMode) MarshalText() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+// This function decodes the validation mode from its name.
+func (m *ValidationMode) UnmarshalText(text []byte) error {
+	mode, err := ParseValidationMode(string(text))
+	if err != nil {
+		return err
+	}
+	*m = mode
+	return nil
+}
+
+// This function parses the name of a validation mode.
+func ParseValidationMode(name string) (ValidationMode, error) {
+	for _, mode := range []ValidationMode{ValidationOff, ValidationWarn, ValidationStrict} {
+		if mode.String() == name {
+			return mode, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown validation mode '%s'", name)
+}
+
+// InvalidRecord represents a saved record not matching the schema.
+type InvalidRecord struct {
+	ID     int
+	Errors models.ValidationErrors
+}
+
+// This function sets the schema of the collection and how it is
+// enforced by InsertRecord and UpdateRecord. The schema is saved
+// in the metadata of the collection, right away if the collection
+// exists or with the first flush. A nil schema removes it.
+// Default values of the schema are applied in every mode.
+func (c *Collection) SetSchema(schema *models.Schema, mode ValidationMode) error {
+	if schema != nil {
+		if err := schema.Check(); err != nil {
+			return fmt.Errorf("invalid schema: %v", err)
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.schema, c.validation = schema, mode
+	return c.saveMetadata()
+}
+
+// This function gets the schema of the collection and
+// how it is enforced.
+func (c *Collection) Schema() (*models.Schema, ValidationMode) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.schema, c.validation
+}
+
+// This function sets the schema of the collection from a JSON Schema
+// document, see models.ParseJSONSchema for the supported keywords.
+func (c *Collection) SetJSONSchema(data []byte, mode ValidationMode) error {
+	schema, err := models.ParseJSONSchema(data)
+	if err != nil {
+		return err
+	}
+	return c.SetSchema(schema, mode)
+}
+
+// This function gets the schema of the collection as
+// a JSON Schema document.
+func (c *Collection) JSONSchema() ([]byte, error) {
+	schema, _ := c.Schema()
+	if schema == nil {
+		return nil, fmt.Errorf("collection '%s' has no schema", c.name)
+	}
+	return schema.JSONSchema()
+}
+
+// This function checks every record of the collection against its
+// schema regardless of the validation mode, including the records
+// in the memory. Returns the records which don't match in order.
+func (c *Collection) ValidateAll() ([]InvalidRecord, error) {
+	return c.ValidateAllContext(context.Background())
+}
+
+// This function is ValidateAll with a context.
+func (c *Collection) ValidateAllContext(ctx context.Context) ([]InvalidRecord, error) {
+	if err := c.ready(ctx); err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	schema := c.schema
+	c.mu.Unlock()
+	if schema == nil {
+		return nil, fmt.Errorf("collection '%s' has no schema", c.name)
+	}
+
+	var invalid []InvalidRecord
+	var err error
+	scanErr := c.ScanRangeContext(ctx, 1, math.MaxInt, func(record *models.Record) bool {
+		verr := schema.Validate(record)
+		var errs models.ValidationErrors
+		if errors.As(verr, &errs) {
+			invalid = append(invalid, InvalidRecord{ID: record.ID, Errors: errs})
+		} else {
+			err = verr
+		}
+		return err == nil
+	})
+	if scanErr != nil {
+		return nil, scanErr
+	}
+	return invalid, err
+}
+
+// This function applies the schema to a record about to be saved
+// with the id. It fails only in the strict mode.
+// The caller must hold the lock.
+func (c *Collection) validate(id int, record *models.Record) error {
+	if c.schema == nil {
+		return nil
+	}
+	c.schema.ApplyDefaults(record)
+	if c.validation == ValidationOff {
+		return nil
+	}
+
+	err := c.schema.Validate(record)
+	if err == nil {
+		return nil
+	}
+	if c.validation == ValidationWarn {
+		c.logger.Warn("record %d of collection '%s' does not match the schema: %v", id, c.name, err)
+		return nil
+	}
+	return fmt.Errorf("%w: record %d does not match the schema: %w", ErrValidation, id, err)
+}
+
+// This function gets the metadata of the collection.
+// The caller must hold the lock.
+func (c *Collection) metadata() *metadata {
+	meta := &metadata{
+		Storage:       c.mode,
+		Schema:        c.schema,
+		Validation:    c.validation,
+		SchemaVersion: c.schemaVersion,
+		KeyStrategy:   c.keyStrategy,
+	}
+	if c.feedOpts.Enabled {
+		feed := c.feedOpts
+		meta.ChangeFeed = &feed
+	}
+	if c.auditOpts.Enabled {
+		audit := c.auditOpts
+		meta.Audit = &audit
+	}
+	if c.historyOpts.Enabled {
+		history := c.historyOpts
+		meta.History = &history
+	}
+	if c.softDelete.Enabled {
+		softDelete := c.softDelete
+		meta.SoftDelete = &softDelete
+	}
+	return meta
+}
+
+// This function saves the metadata if the collection has been
+// saved before. The metadata file is created for collections
+// saved before it existed. A new collection saves its metadata
+// with its first flush, until then the storage mode can change.
+// The caller must hold the lock.
+func (c *Collection) saveMetadata() error {
+	path := filepath.Join(c.dir, c.name)
+	meta, err := readMetadata(path)
+	if err != nil {
+		return err
+	}
+	if meta == nil {
+		last, err := c.store.lastID()
+		if err != nil || last == 0 {
+			return err
+		}
+	}
+	return writeMetadata(path, c.metadata())
+}
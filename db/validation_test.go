@@ -0,0 +1,99 @@
+package db
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/OmerMohideen/minibase/logger"
+	"github.com/OmerMohideen/minibase/models"
+)
+
+func TestCollection_SetSchema(t *testing.T) {
+	logger, tempDir := logger.New(nil, nil), t.TempDir()
+	collection := NewCollection("test_collection", logger)
+	collection.SetDir(tempDir)
+
+	collection.InsertRecord(&models.Record{Fields: map[string]interface{}{"name": "Sajith", "age": 30}})
+	collection.FlushRecords()
+
+	schema := &models.Schema{Fields: map[string]*models.FieldSchema{
+		"name": {Type: models.KindString, Required: true},
+		"age":  {Type: models.KindInt, Min: new(float64)},
+		"role": {Type: models.KindString, Default: "user"},
+	}}
+	if err := collection.SetSchema(schema, ValidationStrict); err != nil {
+		t.Fatalf("SetSchema() failed: %v", err)
+	}
+
+	err := collection.InsertRecord(&models.Record{Fields: map[string]interface{}{"age": -1}})
+	var errs models.ValidationErrors
+	if !errors.As(err, &errs) || len(errs) != 2 {
+		t.Fatalf("InsertRecord() failed: Expected 2 violations, got %v", err)
+	}
+	if len(collection.records) != 1 {
+		t.Errorf("InsertRecord() failed: Invalid record was inserted")
+	}
+	if err := collection.UpdateRecord(1, &models.Record{Fields: map[string]interface{}{"name": 1}}); err == nil {
+		t.Errorf("UpdateRecord() failed: Invalid record was saved")
+	}
+
+	record := &models.Record{Fields: map[string]interface{}{"name": "Mahinda"}}
+	if err := collection.InsertRecord(record); err != nil {
+		t.Fatalf("InsertRecord() failed: %v", err)
+	}
+	if role, _ := record.GetField("role"); role != "user" {
+		t.Errorf("InsertRecord() failed: Default role was not applied, got %v", role)
+	}
+	collection.FlushRecords()
+	collection.Close()
+
+	newcollection := NewCollection("test_collection", logger)
+	newcollection.SetDir(tempDir)
+	defer newcollection.Close()
+	loaded, mode := newcollection.Schema()
+	if loaded == nil || mode != ValidationStrict || loaded.Fields["role"].Default != "user" {
+		t.Fatalf("Schema() failed: Schema was not saved, got %v %s", loaded, mode)
+	}
+
+	newcollection.SetSchema(schema, ValidationWarn)
+	if err := newcollection.InsertRecord(&models.Record{Fields: map[string]interface{}{"age": -1}}); err != nil {
+		t.Errorf("InsertRecord() failed: Warn mode rejected a record: %v", err)
+	}
+	invalid, err := newcollection.ValidateAll()
+	if err != nil {
+		t.Fatalf("ValidateAll() failed: %v", err)
+	}
+	if len(invalid) != 1 || invalid[0].ID != 3 || len(invalid[0].Errors) != 2 {
+		t.Errorf("ValidateAll() failed: Unexpected invalid records %+v", invalid)
+	}
+}
+
+func TestCollection_SetSchemaWithoutMetadata(t *testing.T) {
+	logger, tempDir := logger.New(nil, nil), t.TempDir()
+	collection := NewCollection("test_collection", logger)
+	collection.SetDir(tempDir)
+	collection.InsertRecord(&models.Record{Fields: map[string]interface{}{"name": "Sajith"}})
+	collection.FlushRecords()
+	collection.Close()
+
+	// Collections saved before the metadata existed have none.
+	if err := os.Remove(filepath.Join(tempDir, "test_collection", META_FILE)); err != nil {
+		t.Fatalf("Remove() failed: %v", err)
+	}
+	newcollection := NewCollection("test_collection", logger)
+	newcollection.SetDir(tempDir)
+	schema := &models.Schema{Fields: map[string]*models.FieldSchema{"name": {Type: models.KindString}}}
+	if err := newcollection.SetSchema(schema, ValidationStrict); err != nil {
+		t.Fatalf("SetSchema() failed: %v", err)
+	}
+	newcollection.Close()
+
+	reopened := NewCollection("test_collection", logger)
+	reopened.SetDir(tempDir)
+	defer reopened.Close()
+	if loaded, mode := reopened.Schema(); loaded == nil || mode != ValidationStrict {
+		t.Errorf("SetSchema() failed: Schema was not saved, got %v %s", loaded, mode)
+	}
+}
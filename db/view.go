@@ -0,0 +1,283 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/OmerMohideen/minibase/models"
+)
+
+// RecordView represents a saved record which is read without
+// decoding it. With memory mapped log segments the view points
+// directly into the mapped file and must not be modified. The
+// mapping is kept, even after the collection is closed, until
+// the view is closed. The other storages read a copy.
+type RecordView struct {
+	ID      int
+	data    []byte
+	release func()
+}
+
+// This function gets a view of the record by its id.
+// A record in the memory is encoded into the view, otherwise
+// the saved record is read from the storage. A deleted or
+// expired record is not found.
+func (c *Collection) View(id int) (*RecordView, error) {
+	return c.ViewContext(context.Background(), id)
+}
+
+// This function is View with a context.
+func (c *Collection) ViewContext(ctx context.Context, id int) (*RecordView, error) {
+	if err := c.ready(ctx); err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	record, ok := c.records[id]
+	store, now := c.store, c.now()
+	c.mu.Unlock()
+
+	if ok {
+		if record.Hidden(now) {
+			return nil, hiddenError(record)
+		}
+		data, err := json.Marshal(record)
+		if err != nil {
+			return nil, fmt.Errorf("error encoding data: %v", err)
+		}
+		return &RecordView{ID: id, data: data}, nil
+	}
+
+	data, release, err := store.raw(id)
+	if err != nil {
+		return nil, fmt.Errorf("error loading record: %w", err)
+	}
+	if data == nil {
+		return nil, fmt.Errorf("record with ID '%d' %w", id, ErrNotFound)
+	}
+	view := &RecordView{ID: id, data: data, release: release}
+	if err := view.visible(now); err != nil {
+		view.Close()
+		return nil, err
+	}
+	return view, nil
+}
+
+// This function checks the saved record is neither deleted
+// nor expired at the time, only its dates are decoded.
+func (v *RecordView) visible(now time.Time) error {
+	record := &models.Record{ID: v.ID}
+	if err := v.rawDate("deletedAt", &record.DeletedAt); err != nil {
+		return err
+	}
+	if err := v.rawDate("expireAt", &record.ExpireAt); err != nil {
+		return err
+	}
+	if record.Hidden(now) {
+		return hiddenError(record)
+	}
+	return nil
+}
+
+// This function decodes the date of the record with the name.
+func (v *RecordView) rawDate(name string, date *time.Time) error {
+	raw, err := rawPath(v.data, []models.PathElem{{Key: name, IsKey: true}})
+	if err != nil || raw == nil {
+		return err
+	}
+	if err := json.Unmarshal(raw, date); err != nil {
+		return corrupt(err)
+	}
+	return nil
+}
+
+// This function releases the memory map the view points into.
+// The view and the values it returned must not be used after.
+// Closing a view which doesn't point into a memory map does
+// nothing.
+func (v *RecordView) Close() {
+	if v.release != nil {
+		v.release()
+		v.release = nil
+	}
+}
+
+// This function gets the encoded record.
+func (v *RecordView) Raw() []byte {
+	return v.data
+}
+
+// This function gets the encoded value of the field at the path,
+// such as "address.city" or "tags[0]", without copying it.
+// Returns nil if the field does not exist.
+func (v *RecordView) RawField(path string) (json.RawMessage, error) {
+	elems, err := models.ParsePath(path)
+	if err != nil {
+		return nil, invalid(err)
+	}
+	return rawPath(v.data, append([]models.PathElem{{Key: "fields", IsKey: true}}, elems...))
+}
+
+// This function decodes only the value of the field at the path.
+func (v *RecordView) Field(path string) (interface{}, error) {
+	raw, err := v.RawField(path)
+	if err != nil {
+		return nil, err
+	}
+	if raw == nil {
+		return nil, fmt.Errorf("field '%s' does not exist: %w", path, ErrNotFound)
+	}
+	value, err := models.DecodeValue(raw)
+	if err != nil {
+		return nil, corrupt(err)
+	}
+	return value, nil
+}
+
+// This function decodes the whole record.
+func (v *RecordView) Record() (*models.Record, error) {
+	return decodeRecord(v.data)
+}
+
+// This function gets the encoded value at the path inside the
+// encoded document. Only the values on the way are scanned,
+// nothing is decoded. Returns nil if there is no value.
+func rawPath(data []byte, elems []models.PathElem) (json.RawMessage, error) {
+	start, end := 0, len(data)
+	for _, elem := range elems {
+		var err error
+		start, end, err = rawElem(data, start, elem)
+		if err != nil || start < 0 {
+			return nil, err
+		}
+	}
+	return json.RawMessage(data[start:end]), nil
+}
+
+// This function finds the member or the element of the object
+// or the array at the offset and gets where its value starts
+// and ends. The start is -1 when there is no such value.
+func rawElem(data []byte, offset int, elem models.PathElem) (int, int, error) {
+	opening, closing := byte('['), byte(']')
+	if elem.IsKey {
+		opening, closing = '{', '}'
+	}
+	i := skipSpace(data, offset)
+	if i >= len(data) || data[i] != opening {
+		return -1, -1, nil
+	}
+	i = skipSpace(data, i+1)
+	for n := 0; i < len(data) && data[i] != closing; n++ {
+		match := !elem.IsKey && n == elem.Index
+		if elem.IsKey {
+			if data[i] != '"' {
+				return -1, -1, corrupt(fmt.Errorf("expected a key at offset %d", i))
+			}
+			end, err := rawEnd(data, i)
+			if err != nil {
+				return -1, -1, err
+			}
+			if match, err = rawKeyIs(data[i:end], elem.Key); err != nil {
+				return -1, -1, err
+			}
+			i = skipSpace(data, end)
+			if i >= len(data) || data[i] != ':' {
+				return -1, -1, corrupt(fmt.Errorf("expected ':' at offset %d", i))
+			}
+			i = skipSpace(data, i+1)
+		}
+		end, err := rawEnd(data, i)
+		if err != nil {
+			return -1, -1, err
+		}
+		if match {
+			return i, end, nil
+		}
+		i = skipSpace(data, end)
+		if i < len(data) && data[i] == ',' {
+			i = skipSpace(data, i+1)
+		}
+	}
+	if i >= len(data) {
+		return -1, -1, corrupt(io.ErrUnexpectedEOF)
+	}
+	return -1, -1, nil
+}
+
+// This function tells if the encoded string is the key. Only
+// keys with escapes are decoded.
+func rawKeyIs(raw []byte, key string) (bool, error) {
+	if bytes.IndexByte(raw, '\\') < 0 {
+		return string(raw[1:len(raw)-1]) == key, nil
+	}
+	var decoded string
+	if err := json.Unmarshal(raw, &decoded); err != nil {
+		return false, corrupt(err)
+	}
+	return decoded == key, nil
+}
+
+// This function gets where the encoded value starting at the
+// offset ends.
+func rawEnd(data []byte, offset int) (int, error) {
+	if offset >= len(data) {
+		return 0, corrupt(io.ErrUnexpectedEOF)
+	}
+	switch data[offset] {
+	case '"':
+		for i := offset + 1; i < len(data); i++ {
+			switch data[i] {
+			case '\\':
+				i++
+			case '"':
+				return i + 1, nil
+			}
+		}
+	case '{', '[':
+		depth := 0
+		for i := offset; i < len(data); i++ {
+			switch data[i] {
+			case '"':
+				end, err := rawEnd(data, i)
+				if err != nil {
+					return 0, err
+				}
+				i = end - 1
+			case '{', '[':
+				depth++
+			case '}', ']':
+				if depth--; depth == 0 {
+					return i + 1, nil
+				}
+			}
+		}
+	default:
+		// Numbers, booleans and null end at the next delimiter.
+		i := offset
+		for i < len(data) && strings.IndexByte(",:]} \t\r\n", data[i]) < 0 {
+			i++
+		}
+		if i == offset {
+			return 0, corrupt(fmt.Errorf("unexpected '%c' at offset %d", data[i], i))
+		}
+		return i, nil
+	}
+	return 0, corrupt(io.ErrUnexpectedEOF)
+}
+
+// This function skips the whitespace at the offset.
+func skipSpace(data []byte, offset int) int {
+	for offset < len(data) {
+		switch data[offset] {
+		case ' ', '\t', '\r', '\n':
+			offset++
+		default:
+			return offset
+		}
+	}
+	return offset
+}
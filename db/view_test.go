@@ -0,0 +1,97 @@
+package db
+
+import (
+	"context"
+	"testing"
+
+	"github.com/OmerMohideen/minibase/logger"
+	"github.com/OmerMohideen/minibase/models"
+)
+
+func TestCollection_View(t *testing.T) {
+	tempDir := t.TempDir()
+	collection := newLogCollection(t, tempDir)
+	opts := collection.logOpts
+	opts.MemoryMap = true
+	if err := collection.SetLogOptions(opts); err != nil {
+		t.Fatalf("SetLogOptions() failed: %v", err)
+	}
+
+	for i := 0; i < 20; i++ {
+		collection.InsertRecord(&models.Record{Fields: map[string]interface{}{"name": "Sajith", "age": i, "tags": []interface{}{"a", "b"}, "address": map[string]interface{}{"city": "Colombo"}}})
+	}
+	if err := collection.FlushRecords(); err != nil {
+		t.Fatalf("FlushRecords() failed: %v", err)
+	}
+	collection.Close()
+
+	newcollection := NewCollection("test_collection", logger.New(nil, nil))
+	newcollection.SetDir(tempDir)
+	if err := newcollection.SetLogOptions(opts); err != nil {
+		t.Fatalf("SetLogOptions() failed: %v", err)
+	}
+	defer newcollection.Close()
+
+	view, err := newcollection.View(3)
+	if err != nil {
+		t.Fatalf("View() failed: %v", err)
+	}
+	age, err := view.Field("age")
+	if err != nil || age != int64(2) {
+		t.Errorf("Field() failed: Expected age 2, got %v: %v", age, err)
+	}
+	raw, err := view.RawField("tags")
+	if err != nil || string(raw) != `["a","b"]` {
+		t.Errorf("RawField() failed: Unexpected value %s: %v", raw, err)
+	}
+	if raw, _ := view.RawField("missing"); raw != nil {
+		t.Errorf("RawField() failed: Missing field returned %s", raw)
+	}
+	if city, err := view.Field("address.city"); err != nil || city != "Colombo" {
+		t.Errorf("Field() failed: Expected the nested city, got %v: %v", city, err)
+	}
+	if raw, err := view.RawField("tags[1]"); err != nil || string(raw) != `"b"` {
+		t.Errorf("RawField() failed: Unexpected element %s: %v", raw, err)
+	}
+	for _, path := range []string{"tags[2]", "name.first", "address.zip"} {
+		if raw, err := view.RawField(path); err != nil || raw != nil {
+			t.Errorf("RawField() failed: Expected no value at %s, got %s: %v", path, raw, err)
+		}
+	}
+	if _, err := view.RawField("tags["); err == nil {
+		t.Errorf("RawField() failed: Expected error for an invalid path")
+	}
+	if _, ok := newcollection.records[3]; ok {
+		t.Errorf("View() failed: Viewed record was cached")
+	}
+
+	store := newcollection.store.(*logStorage)
+	mapped := false
+	for _, segment := range store.segments {
+		mapped = mapped || segment.mapped != nil
+	}
+	if !mapped {
+		t.Skip("memory mapping is unavailable")
+	}
+	if _, _, err := store.compact(context.Background(), true); err != nil {
+		t.Fatalf("compact() failed: %v", err)
+	}
+	if _, err := view.Field("name"); err != nil {
+		t.Errorf("Field() failed: View is not valid after compaction: %v", err)
+	}
+	record, err := newcollection.GetRecordByID(20)
+	if err != nil {
+		t.Fatalf("GetRecordByID() failed: %v", err)
+	}
+	if age, _ := record.GetField("age"); age != int64(19) {
+		t.Errorf("GetRecordByID() failed: Expected age 19, got %v", age)
+	}
+
+	// The view keeps its mapping after the collection is closed.
+	newcollection.Close()
+	if name, err := view.Field("name"); err != nil || name != "Sajith" {
+		t.Errorf("Field() failed: View is not valid after closing the collection: %v, %v", name, err)
+	}
+	view.Close()
+	view.Close()
+}
@@ -0,0 +1,555 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/OmerMohideen/minibase/models"
+)
+
+const (
+	// Name of the change feed file inside the collection directory.
+	CHANGES_FILE = "changes.log"
+	// Number of changes a watcher may fall behind by before
+	// it is stopped.
+	WATCH_BUFFER = 1024
+)
+
+// ChangeKind represents the kind of a change to a record.
+type ChangeKind int
+
+const (
+	// The record was inserted.
+	ChangeInsert ChangeKind = iota
+	// The record was replaced.
+	ChangeUpdate
+	// A patch was applied to the record.
+	ChangePatch
+	// The record was deleted.
+	ChangeDelete
+)
+
+// This function returns the name of the change kind.
+func (k ChangeKind) String() string {
+	switch k {
+	case ChangeInsert:
+		return "insert"
+	case ChangeUpdate:
+		return "update"
+	case ChangePatch:
+		return "patch"
+	case ChangeDelete:
+		return "delete"
+	}
+	return fmt.Sprintf("ChangeKind(%d)", int(k))
+}
+
+// This function encodes the change kind by its name.
+func (k ChangeKind) MarshalText() ([]byte, error) {
+	return []byte(k.String()), nil
+}
+
+// This function decodes the change kind from its name.
+func (k *ChangeKind) UnmarshalText(text []byte) error {
+	for _, kind := range []ChangeKind{ChangeInsert, ChangeUpdate, ChangePatch, ChangeDelete} {
+		if kind.String() == string(text) {
+			*k = kind
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown change kind '%s'", text)
+}
+
+// ChangeEvent represents a change to a record. Before is the
+// record before the change and After the record after it, inserts
+// have no Before and deletes have no After. Seq numbers the
+// changes of the collection in the order they were saved, Time
+// is when the change was made.
+// The records are shared by the watchers and must not be changed.
+type ChangeEvent struct {
+	Seq    uint64         `json:"seq"`
+	Kind   ChangeKind     `json:"kind"`
+	ID     int            `json:"id"`
+	Time   time.Time      `json:"time"`
+	Before *models.Record `json:"before,omitempty"`
+	After  *models.Record `json:"after,omitempty"`
+}
+
+// pendingChange is a change waiting for its record to be saved
+// with the actor who made it.
+type pendingChange struct {
+	event ChangeEvent
+	actor string
+}
+
+// ChangeFeedOptions represents the settings of the change feed.
+// The feed keeps the changes in a file of the collection so
+// watchers can resume from the sequence of the last change they
+// handled, even after the collection is opened again.
+type ChangeFeedOptions struct {
+	Enabled bool `json:"enabled"`
+	// Number of the latest changes kept, zero keeps all of them.
+	Retain int `json:"retain,omitempty"`
+}
+
+// ChangeFilter selects the changes sent to a watcher. Empty
+// Kinds and IDs select all of them.
+type ChangeFilter struct {
+	Kinds []ChangeKind
+	IDs   []int
+	// Changes matching the filter are sent when Match is nil
+	// or returns true.
+	Match func(event ChangeEvent) bool
+	// Sequence of the first change to send. The changes from it
+	// are read from the change feed before the new ones are sent.
+	// Zero only sends the new changes.
+	From uint64
+}
+
+// This function tells if the change is selected by the filter.
+func (f ChangeFilter) matches(event ChangeEvent) bool {
+	if len(f.Kinds) > 0 && !containsKind(f.Kinds, event.Kind) {
+		return false
+	}
+	if len(f.IDs) > 0 && !containsID(f.IDs, event.ID) {
+		return false
+	}
+	return f.Match == nil || f.Match(event)
+}
+
+func containsKind(kinds []ChangeKind, kind ChangeKind) bool {
+	for _, k := range kinds {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
+
+func containsID(ids []int, id int) bool {
+	for _, i := range ids {
+		if i == id {
+			return true
+		}
+	}
+	return false
+}
+
+// Watcher represents a subscription to the changes of a
+// collection. The changes are received from Events in order,
+// the channel is closed when the watcher stops and Err tells why.
+type Watcher struct {
+	collection *Collection
+	filter     ChangeFilter
+	events     chan ChangeEvent
+	notify     chan struct{}
+	done       chan struct{}
+	mu         sync.Mutex
+	pending    []ChangeEvent
+	err        error
+}
+
+// This function enables or disables the change feed of the
+// collection. The setting is saved in the metadata of the
+// collection. Without the feed watchers only receive the new
+// changes and sequences start again when the collection is opened.
+func (c *Collection) SetChangeFeed(opts ChangeFeedOptions) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.feedOpts = opts
+	if err := c.openChangeFeed(); err != nil {
+		return err
+	}
+	return c.saveMetadata()
+}
+
+// This function gets the sequence of the last saved change of
+// the collection.
+func (c *Collection) LastChange() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.seq
+}
+
+// This function watches the changes of the collection selected
+// by the filter. Inserts, updates, patches, deletes, expiries and
+// migrations are sent once they are saved: changes made in the
+// memory by the next flush and deletes, which remove the record
+// from the storage, right away. A watcher falling more than
+// WATCH_BUFFER changes behind is stopped with ErrLagging, resume
+// it from the sequence after its last change.
+func (c *Collection) Watch(filter ChangeFilter) (*Watcher, error) {
+	return c.WatchContext(context.Background(), filter)
+}
+
+// This function is Watch with a context, the watcher stops
+// when the context is done.
+func (c *Collection) WatchContext(ctx context.Context, filter ChangeFilter) (*Watcher, error) {
+	if err := c.ready(ctx); err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if filter.From > c.seq+1 {
+		return nil, invalid(fmt.Errorf("change %d is after the last change %d", filter.From, c.seq))
+	}
+	// The changes to replay are read from the feed up to its
+	// current size, the later ones are sent by the collection.
+	var replay io.ReadCloser
+	if filter.From > 0 && filter.From <= c.seq {
+		if c.feed == nil || c.feed.count == 0 || filter.From < c.feed.first {
+			return nil, fmt.Errorf("change %d is not in the change feed: %w", filter.From, ErrNotFound)
+		}
+		file, err := os.Open(c.feed.path)
+		if err != nil {
+			return nil, fmt.Errorf("error opening file: %w", err)
+		}
+		replay = struct {
+			io.Reader
+			io.Closer
+		}{io.NewSectionReader(file, 0, c.feed.size), file}
+	}
+
+	w := &Watcher{
+		collection: c,
+		filter:     filter,
+		events:     make(chan ChangeEvent),
+		notify:     make(chan struct{}, 1),
+		done:       make(chan struct{}),
+	}
+	if c.watchers == nil {
+		c.watchers = make(map[*Watcher]struct{})
+	}
+	c.watchers[w] = struct{}{}
+	go w.run(ctx, replay)
+	return w, nil
+}
+
+// This function gets the channel the changes are received from.
+func (w *Watcher) Events() <-chan ChangeEvent {
+	return w.events
+}
+
+// This function stops the watcher. The changes not received
+// yet are dropped.
+func (w *Watcher) Close() {
+	w.stop(nil)
+}
+
+// This function gets why the watcher stopped. It is nil while
+// the watcher runs and after Close.
+func (w *Watcher) Err() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.err
+}
+
+// This function stops the watcher with the error, only the
+// first error is kept.
+func (w *Watcher) stop(err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.stopLocked(err)
+}
+
+// The caller must hold the lock of the watcher.
+func (w *Watcher) stopLocked(err error) {
+	select {
+	case <-w.done:
+	default:
+		w.err = err
+		close(w.done)
+	}
+}
+
+// This function queues a change for the watcher.
+// The caller must hold the lock of the collection.
+func (w *Watcher) push(event ChangeEvent) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if len(w.pending) >= WATCH_BUFFER {
+		w.stopLocked(fmt.Errorf("watcher is %d changes behind: %w", len(w.pending), ErrLagging))
+		return
+	}
+	w.pending = append(w.pending, event)
+	select {
+	case w.notify <- struct{}{}:
+	default:
+	}
+}
+
+// This function sends the replayed changes and then the queued
+// ones until the watcher stops.
+func (w *Watcher) run(ctx context.Context, replay io.ReadCloser) {
+	defer func() {
+		w.collection.mu.Lock()
+		delete(w.collection.watchers, w)
+		w.collection.mu.Unlock()
+		close(w.events)
+	}()
+
+	if replay != nil {
+		err := readJournal(replay, func(_ byte, seq uint64, payload []byte) error {
+			if seq < w.filter.From {
+				return nil
+			}
+			var event ChangeEvent
+			if err := json.Unmarshal(payload, &event); err != nil {
+				return corrupt(err)
+			}
+			return w.send(ctx, event)
+		})
+		replay.Close()
+		if err != nil {
+			w.stop(err)
+			return
+		}
+	}
+
+	for {
+		w.mu.Lock()
+		events := w.pending
+		w.pending = nil
+		w.mu.Unlock()
+		for _, event := range events {
+			if err := w.send(ctx, event); err != nil {
+				w.stop(err)
+				return
+			}
+		}
+
+		select {
+		case <-w.notify:
+		case <-w.done:
+			return
+		case <-ctx.Done():
+			w.stop(ctx.Err())
+			return
+		}
+	}
+}
+
+// This function sends the change if the filter selects it.
+func (w *Watcher) send(ctx context.Context, event ChangeEvent) error {
+	if event.Seq < w.filter.From || !w.filter.matches(event) {
+		return nil
+	}
+	select {
+	case w.events <- event:
+		return nil
+	case <-w.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// This function tells if the changes are recorded.
+// The caller must hold the lock.
+func (c *Collection) watched() bool {
+	return c.feed != nil || c.audit != nil || c.history != nil || len(c.watchers) > 0
+}
+
+// This function queues the change of the record until it is saved,
+// see commitChanges. The records are copied so later changes don't
+// alter the event.
+// The caller must hold the lock.
+func (c *Collection) changed(ctx context.Context, kind ChangeKind, id int, before, after *models.Record) {
+	if !c.watched() {
+		return
+	}
+	event := ChangeEvent{Kind: kind, ID: id, Time: c.now()}
+	if before != nil {
+		event.Before = before.Copy()
+	}
+	if after != nil {
+		event.After = after.Copy()
+	}
+	c.pending = append(c.pending, pendingChange{event: event, actor: ActorFrom(ctx)})
+}
+
+// This function numbers the queued changes selected by the
+// function, appends them to the change feed, the audit trail and
+// the history and sends them to the watchers. It is called once the
+// changes are saved so the feed never holds a change the storage lost.
+// Every selected change is committed, the first error is returned.
+// The caller must hold the lock.
+func (c *Collection) commitChanges(selected func(event ChangeEvent) bool) error {
+	var rest []pendingChange
+	var first error
+	for _, change := range c.pending {
+		if !selected(change.event) {
+			rest = append(rest, change)
+			continue
+		}
+		if err := c.commitChange(change); err != nil && first == nil {
+			first = err
+		}
+	}
+	c.pending = rest
+	return first
+}
+
+// This function commits the queued changes of the record with
+// the id, which was written to the storage.
+// The caller must hold the lock.
+func (c *Collection) commitRecord(id int) error {
+	return c.commitChanges(func(event ChangeEvent) bool { return event.ID == id })
+}
+
+// This function numbers the change and records it.
+// The caller must hold the lock.
+func (c *Collection) commitChange(change pendingChange) error {
+	c.seq++
+	event := change.event
+	event.Seq = c.seq
+
+	var errs []error
+	if c.feed != nil {
+		err := c.feed.appendChange(event)
+		if err == nil {
+			err = c.feed.trim(c.feedOpts.Retain)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("error writing the change feed: %w", err))
+		}
+	}
+	if c.audit != nil {
+		if err := c.audit.appendEntry(newAuditEntry(change.actor, event)); err != nil {
+			errs = append(errs, fmt.Errorf("error writing the audit trail: %w", err))
+		}
+	}
+	if c.history != nil {
+		if err := c.history.record(event); err != nil {
+			errs = append(errs, fmt.Errorf("error writing the history: %w", err))
+		}
+	}
+	for w := range c.watchers {
+		w.push(event)
+	}
+	return errors.Join(errs...)
+}
+
+// This function closes the change feed and opens it again when
+// it is enabled. The sequence continues from its last change.
+// The caller must hold the lock.
+func (c *Collection) openChangeFeed() error {
+	if c.feed != nil {
+		c.feed.close()
+		c.feed = nil
+	}
+	if !c.feedOpts.Enabled {
+		return nil
+	}
+	feed, err := openChangeFeed(filepath.Join(c.dir, c.name))
+	if err != nil {
+		return err
+	}
+	c.feed = feed
+	if feed.last > c.seq {
+		c.seq = feed.last
+	}
+	return nil
+}
+
+// This function stops the watchers with the error and closes
+// the change feed, the audit trail and the history.
+// The caller must hold the lock.
+func (c *Collection) closeChanges(err error) {
+	for w := range c.watchers {
+		w.stop(err)
+	}
+	c.pending = nil
+	if c.feed != nil {
+		c.feed.close()
+		c.feed = nil
+	}
+	if c.audit != nil {
+		c.audit.close()
+		c.audit = nil
+	}
+	if c.history != nil {
+		c.history.close()
+		c.history = nil
+	}
+}
+
+// changeFeed is the journal of the changes of a collection. The
+// entries have the kind of the change as operation and its sequence
+// as id.
+type changeFeed struct {
+	*journal
+	// Number and sequences of the changes in the journal.
+	count       int
+	first, last uint64
+}
+
+// This function opens the change feed of the collection directory.
+func openChangeFeed(dir string) (*changeFeed, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	feed := &changeFeed{}
+	j, err := openJournal(filepath.Join(dir, CHANGES_FILE), func(_ byte, seq uint64, _ []byte) {
+		feed.add(seq)
+	})
+	if err != nil {
+		return nil, err
+	}
+	feed.journal = j
+	return feed, nil
+}
+
+// This function accounts a change of the journal.
+func (f *changeFeed) add(seq uint64) {
+	if f.count == 0 {
+		f.first = seq
+	}
+	f.last = seq
+	f.count++
+}
+
+// This function appends the change to the journal.
+func (f *changeFeed) appendChange(event ChangeEvent) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("error encoding data: %v", err)
+	}
+	if err := f.append(byte(event.Kind), event.Seq, payload); err != nil {
+		return err
+	}
+	f.add(event.Seq)
+	return nil
+}
+
+// This function rewrites the journal with the latest retain
+// changes once it holds twice as many.
+func (f *changeFeed) trim(retain int) error {
+	if retain <= 0 || f.count <= 2*retain {
+		return nil
+	}
+	trimmed := &changeFeed{journal: f.journal}
+	keep := f.last - uint64(retain)
+	err := f.rewrite(func(_ byte, seq uint64, _ []byte) bool {
+		if seq <= keep {
+			return false
+		}
+		trimmed.add(seq)
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	*f = *trimmed
+	return nil
+}
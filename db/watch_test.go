@@ -0,0 +1,241 @@
+package db
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/OmerMohideen/minibase/logger"
+	"github.com/OmerMohideen/minibase/models"
+)
+
+// This function receives the next change of the watcher.
+func nextChange(t *testing.T, w *Watcher) ChangeEvent {
+	t.Helper()
+	select {
+	case event, ok := <-w.Events():
+		if !ok {
+			t.Fatalf("Events() failed: Watcher stopped with %v", w.Err())
+		}
+		return event
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Events() failed: No change received")
+	}
+	return ChangeEvent{}
+}
+
+func TestCollection_Watch(t *testing.T) {
+	logger, tempDir := logger.New(nil, nil), t.TempDir()
+	collection := NewCollection("test_collection", logger)
+	collection.SetDir(tempDir)
+	defer collection.Close()
+
+	all, err := collection.Watch(ChangeFilter{})
+	if err != nil {
+		t.Fatalf("Watch() failed: %v", err)
+	}
+	deletes, err := collection.Watch(ChangeFilter{Kinds: []ChangeKind{ChangeDelete}, IDs: []int{2}})
+	if err != nil {
+		t.Fatalf("Watch() failed: %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := collection.InsertRecord(&models.Record{Fields: map[string]interface{}{"n": i}}); err != nil {
+			t.Fatalf("InsertRecord() failed: %v", err)
+		}
+	}
+	if err := collection.UpdateRecord(1, &models.Record{Fields: map[string]interface{}{"n": 10}}); err != nil {
+		t.Fatalf("UpdateRecord() failed: %v", err)
+	}
+	if _, err := collection.Patch(1, models.Patch{"$inc": {"n": 1}}); err != nil {
+		t.Fatalf("Patch() failed: %v", err)
+	}
+	if last := collection.LastChange(); last != 0 {
+		t.Errorf("LastChange() failed: Expected no change before the flush, got %d", last)
+	}
+	if err := collection.FlushRecords(); err != nil {
+		t.Fatalf("FlushRecords() failed: %v", err)
+	}
+	collection.records = make(map[int]*models.Record)
+	if err := collection.DeleteRecord(2); err != nil {
+		t.Fatalf("DeleteRecord() failed: %v", err)
+	}
+
+	expected := []struct {
+		kind          ChangeKind
+		id            int
+		before, after interface{}
+	}{
+		{ChangeInsert, 1, nil, int64(0)},
+		{ChangeInsert, 2, nil, int64(1)},
+		{ChangeUpdate, 1, int64(0), int64(10)},
+		{ChangePatch, 1, int64(10), int64(11)},
+		{ChangeDelete, 2, int64(1), nil},
+	}
+	for i, e := range expected {
+		event := nextChange(t, all)
+		if event.Seq != uint64(i+1) || event.Kind != e.kind || event.ID != e.id {
+			t.Fatalf("Watch() failed: Expected change %d to be %s of %d, got %d %s of %d", i+1, e.kind, e.id, event.Seq, event.Kind, event.ID)
+		}
+		var before, after interface{}
+		if event.Before != nil {
+			before = event.Before.Fields["n"]
+		}
+		if event.After != nil {
+			after = event.After.Fields["n"]
+		}
+		if before != e.before || after != e.after {
+			t.Errorf("Watch() failed: Expected change %d from %v to %v, got %v to %v", i+1, e.before, e.after, before, after)
+		}
+	}
+	if event := nextChange(t, deletes); event.Seq != 5 {
+		t.Errorf("Watch() failed: Expected only the delete of 2, got %d %s", event.Seq, event.Kind)
+	}
+
+	all.Close()
+	if _, ok := <-all.Events(); ok || all.Err() != nil {
+		t.Errorf("Close() failed: Expected the watcher to stop without an error, got %v", all.Err())
+	}
+	collection.Close()
+	for range deletes.Events() {
+	}
+	if !errors.Is(deletes.Err(), ErrClosed) {
+		t.Errorf("Close() failed: Expected the watcher to stop with ErrClosed, got %v", deletes.Err())
+	}
+}
+
+func TestCollection_ChangeFeed(t *testing.T) {
+	logger, tempDir := logger.New(nil, nil), t.TempDir()
+	collection := NewCollection("test_collection", logger)
+	collection.SetDir(tempDir)
+	if err := collection.SetChangeFeed(ChangeFeedOptions{Enabled: true, Retain: 5}); err != nil {
+		t.Fatalf("SetChangeFeed() failed: %v", err)
+	}
+	for i := 0; i < 20; i++ {
+		if err := collection.InsertRecord(&models.Record{Fields: map[string]interface{}{"n": i}}); err != nil {
+			t.Fatalf("InsertRecord() failed: %v", err)
+		}
+	}
+	if err := collection.FlushRecords(); err != nil {
+		t.Fatalf("FlushRecords() failed: %v", err)
+	}
+	// The change is lost with the record it was made to.
+	if err := collection.UpdateRecord(1, &models.Record{Fields: map[string]interface{}{"n": 100}}); err != nil {
+		t.Fatalf("UpdateRecord() failed: %v", err)
+	}
+	collection.Close()
+
+	newcollection := NewCollection("test_collection", logger)
+	newcollection.SetDir(tempDir)
+	defer newcollection.Close()
+	if last := newcollection.LastChange(); last != 20 {
+		t.Fatalf("LastChange() failed: Expected 20, got %d", last)
+	}
+	if _, err := newcollection.Watch(ChangeFilter{From: 1}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Watch() failed: Expected ErrNotFound for a trimmed change, got %v", err)
+	}
+	if _, err := newcollection.Watch(ChangeFilter{From: 30}); !errors.Is(err, ErrValidation) {
+		t.Errorf("Watch() failed: Expected ErrValidation for a future change, got %v", err)
+	}
+
+	w, err := newcollection.Watch(ChangeFilter{From: 16})
+	if err != nil {
+		t.Fatalf("Watch() failed: %v", err)
+	}
+	if err := newcollection.DeleteRecord(20); err != nil {
+		t.Fatalf("DeleteRecord() failed: %v", err)
+	}
+	for seq := uint64(16); seq <= 21; seq++ {
+		event := nextChange(t, w)
+		if event.Seq != seq {
+			t.Fatalf("Watch() failed: Expected change %d, got %d", seq, event.Seq)
+		}
+		if seq <= 20 && (event.Kind != ChangeInsert || event.After.Fields["n"] != int64(seq-1)) {
+			t.Errorf("Watch() failed: Expected the insert of %d, got %s %v", seq-1, event.Kind, event.After)
+		}
+	}
+
+	newcollection.feed.file.Close()
+	if err := newcollection.UpdateRecord(2, &models.Record{Fields: map[string]interface{}{"n": 200}}); err != nil {
+		t.Fatalf("UpdateRecord() failed: %v", err)
+	}
+	if err := newcollection.FlushRecords(); err == nil {
+		t.Errorf("FlushRecords() failed: Expected the error of writing the change feed")
+	}
+}
+
+func TestCollection_WatchLagging(t *testing.T) {
+	logger, tempDir := logger.New(nil, nil), t.TempDir()
+	collection := NewCollection("test_collection", logger)
+	collection.SetDir(tempDir)
+	defer collection.Close()
+
+	w, err := collection.Watch(ChangeFilter{})
+	if err != nil {
+		t.Fatalf("Watch() failed: %v", err)
+	}
+	for i := 0; i < 3*WATCH_BUFFER; i++ {
+		if err := collection.InsertRecord(&models.Record{Fields: map[string]interface{}{"n": i}}); err != nil {
+			t.Fatalf("InsertRecord() failed: %v", err)
+		}
+	}
+	if err := collection.FlushRecords(); err != nil {
+		t.Fatalf("FlushRecords() failed: %v", err)
+	}
+	for range w.Events() {
+	}
+	if !errors.Is(w.Err(), ErrLagging) {
+		t.Errorf("Watch() failed: Expected ErrLagging, got %v", w.Err())
+	}
+}
+
+func TestCollection_WatchMigrations(t *testing.T) {
+	logger, tempDir := logger.New(nil, nil), t.TempDir()
+	collection := NewCollection("test_collection", logger)
+	collection.SetDir(tempDir)
+	defer collection.Close()
+
+	for i := 0; i < 3; i++ {
+		if err := collection.InsertRecord(&models.Record{Fields: map[string]interface{}{"city": "colombo"}}); err != nil {
+			t.Fatalf("InsertRecord() failed: %v", err)
+		}
+	}
+	if err := collection.FlushRecords(); err != nil {
+		t.Fatalf("FlushRecords() failed: %v", err)
+	}
+	collection.records = make(map[int]*models.Record)
+	w, err := collection.Watch(ChangeFilter{Kinds: []ChangeKind{ChangeUpdate}})
+	if err != nil {
+		t.Fatalf("Watch() failed: %v", err)
+	}
+
+	collection.RegisterMigration(Migration{Version: 1, Up: func(record *models.Record) error {
+		return record.SetField("city", "Colombo")
+	}})
+	if report, err := collection.Migrate(false); err != nil || report.Upgraded != 3 {
+		t.Fatalf("Migrate() failed: Expected 3 upgraded, got %+v, %v", report, err)
+	}
+	if seq := collection.LastChange(); seq != 3 {
+		t.Errorf("Migrate() failed: Expected an update for every upgraded record, got %d changes", seq)
+	}
+	event := nextChange(t, w)
+	if event.Before.Fields["city"] != "colombo" || event.After.Fields["city"] != "Colombo" {
+		t.Errorf("Migrate() failed: Unexpected change %v to %v", event.Before, event.After)
+	}
+
+	collection.RegisterMigration(Migration{Version: 2, Up: func(record *models.Record) error {
+		return record.SetField("city", "COLOMBO")
+	}})
+	if _, err := collection.GetRecordByID(1); err != nil {
+		t.Fatalf("GetRecordByID() failed: %v", err)
+	}
+	if seq := collection.LastChange(); seq != 3 {
+		t.Errorf("GetRecordByID() failed: Expected no change before the flush, got %d", seq)
+	}
+	if err := collection.FlushRecords(); err != nil {
+		t.Fatalf("FlushRecords() failed: %v", err)
+	}
+	if seq := collection.LastChange(); seq != 4 {
+		t.Errorf("GetRecordByID() failed: Expected an update for the record upgraded on load, got %d changes", seq)
+	}
+}
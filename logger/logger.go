@@ -11,6 +11,7 @@ import (
 // Logger represents a custom logger.
 type Logger struct {
 	infoLog  *log.Logger
+	warnLog  *log.Logger
 	errorLog *log.Logger
 }
 
@@ -18,6 +19,7 @@ type Logger struct {
 func New(infoHandle, errorHandle *os.File) *Logger {
 	return &Logger{
 		infoLog:  log.New(infoHandle, "INFO: ", log.Ldate|log.Ltime),
+		warnLog:  log.New(errorHandle, "WARN: ", log.Ldate|log.Ltime),
 		errorLog: log.New(errorHandle, "ERROR: ", log.Ldate|log.Ltime),
 	}
 }
@@ -27,6 +29,12 @@ func (l *Logger) Info(format string, v ...interface{}) {
 	l.infoLog.Printf(format, v...)
 }
 
+// This function logs warning messages.
+// Warnings are written to the error handle.
+func (l *Logger) Warn(format string, v ...interface{}) {
+	l.warnLog.Printf(format, v...)
+}
+
 // This function logs error messages.
 func (l *Logger) Error(format string, v ...interface{}) {
 	l.errorLog.Printf(format, v...)
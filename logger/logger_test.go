@@ -25,6 +25,24 @@ func TestLogger_Info(t *testing.T) {
 	}
 }
 
+func TestLogger_Warn(t *testing.T) {
+	tmpfile, err := os.CreateTemp("", "warn_log_test")
+	if err != nil {
+		t.Fatalf("Failed to create temporary file: %v", err)
+	}
+	defer os.Remove(tmpfile.Name())
+	logger := New(nil, tmpfile)
+	logger.Warn("This is a warning message")
+	content, err := os.ReadFile(tmpfile.Name())
+	if err != nil {
+		t.Fatalf("Failed to read log content: %v", err)
+	}
+	expectedOutput := fmt.Sprintf("WARN: %s %s This is a warning message\n", time.Now().Format("2006/01/02"), time.Now().Format("15:04:05"))
+	if string(content) != expectedOutput {
+		t.Errorf("Warn() failed: Warn log output does not match expected. Got: %s, Expected: %s", content, expectedOutput)
+	}
+}
+
 func TestLogger_Error(t *testing.T) {
 	tmpfile, err := os.CreateTemp("", "error_log_test")
 	if err != nil {
@@ -0,0 +1,390 @@
+package models
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The name of the struct tag read by Marshal and Unmarshal.
+// The tag holds the field name followed by options:
+//
+//	Name  string `minibase:"name"`
+//	Email string `minibase:"email,omitempty,index"`
+//	ID    int    `minibase:"id"`
+//	Notes string `minibase:"-"`
+//
+// A field named "id" holds the ID of the record instead of
+// being saved in Fields. Fields without a tag use their Go name.
+// The index option only marks the field in StructFields, it
+// doesn't change how the field is saved.
+const TAG = "minibase"
+
+// StructField describes an exported field of a struct mapped
+// to a record field.
+type StructField struct {
+	Name      string
+	OmitEmpty bool
+	Index     bool
+	index     []int
+}
+
+var (
+	timeType   = reflect.TypeOf(time.Time{})
+	structInfo sync.Map // map[reflect.Type][]StructField
+)
+
+// This function gets the fields of a struct type that are
+// mapped to a record. Embedded structs without a tag are
+// flattened into the parent.
+func StructFields(t reflect.Type) ([]StructField, error) {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("expected a struct, got %s", t)
+	}
+	if fields, ok := structInfo.Load(t); ok {
+		return fields.([]StructField), nil
+	}
+
+	var fields []StructField
+	seen := make(map[string]bool)
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag, tagged := field.Tag.Lookup(TAG)
+		if tag == "-" || !field.IsExported() && !field.Anonymous {
+			continue
+		}
+		if field.Anonymous && !tagged && field.Type.Kind() == reflect.Struct {
+			embedded, err := StructFields(field.Type)
+			if err != nil {
+				return nil, err
+			}
+			for _, inner := range embedded {
+				inner.index = append([]int{i}, inner.index...)
+				if !seen[inner.Name] {
+					seen[inner.Name] = true
+					fields = append(fields, inner)
+				}
+			}
+			continue
+		}
+		if !field.IsExported() {
+			continue
+		}
+
+		options := strings.Split(tag, ",")
+		info := StructField{Name: options[0], index: []int{i}}
+		if info.Name == "" {
+			info.Name = field.Name
+		}
+		for _, option := range options[1:] {
+			switch option {
+			case "omitempty":
+				info.OmitEmpty = true
+			case "index":
+				info.Index = true
+			default:
+				return nil, fmt.Errorf("%s.%s: unknown tag option '%s'", t, field.Name, option)
+			}
+		}
+		if seen[info.Name] {
+			return nil, fmt.Errorf("%s.%s: duplicate field name '%s'", t, field.Name, info.Name)
+		}
+		seen[info.Name] = true
+		fields = append(fields, info)
+	}
+
+	structInfo.Store(t, fields)
+	return fields, nil
+}
+
+// This function converts a struct into a record.
+// The value must be a struct or a pointer to one.
+func Marshal(v interface{}) (*Record, error) {
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Pointer {
+		if rv.IsNil() {
+			return nil, fmt.Errorf("cannot marshal nil %s", rv.Type())
+		}
+		rv = rv.Elem()
+	}
+	id, document, err := marshalDocument(rv, true)
+	if err != nil {
+		return nil, err
+	}
+	return &Record{ID: id, Fields: document}, nil
+}
+
+// This function converts the fields of a struct into a document.
+// Only the top level struct keeps its "id" field out of the
+// document, nested structs save it as an ordinary field.
+func marshalDocument(rv reflect.Value, top bool) (int, map[string]interface{}, error) {
+	fields, err := StructFields(rv.Type())
+	if err != nil {
+		return 0, nil, err
+	}
+	id, document := 0, make(map[string]interface{}, len(fields))
+	for _, field := range fields {
+		value := rv.FieldByIndex(field.index)
+		if field.OmitEmpty && isEmpty(value) {
+			continue
+		}
+		if top && field.Name == "id" {
+			if !value.CanInt() {
+				return 0, nil, fmt.Errorf("field 'id': expected an integer, got %s", value.Type())
+			}
+			id = int(value.Int())
+			continue
+		}
+		converted, err := marshalValue(value)
+		if err != nil {
+			return 0, nil, fmt.Errorf("field '%s': %w", field.Name, err)
+		}
+		document[field.Name] = converted
+	}
+	return id, document, nil
+}
+
+// This function converts a value into the type its kind is kept as.
+// Structs other than time.Time become documents.
+func marshalValue(rv reflect.Value) (interface{}, error) {
+	switch rv.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		if rv.IsNil() {
+			return nil, nil
+		}
+		return marshalValue(rv.Elem())
+	case reflect.Struct:
+		if rv.Type() == timeType {
+			return Normalize(rv.Interface())
+		}
+		_, document, err := marshalDocument(rv, false)
+		return document, err
+	case reflect.Slice, reflect.Array:
+		if rv.Type().Elem().Kind() == reflect.Uint8 {
+			return Normalize(rv.Interface())
+		}
+		if rv.Kind() == reflect.Slice && rv.IsNil() {
+			return nil, nil
+		}
+		array := make([]interface{}, rv.Len())
+		for i := range array {
+			item, err := marshalValue(rv.Index(i))
+			if err != nil {
+				return nil, fmt.Errorf("[%d]: %w", i, err)
+			}
+			array[i] = item
+		}
+		return array, nil
+	case reflect.Map:
+		if rv.Type().Key().Kind() != reflect.String {
+			return nil, fmt.Errorf("unsupported map key type %s", rv.Type().Key())
+		}
+		if rv.IsNil() {
+			return nil, nil
+		}
+		document := make(map[string]interface{}, rv.Len())
+		iter := rv.MapRange()
+		for iter.Next() {
+			item, err := marshalValue(iter.Value())
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", iter.Key().String(), err)
+			}
+			document[iter.Key().String()] = item
+		}
+		return document, nil
+	}
+	return Normalize(rv.Interface())
+}
+
+// This function fills a struct from a record.
+// The value must be a pointer to a struct. Fields missing from
+// the record are left unchanged.
+func Unmarshal(record *Record, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() {
+		return fmt.Errorf("expected a non-nil pointer, got %T", v)
+	}
+	return unmarshalDocument(rv.Elem(), record.Fields, &record.ID)
+}
+
+// This function fills the fields of a struct from a document.
+// The id is only given for the top level struct.
+func unmarshalDocument(rv reflect.Value, document map[string]interface{}, id *int) error {
+	fields, err := StructFields(rv.Type())
+	if err != nil {
+		return err
+	}
+	for _, field := range fields {
+		if id != nil && field.Name == "id" {
+			target := rv.FieldByIndex(field.index)
+			if !target.CanInt() {
+				return fmt.Errorf("field 'id': expected an integer, got %s", target.Type())
+			}
+			if target.OverflowInt(int64(*id)) {
+				return fmt.Errorf("field 'id': value %d overflows %s", *id, target.Type())
+			}
+			target.SetInt(int64(*id))
+			continue
+		}
+		value, ok := document[field.Name]
+		if !ok {
+			continue
+		}
+		if err := unmarshalValue(value, rv.FieldByIndex(field.index)); err != nil {
+			return fmt.Errorf("field '%s': %w", field.Name, err)
+		}
+	}
+	return nil
+}
+
+// This function sets the target to the value converted to its type.
+func unmarshalValue(value interface{}, target reflect.Value) error {
+	if value == nil {
+		target.Set(reflect.Zero(target.Type()))
+		return nil
+	}
+	value, err := Normalize(value)
+	if err != nil {
+		return err
+	}
+	mismatch := func() error {
+		kind, _ := KindOf(value)
+		return fmt.Errorf("cannot assign %s to %s", kind, target.Type())
+	}
+
+	switch target.Kind() {
+	case reflect.Interface:
+		if reflect.TypeOf(value).AssignableTo(target.Type()) {
+			target.Set(reflect.ValueOf(value))
+			return nil
+		}
+		return mismatch()
+	case reflect.Pointer:
+		if target.IsNil() {
+			target.Set(reflect.New(target.Type().Elem()))
+		}
+		return unmarshalValue(value, target.Elem())
+	case reflect.Bool:
+		v, ok := value.(bool)
+		if !ok {
+			return mismatch()
+		}
+		target.SetBool(v)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		v, ok := value.(int64)
+		if !ok {
+			return mismatch()
+		}
+		if target.OverflowInt(v) {
+			return fmt.Errorf("value %d overflows %s", v, target.Type())
+		}
+		target.SetInt(v)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		v, ok := value.(int64)
+		if !ok {
+			return mismatch()
+		}
+		if v < 0 || target.OverflowUint(uint64(v)) {
+			return fmt.Errorf("value %d overflows %s", v, target.Type())
+		}
+		target.SetUint(uint64(v))
+	case reflect.Float32, reflect.Float64:
+		switch v := value.(type) {
+		case float64:
+			if target.Kind() == reflect.Float32 && !math.IsInf(v, 0) && target.OverflowFloat(v) {
+				return fmt.Errorf("value %v overflows %s", v, target.Type())
+			}
+			target.SetFloat(v)
+		case int64:
+			target.SetFloat(float64(v))
+		default:
+			return mismatch()
+		}
+	case reflect.String:
+		v, ok := value.(string)
+		if !ok {
+			return mismatch()
+		}
+		target.SetString(v)
+	case reflect.Struct:
+		if target.Type() == timeType {
+			v, ok := value.(time.Time)
+			if !ok {
+				return mismatch()
+			}
+			target.Set(reflect.ValueOf(v))
+			return nil
+		}
+		v, ok := value.(map[string]interface{})
+		if !ok {
+			return mismatch()
+		}
+		return unmarshalDocument(target, v, nil)
+	case reflect.Slice, reflect.Array:
+		if target.Type().Elem().Kind() == reflect.Uint8 {
+			v, ok := value.([]byte)
+			if !ok {
+				return mismatch()
+			}
+			if target.Kind() == reflect.Array {
+				if len(v) != target.Len() {
+					return fmt.Errorf("cannot assign %d bytes to %s", len(v), target.Type())
+				}
+				reflect.Copy(target, reflect.ValueOf(v))
+				return nil
+			}
+			target.SetBytes(append([]byte(nil), v...))
+			return nil
+		}
+		v, ok := value.([]interface{})
+		if !ok {
+			return mismatch()
+		}
+		if target.Kind() == reflect.Array {
+			if len(v) != target.Len() {
+				return fmt.Errorf("cannot assign %d items to %s", len(v), target.Type())
+			}
+		} else {
+			target.Set(reflect.MakeSlice(target.Type(), len(v), len(v)))
+		}
+		for i, item := range v {
+			if err := unmarshalValue(item, target.Index(i)); err != nil {
+				return fmt.Errorf("[%d]: %w", i, err)
+			}
+		}
+	case reflect.Map:
+		if target.Type().Key().Kind() != reflect.String {
+			return fmt.Errorf("unsupported map key type %s", target.Type().Key())
+		}
+		v, ok := value.(map[string]interface{})
+		if !ok {
+			return mismatch()
+		}
+		target.Set(reflect.MakeMapWithSize(target.Type(), len(v)))
+		for key, item := range v {
+			element := reflect.New(target.Type().Elem()).Elem()
+			if err := unmarshalValue(item, element); err != nil {
+				return fmt.Errorf("%s: %w", key, err)
+			}
+			target.SetMapIndex(reflect.ValueOf(key).Convert(target.Type().Key()), element)
+		}
+	default:
+		return mismatch()
+	}
+	return nil
+}
+
+// This function reports whether the value is skipped by omitempty.
+func isEmpty(rv reflect.Value) bool {
+	switch rv.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return rv.Len() == 0
+	}
+	return rv.IsZero()
+}
@@ -0,0 +1,110 @@
+package models
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type address struct {
+	City string `minibase:"city"`
+	Zip  int    `minibase:"zip,omitempty"`
+}
+
+type timestamps struct {
+	Created time.Time `minibase:"created"`
+}
+
+type user struct {
+	timestamps
+	ID      int               `minibase:"id"`
+	Name    string            `minibase:"name"`
+	Email   string            `minibase:"email,omitempty,index"`
+	Age     uint8             `minibase:"age"`
+	Score   float32           `minibase:"score"`
+	Tags    []string          `minibase:"tags"`
+	Address *address          `minibase:"address"`
+	Labels  map[string]string `minibase:"labels,omitempty"`
+	Secret  string            `minibase:"-"`
+	Nick    string
+}
+
+func TestMarshal(t *testing.T) {
+	created := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	record, err := Marshal(&user{
+		timestamps: timestamps{Created: created},
+		ID:         7,
+		Name:       "Sajith",
+		Age:        30,
+		Score:      1.5,
+		Tags:       []string{"a"},
+		Address:    &address{City: "Colombo"},
+		Secret:     "hidden",
+		Nick:       "saj",
+	})
+	if err != nil {
+		t.Fatalf("Marshal() failed: %v", err)
+	}
+	expected := map[string]interface{}{
+		"created": created,
+		"name":    "Sajith",
+		"age":     int64(30),
+		"score":   1.5,
+		"tags":    []interface{}{"a"},
+		"address": map[string]interface{}{"city": "Colombo"},
+		"Nick":    "saj",
+	}
+	if record.ID != 7 {
+		t.Errorf("Marshal() failed: Expected ID 7, got %d", record.ID)
+	}
+	if !reflect.DeepEqual(record.Fields, expected) {
+		t.Errorf("Marshal() failed: Expected fields %#v, got %#v", expected, record.Fields)
+	}
+
+	fields, err := StructFields(reflect.TypeOf(user{}))
+	if err != nil {
+		t.Fatalf("StructFields() failed: %v", err)
+	}
+	for _, field := range fields {
+		if field.Index != (field.Name == "email") {
+			t.Errorf("StructFields() failed: Unexpected index option on '%s'", field.Name)
+		}
+	}
+}
+
+func TestUnmarshal(t *testing.T) {
+	record := NewRecord()
+	record.ID = 3
+	record.AddField("name", "Mahinda")
+	record.AddField("age", int64(40))
+	record.AddField("score", int64(2))
+	record.AddField("tags", []interface{}{"x", "y"})
+	record.AddField("address", map[string]interface{}{"city": "Kandy", "zip": int64(20000)})
+
+	var value user
+	if err := Unmarshal(record, &value); err != nil {
+		t.Fatalf("Unmarshal() failed: %v", err)
+	}
+	expected := user{
+		ID:      3,
+		Name:    "Mahinda",
+		Age:     40,
+		Score:   2,
+		Tags:    []string{"x", "y"},
+		Address: &address{City: "Kandy", Zip: 20000},
+	}
+	if !reflect.DeepEqual(value, expected) {
+		t.Errorf("Unmarshal() failed: Expected %+v, got %+v", expected, value)
+	}
+
+	record.AddField("age", "forty")
+	err := Unmarshal(record, &value)
+	if err == nil || !strings.Contains(err.Error(), "field 'age': cannot assign string to uint8") {
+		t.Errorf("Unmarshal() failed: Expected type mismatch error, got %v", err)
+	}
+	record.AddField("age", int64(300))
+	if err := Unmarshal(record, &value); err == nil {
+		t.Errorf("Unmarshal() failed: Expected overflow error")
+	}
+}
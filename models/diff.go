@@ -0,0 +1,116 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+// The operations of a field change.
+const (
+	FieldAdded   = "added"
+	FieldRemoved = "removed"
+	FieldChanged = "changed"
+)
+
+// FieldChange describes a field which differs between two
+// versions of a record. Before is nil when the field was added
+// and After is nil when it was removed.
+type FieldChange struct {
+	Path   string      `json:"path"`
+	Op     string      `json:"op"`
+	Before interface{} `json:"before,omitempty"`
+	After  interface{} `json:"after,omitempty"`
+}
+
+// This function lists the fields which differ between two versions
+// of the fields of a record sorted by their paths. Nested documents
+// are compared field by field, arrays and other values as a whole.
+func Diff(before, after map[string]interface{}) []FieldChange {
+	changes := diffDocument(nil, "", before, after)
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Path < changes[j].Path })
+	return changes
+}
+
+func diffDocument(changes []FieldChange, path string, before, after map[string]interface{}) []FieldChange {
+	for key, old := range before {
+		childPath := joinKey(path, key)
+		value, ok := after[key]
+		if !ok {
+			changes = append(changes, FieldChange{Path: childPath, Op: FieldRemoved, Before: old})
+			continue
+		}
+		oldDocument, oldIsDocument := old.(map[string]interface{})
+		document, isDocument := value.(map[string]interface{})
+		if oldIsDocument && isDocument {
+			changes = diffDocument(changes, childPath, oldDocument, document)
+			continue
+		}
+		if !equalValues(old, value) {
+			changes = append(changes, FieldChange{Path: childPath, Op: FieldChanged, Before: old, After: value})
+		}
+	}
+	for key, value := range after {
+		if _, ok := before[key]; !ok {
+			changes = append(changes, FieldChange{Path: joinKey(path, key), Op: FieldAdded, After: value})
+		}
+	}
+	return changes
+}
+
+// This function compares two values by their encoding.
+func equalValues(a, b interface{}) bool {
+	encodedA, errA := EncodeValue(a)
+	encodedB, errB := EncodeValue(b)
+	if errA != nil || errB != nil {
+		return false
+	}
+	return string(encodedA) == string(encodedB)
+}
+
+// This function encodes the change keeping the kinds of its
+// values like the fields of a record.
+func (c FieldChange) MarshalJSON() ([]byte, error) {
+	aux := fieldChange{Path: c.Path, Op: c.Op}
+	var err error
+	if c.Before != nil {
+		if aux.Before, err = EncodeValue(c.Before); err != nil {
+			return nil, fmt.Errorf("field %s: %v", c.Path, err)
+		}
+	}
+	if c.After != nil {
+		if aux.After, err = EncodeValue(c.After); err != nil {
+			return nil, fmt.Errorf("field %s: %v", c.Path, err)
+		}
+	}
+	return json.Marshal(aux)
+}
+
+// This function decodes the change and the kinds of its values.
+func (c *FieldChange) UnmarshalJSON(data []byte) error {
+	var aux fieldChange
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+	*c = FieldChange{Path: aux.Path, Op: aux.Op}
+	var err error
+	if len(aux.Before) > 0 {
+		if c.Before, err = DecodeValue(aux.Before); err != nil {
+			return fmt.Errorf("field %s: %v", c.Path, err)
+		}
+	}
+	if len(aux.After) > 0 {
+		if c.After, err = DecodeValue(aux.After); err != nil {
+			return fmt.Errorf("field %s: %v", c.Path, err)
+		}
+	}
+	return nil
+}
+
+// fieldChange is the encoding of a FieldChange.
+type fieldChange struct {
+	Path   string          `json:"path"`
+	Op     string          `json:"op"`
+	Before json.RawMessage `json:"before,omitempty"`
+	After  json.RawMessage `json:"after,omitempty"`
+}
@@ -0,0 +1,45 @@
+package models
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	before := map[string]interface{}{
+		"name":    "Sajith",
+		"age":     int64(30),
+		"tags":    []interface{}{"a"},
+		"address": map[string]interface{}{"city": "Colombo", "zip": int64(10100)},
+	}
+	after := map[string]interface{}{
+		"name":    "Sajith",
+		"age":     30.0,
+		"email":   "sajith@example.com",
+		"tags":    []interface{}{"a"},
+		"address": map[string]interface{}{"city": "Kandy"},
+	}
+	expected := []FieldChange{
+		{Path: "address.city", Op: FieldChanged, Before: "Colombo", After: "Kandy"},
+		{Path: "address.zip", Op: FieldRemoved, Before: int64(10100)},
+		{Path: "age", Op: FieldChanged, Before: int64(30), After: 30.0},
+		{Path: "email", Op: FieldAdded, After: "sajith@example.com"},
+	}
+	changes := Diff(before, after)
+	if !reflect.DeepEqual(changes, expected) {
+		t.Errorf("Diff() failed: Expected %+v, got %+v", expected, changes)
+	}
+
+	data, err := json.Marshal(changes)
+	if err != nil {
+		t.Fatalf("Marshal() failed: %v", err)
+	}
+	var decoded []FieldChange
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("Unmarshal() failed: %v", err)
+	}
+	if !reflect.DeepEqual(decoded, expected) {
+		t.Errorf("Unmarshal() failed: Expected %+v, got %+v", expected, decoded)
+	}
+}
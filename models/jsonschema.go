@@ -0,0 +1,423 @@
+package models
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// The dialect written by JSONSchema.
+const JSON_SCHEMA_DIALECT = "https://json-schema.org/draft/2020-12/schema"
+
+// Keywords which only describe a schema and are skipped on import.
+var jsonSchemaAnnotations = map[string]bool{
+	"$schema": true, "$id": true, "$comment": true, "title": true,
+	"description": true, "examples": true, "deprecated": true,
+	"readOnly": true, "writeOnly": true,
+}
+
+// This function converts a JSON Schema document into a schema.
+//
+// The supported subset of draft 2020-12 is: type (a name or a list
+// of names with "null"), properties, required, additionalProperties
+// set to false, items, enum, const, default, minimum, maximum,
+// minLength, maxLength, minItems, maxItems and pattern. Strings with
+// the format "date-time" are times and strings with the
+// contentEncoding "base64" are bytes. Other keywords are rejected
+// so no rule is silently dropped.
+func ParseJSONSchema(data []byte) (*Schema, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var document map[string]interface{}
+	if err := decoder.Decode(&document); err != nil {
+		return nil, fmt.Errorf("error decoding JSON Schema: %v", err)
+	}
+
+	root, err := parseJSONSchema("", document)
+	if err != nil {
+		return nil, err
+	}
+	if root.Type != KindDocument && root.Type != KindAny {
+		return nil, fmt.Errorf("JSON Schema must describe an object, got %s", root.Type)
+	}
+	schema := &Schema{Fields: root.Fields, Strict: root.Strict}
+	if schema.Fields == nil {
+		schema.Fields = make(map[string]*FieldSchema)
+	}
+	if err := schema.Check(); err != nil {
+		return nil, err
+	}
+	return schema, nil
+}
+
+func parseJSONSchema(path string, document map[string]interface{}) (*FieldSchema, error) {
+	fail := func(format string, args ...interface{}) error {
+		if path == "" {
+			return fmt.Errorf(format, args...)
+		}
+		return fmt.Errorf("%s: %s", path, fmt.Sprintf(format, args...))
+	}
+
+	field := &FieldSchema{}
+	if err := parseJSONSchemaType(field, document); err != nil {
+		return nil, fail("%v", err)
+	}
+
+	var required []string
+	for _, keyword := range sortedKeys(document) {
+		value := document[keyword]
+		var err error
+		switch keyword {
+		case "type", "format", "contentEncoding":
+		case "properties":
+			properties, ok := value.(map[string]interface{})
+			if !ok {
+				return nil, fail("properties must be an object")
+			}
+			field.Fields = make(map[string]*FieldSchema, len(properties))
+			for _, name := range sortedKeys(properties) {
+				property, ok := properties[name].(map[string]interface{})
+				if !ok {
+					return nil, fail("property '%s' must be an object", name)
+				}
+				child, err := parseJSONSchema(joinKey(path, name), property)
+				if err != nil {
+					return nil, err
+				}
+				field.Fields[name] = child
+			}
+		case "required":
+			required, err = stringList(value)
+		case "additionalProperties":
+			allowed, ok := value.(bool)
+			if !ok {
+				return nil, fail("additionalProperties must be a boolean")
+			}
+			field.Strict = !allowed
+		case "items":
+			items, ok := value.(map[string]interface{})
+			if !ok {
+				return nil, fail("items must be an object")
+			}
+			field.Items, err = parseJSONSchema(path+"[]", items)
+			if err != nil {
+				return nil, err
+			}
+		case "enum":
+			values, ok := value.([]interface{})
+			if !ok {
+				return nil, fail("enum must be an array")
+			}
+			for _, value := range values {
+				converted, err := fromJSONValue(field.Type, value)
+				if err != nil {
+					return nil, fail("enum: %v", err)
+				}
+				field.Enum = append(field.Enum, converted)
+			}
+		case "const":
+			converted, err := fromJSONValue(field.Type, value)
+			if err != nil {
+				return nil, fail("const: %v", err)
+			}
+			field.Enum = []interface{}{converted}
+		case "default":
+			field.Default, err = fromJSONValue(field.Type, value)
+		case "minimum":
+			field.Min, err = jsonFloat(value)
+		case "maximum":
+			field.Max, err = jsonFloat(value)
+		case "minLength", "minItems":
+			field.MinLength, err = jsonInt(value)
+		case "maxLength", "maxItems":
+			field.MaxLength, err = jsonInt(value)
+		case "pattern":
+			pattern, ok := value.(string)
+			if !ok {
+				return nil, fail("pattern must be a string")
+			}
+			field.Pattern = pattern
+		default:
+			if !jsonSchemaAnnotations[keyword] {
+				return nil, fail("unsupported keyword '%s'", keyword)
+			}
+		}
+		if err != nil {
+			return nil, fail("%s: %v", keyword, err)
+		}
+	}
+
+	for _, name := range required {
+		child, ok := field.Fields[name]
+		if !ok {
+			child = &FieldSchema{}
+			if field.Fields == nil {
+				field.Fields = make(map[string]*FieldSchema)
+			}
+			field.Fields[name] = child
+		}
+		child.Required = true
+	}
+	return field, nil
+}
+
+// This function reads the type, format and contentEncoding
+// keywords into the kind of the field.
+func parseJSONSchemaType(field *FieldSchema, document map[string]interface{}) error {
+	var names []string
+	switch value := document["type"].(type) {
+	case nil:
+	case string:
+		names = []string{value}
+	case []interface{}:
+		var err error
+		if names, err = stringList(value); err != nil {
+			return fmt.Errorf("type: %v", err)
+		}
+	default:
+		return fmt.Errorf("type must be a string or an array")
+	}
+
+	var kinds []Kind
+	for _, name := range names {
+		switch name {
+		case "null":
+			field.Nullable = true
+			continue
+		case "string":
+			kinds = append(kinds, KindString)
+		case "integer":
+			kinds = append(kinds, KindInt)
+		case "number":
+			kinds = append(kinds, KindFloat)
+		case "boolean":
+			kinds = append(kinds, KindBool)
+		case "object":
+			kinds = append(kinds, KindDocument)
+		case "array":
+			kinds = append(kinds, KindArray)
+		default:
+			return fmt.Errorf("unknown type '%s'", name)
+		}
+	}
+	switch {
+	case len(kinds) > 1:
+		return fmt.Errorf("only one type besides null is supported")
+	case len(kinds) == 1:
+		field.Type = kinds[0]
+	case field.Nullable:
+		field.Type, field.Nullable = KindNull, false
+	}
+
+	format, _ := document["format"].(string)
+	encoding, _ := document["contentEncoding"].(string)
+	if field.Type == KindString && format == "date-time" {
+		field.Type = KindTime
+	}
+	if field.Type == KindString && encoding == "base64" {
+		field.Type = KindBytes
+	}
+	if encoding != "" && field.Type != KindBytes {
+		return fmt.Errorf("unsupported contentEncoding '%s'", encoding)
+	}
+	return nil
+}
+
+// This function converts a plain JSON value of a JSON Schema
+// into the kind of the field.
+func fromJSONValue(kind Kind, value interface{}) (interface{}, error) {
+	value, err := plainValue(value)
+	if err != nil {
+		return nil, err
+	}
+	switch v := value.(type) {
+	case string:
+		switch kind {
+		case KindTime:
+			return time.Parse(time.RFC3339Nano, v)
+		case KindBytes:
+			return base64.StdEncoding.DecodeString(v)
+		}
+	case int64:
+		if kind == KindFloat {
+			return float64(v), nil
+		}
+	}
+	return value, nil
+}
+
+// This function converts the numbers of a plain JSON value,
+// objects with "$" keys are not unwrapped.
+func plainValue(value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case json.Number:
+		return decodeNumber(v)
+	case []interface{}:
+		for i, item := range v {
+			converted, err := plainValue(item)
+			if err != nil {
+				return nil, err
+			}
+			v[i] = converted
+		}
+	case map[string]interface{}:
+		for key, item := range v {
+			converted, err := plainValue(item)
+			if err != nil {
+				return nil, err
+			}
+			v[key] = converted
+		}
+	}
+	return value, nil
+}
+
+// This function converts the schema into a JSON Schema document.
+// Every rule of the schema has a JSON Schema keyword, so
+// ParseJSONSchema gives the same schema back.
+func (s *Schema) JSONSchema() ([]byte, error) {
+	root := &FieldSchema{Type: KindDocument, Fields: s.Fields, Strict: s.Strict}
+	document, err := jsonSchemaOf(root)
+	if err != nil {
+		return nil, err
+	}
+	document["$schema"] = JSON_SCHEMA_DIALECT
+	return json.MarshalIndent(document, "", "  ")
+}
+
+func jsonSchemaOf(field *FieldSchema) (map[string]interface{}, error) {
+	document := make(map[string]interface{})
+	if field == nil {
+		return document, nil
+	}
+
+	var typeName string
+	switch field.Type {
+	case KindNull:
+		typeName = "null"
+	case KindBool:
+		typeName = "boolean"
+	case KindInt:
+		typeName = "integer"
+	case KindFloat:
+		typeName = "number"
+	case KindString:
+		typeName = "string"
+	case KindTime:
+		typeName, document["format"] = "string", "date-time"
+	case KindBytes:
+		typeName, document["contentEncoding"] = "string", "base64"
+	case KindArray:
+		typeName = "array"
+	case KindDocument:
+		typeName = "object"
+	}
+	switch {
+	case typeName != "" && field.Nullable && field.Type != KindNull:
+		document["type"] = []string{typeName, "null"}
+	case typeName != "":
+		document["type"] = typeName
+	}
+
+	if len(field.Enum) > 0 {
+		document["enum"] = field.Enum
+	}
+	if field.Default != nil {
+		document["default"] = field.Default
+	}
+	if field.Min != nil {
+		document["minimum"] = *field.Min
+	}
+	if field.Max != nil {
+		document["maximum"] = *field.Max
+	}
+	minLength, maxLength := "minLength", "maxLength"
+	if field.Type == KindArray {
+		minLength, maxLength = "minItems", "maxItems"
+	}
+	if field.MinLength != nil {
+		document[minLength] = *field.MinLength
+	}
+	if field.MaxLength != nil {
+		document[maxLength] = *field.MaxLength
+	}
+	if field.Pattern != "" {
+		document["pattern"] = field.Pattern
+	}
+
+	if field.Fields != nil {
+		properties := make(map[string]interface{}, len(field.Fields))
+		var required []string
+		for name, child := range field.Fields {
+			property, err := jsonSchemaOf(child)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", name, err)
+			}
+			properties[name] = property
+			if child != nil && child.Required {
+				required = append(required, name)
+			}
+		}
+		sort.Strings(required)
+		document["properties"] = properties
+		if len(required) > 0 {
+			document["required"] = required
+		}
+	}
+	if field.Strict {
+		document["additionalProperties"] = false
+	}
+	if field.Items != nil {
+		items, err := jsonSchemaOf(field.Items)
+		if err != nil {
+			return nil, fmt.Errorf("items: %v", err)
+		}
+		document["items"] = items
+	}
+	return document, nil
+}
+
+func stringList(value interface{}) ([]string, error) {
+	values, ok := value.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("must be an array of strings")
+	}
+	list := make([]string, len(values))
+	for i, value := range values {
+		text, ok := value.(string)
+		if !ok {
+			return nil, fmt.Errorf("must be an array of strings")
+		}
+		list[i] = text
+	}
+	return list, nil
+}
+
+func jsonFloat(value interface{}) (*float64, error) {
+	number, ok := value.(json.Number)
+	if !ok {
+		return nil, fmt.Errorf("must be a number")
+	}
+	f, err := number.Float64()
+	if err != nil {
+		return nil, err
+	}
+	return &f, nil
+}
+
+func jsonInt(value interface{}) (*int, error) {
+	number, ok := value.(json.Number)
+	if !ok || strings.ContainsAny(number.String(), ".eE") {
+		return nil, fmt.Errorf("must be an integer")
+	}
+	n, err := number.Int64()
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("must be a non-negative integer")
+	}
+	i := int(n)
+	return &i, nil
+}
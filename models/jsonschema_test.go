@@ -0,0 +1,63 @@
+package models
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseJSONSchema(t *testing.T) {
+	data := []byte(`{
+		"$schema": "https://json-schema.org/draft/2020-12/schema",
+		"title": "User",
+		"type": "object",
+		"additionalProperties": false,
+		"required": ["name", "created"],
+		"properties": {
+			"name": {"type": "string", "minLength": 1, "pattern": "^[a-z]+$"},
+			"score": {"type": ["number", "null"], "minimum": 0, "default": 1},
+			"created": {"type": "string", "format": "date-time", "enum": ["2024-03-01T00:00:00Z"]},
+			"avatar": {"type": "string", "contentEncoding": "base64"},
+			"tags": {"type": "array", "maxItems": 3, "items": {"type": "integer"}},
+			"address": {"type": "object", "properties": {"city": {"const": "Colombo"}}}
+		}
+	}`)
+	schema, err := ParseJSONSchema(data)
+	if err != nil {
+		t.Fatalf("ParseJSONSchema() failed: %v", err)
+	}
+	created := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	one, zero, three := 1.0, 0.0, 3
+	minLength := 1
+	expected := &Schema{Strict: true, Fields: map[string]*FieldSchema{
+		"name":    {Type: KindString, Required: true, MinLength: &minLength, Pattern: "^[a-z]+$"},
+		"score":   {Type: KindFloat, Nullable: true, Min: &zero, Default: one},
+		"created": {Type: KindTime, Required: true, Enum: []interface{}{created}},
+		"avatar":  {Type: KindBytes},
+		"tags":    {Type: KindArray, MaxLength: &three, Items: &FieldSchema{Type: KindInt}},
+		"address": {Type: KindDocument, Fields: map[string]*FieldSchema{
+			"city": {Enum: []interface{}{"Colombo"}},
+		}},
+	}}
+	if !reflect.DeepEqual(schema, expected) {
+		t.Errorf("ParseJSONSchema() failed: Expected %+v, got %+v", expected, schema)
+	}
+
+	exported, err := schema.JSONSchema()
+	if err != nil {
+		t.Fatalf("JSONSchema() failed: %v", err)
+	}
+	again, err := ParseJSONSchema(exported)
+	if err != nil {
+		t.Fatalf("ParseJSONSchema() failed: %v", err)
+	}
+	if !reflect.DeepEqual(again, schema) {
+		t.Errorf("JSONSchema() failed: Exported schema changed after import: %s", exported)
+	}
+
+	_, err = ParseJSONSchema([]byte(`{"type": "object", "properties": {"a": {"oneOf": []}}}`))
+	if err == nil || !strings.Contains(err.Error(), "a: unsupported keyword 'oneOf'") {
+		t.Errorf("ParseJSONSchema() failed: Expected unsupported keyword error, got %v", err)
+	}
+}
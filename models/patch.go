@@ -0,0 +1,182 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+// ErrOverflow is returned when $inc overflows the number of a field.
+var ErrOverflow = errors.New("number overflow")
+
+// The operators of a patch in the order they are applied.
+var patchOperators = []string{"$set", "$unset", "$inc", "$push", "$pull", "$addToSet", "$rename"}
+
+// Patch maps update operators to the paths of the fields they
+// change and their arguments, like {"$inc": {"views": 1}}.
+//
+//   - $set sets the field to the value.
+//   - $unset removes the field, the value is ignored.
+//   - $inc adds the number to the field, a missing field is set to it.
+//     A sum out of the range of its kind fails with ErrOverflow.
+//   - $push appends the value to the array, a missing field is created.
+//   - $pull removes every element of the array equal to the value.
+//   - $addToSet appends the value unless the array already holds it.
+//   - $rename moves the field to the path given as the value.
+//
+// The operators are applied in this order and the fields of an
+// operator in the order of their paths.
+type Patch map[string]map[string]interface{}
+
+// This function checks the operators and paths of the patch.
+func (p Patch) Check() error {
+	for operator, fields := range p {
+		if !isPatchOperator(operator) {
+			return fmt.Errorf("unknown patch operator '%s'", operator)
+		}
+		for path, value := range fields {
+			if _, err := ParsePath(path); err != nil {
+				return fmt.Errorf("%s: %v", operator, err)
+			}
+			if operator != "$rename" {
+				continue
+			}
+			target, ok := value.(string)
+			if !ok {
+				return fmt.Errorf("$rename: field '%s' must be renamed to a path", path)
+			}
+			if _, err := ParsePath(target); err != nil {
+				return fmt.Errorf("$rename: %v", err)
+			}
+		}
+	}
+	return nil
+}
+
+// This function applies the patch to the fields of the record.
+// The record may be partly changed when an error is returned,
+// patch a copy to keep the original.
+func (r *Record) Apply(patch Patch) error {
+	if err := patch.Check(); err != nil {
+		return err
+	}
+	if r.Fields == nil {
+		r.Fields = make(map[string]interface{})
+	}
+	for _, operator := range patchOperators {
+		fields := patch[operator]
+		for _, path := range sortedKeys(fields) {
+			if err := r.applyOperator(operator, path, fields[path]); err != nil {
+				return fmt.Errorf("%s: %w", operator, err)
+			}
+		}
+	}
+	return nil
+}
+
+func (r *Record) applyOperator(operator, path string, value interface{}) error {
+	elems, _ := ParsePath(path)
+	current, exists := lookupPath(r.Fields, elems)
+	if operator == "$unset" {
+		if exists {
+			return r.UnsetField(path)
+		}
+		return nil
+	}
+	if operator == "$rename" {
+		if !exists {
+			return nil
+		}
+		if err := r.UnsetField(path); err != nil {
+			return err
+		}
+		return r.SetField(value.(string), current)
+	}
+
+	value, err := Normalize(value)
+	if err != nil {
+		return fmt.Errorf("field '%s': %v", path, err)
+	}
+	switch operator {
+	case "$set":
+		return r.SetField(path, value)
+	case "$inc":
+		if !exists {
+			current = int64(0)
+		}
+		sum, err := addNumbers(current, value)
+		if err != nil {
+			return fmt.Errorf("field '%s': %w", path, err)
+		}
+		return r.SetField(path, sum)
+	}
+
+	var array []interface{}
+	if exists {
+		var ok bool
+		if array, ok = current.([]interface{}); !ok {
+			return fmt.Errorf("field '%s': expected array, got %s", path, kindName(current))
+		}
+	}
+	switch operator {
+	case "$push":
+		array = append(array[:len(array):len(array)], value)
+	case "$addToSet":
+		for _, item := range array {
+			if equalValues(item, value) {
+				return nil
+			}
+		}
+		array = append(array[:len(array):len(array)], value)
+	case "$pull":
+		if !exists {
+			return nil
+		}
+		kept := make([]interface{}, 0, len(array))
+		for _, item := range array {
+			if !equalValues(item, value) {
+				kept = append(kept, item)
+			}
+		}
+		array = kept
+	}
+	return r.SetField(path, array)
+}
+
+// This function adds two numbers. The sum of two integers is an
+// integer and fails with ErrOverflow when it doesn't fit in an
+// int64 instead of losing precision as a float, any other sum is
+// a float and fails when it is infinite.
+func addNumbers(a, b interface{}) (interface{}, error) {
+	x, xIsInt := a.(int64)
+	y, yIsInt := b.(int64)
+	if xIsInt && yIsInt {
+		sum := x + y
+		if (y > 0 && sum < x) || (y < 0 && sum > x) {
+			return nil, fmt.Errorf("integer %w", ErrOverflow)
+		}
+		return sum, nil
+	}
+	f, ok := toFloat(a)
+	if !ok {
+		return nil, fmt.Errorf("cannot increment %s", kindName(a))
+	}
+	g, ok := toFloat(b)
+	if !ok {
+		return nil, fmt.Errorf("cannot increment by %s", kindName(b))
+	}
+	sum := f + g
+	if math.IsInf(sum, 0) && !math.IsInf(f, 0) && !math.IsInf(g, 0) {
+		return nil, fmt.Errorf("float %w", ErrOverflow)
+	}
+	return sum, nil
+}
+
+func isPatchOperator(operator string) bool {
+	for _, known := range patchOperators {
+		if known == operator {
+			return true
+		}
+	}
+	return false
+}
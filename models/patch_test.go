@@ -0,0 +1,65 @@
+package models
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestRecord_Apply(t *testing.T) {
+	record := NewRecord()
+	record.AddField("name", "Sajith")
+	record.AddField("views", 1)
+	record.AddField("score", 1.5)
+	record.AddField("tags", []interface{}{"a", "b", "a"})
+	record.AddField("address", map[string]interface{}{"city": "Colombo"})
+	if err := record.Normalize(); err != nil {
+		t.Fatalf("Normalize() failed: %v", err)
+	}
+
+	err := record.Apply(Patch{
+		"$set":      {"address.zip": 10100},
+		"$unset":    {"name": nil, "missing": nil},
+		"$inc":      {"views": 2, "score": 1, "likes": 1},
+		"$push":     {"history": "created"},
+		"$pull":     {"tags": "a"},
+		"$addToSet": {"tags": "b"},
+		"$rename":   {"address.city": "city"},
+	})
+	if err != nil {
+		t.Fatalf("Apply() failed: %v", err)
+	}
+	expected := map[string]interface{}{
+		"views":   int64(3),
+		"score":   2.5,
+		"likes":   int64(1),
+		"tags":    []interface{}{"b"},
+		"history": []interface{}{"created"},
+		"address": map[string]interface{}{"zip": int64(10100)},
+		"city":    "Colombo",
+	}
+	if !reflect.DeepEqual(record.Fields, expected) {
+		t.Errorf("Apply() failed: Expected %#v, got %#v", expected, record.Fields)
+	}
+}
+
+func TestRecord_ApplyErrors(t *testing.T) {
+	tests := []Patch{
+		{"$replace": {"name": "Anura"}},
+		{"$set": {"a..b": 1}},
+		{"$rename": {"name": 1}},
+		{"$inc": {"name": 1}},
+		{"$inc": {"views": "one"}},
+		{"$inc": {"big": 1}},
+		{"$push": {"name": "Anura"}},
+	}
+	for _, patch := range tests {
+		record := NewRecord()
+		record.AddField("name", "Sajith")
+		record.AddField("views", int64(1))
+		record.AddField("big", int64(math.MaxInt64))
+		if err := record.Apply(patch); err == nil {
+			t.Errorf("Apply() failed: Expected an error for %v", patch)
+		}
+	}
+}
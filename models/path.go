@@ -0,0 +1,210 @@
+package models
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// PathElem is a step of a field path, either the key of a
+// document or the index of an array.
+type PathElem struct {
+	Key   string
+	Index int
+	IsKey bool
+}
+
+// This function parses a field path such as "address.city",
+// "tags[0]" or "orders[2].items[0].name". Keys which hold dots
+// or brackets can be quoted: `labels["app.kubernetes.io"]`.
+func ParsePath(path string) ([]PathElem, error) {
+	var elems []PathElem
+	i := 0
+	expectKey := true
+	for i < len(path) {
+		switch {
+		case path[i] == '[':
+			// A quoted key may hold a ], the bracket is after its
+			// closing quote.
+			start := 1
+			if strings.HasPrefix(path[i+1:], `"`) {
+				start = closingQuote(path[i+1:]) + 2
+				if start < 2 {
+					return nil, fmt.Errorf("invalid path '%s': missing closing quote", path)
+				}
+			}
+			end := strings.IndexByte(path[i+start:], ']')
+			if end < 0 {
+				return nil, fmt.Errorf("invalid path '%s': missing ]", path)
+			}
+			end += start
+			inner := path[i+1 : i+end]
+			if strings.HasPrefix(inner, `"`) {
+				key, err := strconv.Unquote(inner)
+				if err != nil {
+					return nil, fmt.Errorf("invalid path '%s': bad key %s", path, inner)
+				}
+				elems = append(elems, PathElem{Key: key, IsKey: true})
+			} else {
+				index, err := strconv.Atoi(inner)
+				if err != nil || index < 0 {
+					return nil, fmt.Errorf("invalid path '%s': bad index [%s]", path, inner)
+				}
+				elems = append(elems, PathElem{Index: index})
+			}
+			i += end + 1
+			expectKey = false
+		case path[i] == '.':
+			if expectKey {
+				return nil, fmt.Errorf("invalid path '%s': empty key", path)
+			}
+			i++
+			expectKey = true
+			if i == len(path) {
+				return nil, fmt.Errorf("invalid path '%s': empty key", path)
+			}
+		default:
+			if !expectKey {
+				return nil, fmt.Errorf("invalid path '%s': expected . or [", path)
+			}
+			end := strings.IndexAny(path[i:], ".[")
+			if end < 0 {
+				end = len(path) - i
+			}
+			elems = append(elems, PathElem{Key: path[i : i+end], IsKey: true})
+			i += end
+			expectKey = false
+		}
+	}
+	if len(elems) == 0 {
+		return nil, fmt.Errorf("invalid path '%s': empty key", path)
+	}
+	return elems, nil
+}
+
+// This function gets the index of the quote closing the quoted
+// string the text starts with, skipping escaped quotes. It is -1
+// when the string is not closed.
+func closingQuote(text string) int {
+	for i := 1; i < len(text); i++ {
+		switch text[i] {
+		case '\\':
+			i++
+		case '"':
+			return i
+		}
+	}
+	return -1
+}
+
+// This function gets the value at the path of a document.
+func lookupPath(document map[string]interface{}, elems []PathElem) (interface{}, bool) {
+	var value interface{} = document
+	for _, elem := range elems {
+		switch container := value.(type) {
+		case map[string]interface{}:
+			if !elem.IsKey {
+				return nil, false
+			}
+			item, ok := container[elem.Key]
+			if !ok {
+				return nil, false
+			}
+			value = item
+		case []interface{}:
+			if elem.IsKey || elem.Index >= len(container) {
+				return nil, false
+			}
+			value = container[elem.Index]
+		default:
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+// This function sets the value at the path below the node and
+// returns the node, which is a new one when an array grows or
+// a missing document is created. An array index can point one
+// past the end to append.
+func setPath(node interface{}, elems []PathElem, value interface{}) (interface{}, error) {
+	elem, rest := elems[0], elems[1:]
+	if elem.IsKey {
+		document, ok := node.(map[string]interface{})
+		if node == nil {
+			document = make(map[string]interface{})
+		} else if !ok {
+			return nil, fmt.Errorf("cannot set key '%s' of %s", elem.Key, kindName(node))
+		}
+		if len(rest) == 0 {
+			document[elem.Key] = value
+			return document, nil
+		}
+		child, err := setPath(document[elem.Key], rest, value)
+		if err != nil {
+			return nil, err
+		}
+		document[elem.Key] = child
+		return document, nil
+	}
+
+	array, ok := node.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("cannot set index %d of %s", elem.Index, kindName(node))
+	}
+	if elem.Index > len(array) {
+		return nil, fmt.Errorf("index %d out of range", elem.Index)
+	}
+	if elem.Index == len(array) {
+		array = append(array, nil)
+	}
+	if len(rest) == 0 {
+		array[elem.Index] = value
+		return array, nil
+	}
+	child, err := setPath(array[elem.Index], rest, value)
+	if err != nil {
+		return nil, err
+	}
+	array[elem.Index] = child
+	return array, nil
+}
+
+// This function removes the value at the path below the node and
+// returns the node, array elements after a removed one move down.
+// Reports false when nothing is at the path.
+func unsetPath(node interface{}, elems []PathElem) (interface{}, bool) {
+	elem, rest := elems[0], elems[1:]
+	switch container := node.(type) {
+	case map[string]interface{}:
+		child, ok := container[elem.Key]
+		if !elem.IsKey || !ok {
+			return node, false
+		}
+		if len(rest) == 0 {
+			delete(container, elem.Key)
+			return container, true
+		}
+		child, ok = unsetPath(child, rest)
+		container[elem.Key] = child
+		return container, ok
+	case []interface{}:
+		if elem.IsKey || elem.Index >= len(container) {
+			return node, false
+		}
+		if len(rest) == 0 {
+			return append(container[:elem.Index], container[elem.Index+1:]...), true
+		}
+		child, ok := unsetPath(container[elem.Index], rest)
+		container[elem.Index] = child
+		return container, ok
+	}
+	return node, false
+}
+
+func kindName(value interface{}) string {
+	if kind, err := KindOf(value); err == nil {
+		return kind.String()
+	}
+	return fmt.Sprintf("%T", value)
+}
@@ -0,0 +1,113 @@
+package models
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParsePath(t *testing.T) {
+	elems, err := ParsePath(`orders[2].items[0]["app.name"]`)
+	if err != nil {
+		t.Fatalf("ParsePath() failed: %v", err)
+	}
+	expected := []PathElem{
+		{Key: "orders", IsKey: true},
+		{Index: 2},
+		{Key: "items", IsKey: true},
+		{Index: 0},
+		{Key: "app.name", IsKey: true},
+	}
+	if !reflect.DeepEqual(elems, expected) {
+		t.Errorf("ParsePath() failed: Expected %+v, got %+v", expected, elems)
+	}
+
+	elems, err = ParsePath(`a["x]y"]["q\"]"]`)
+	expected = []PathElem{
+		{Key: "a", IsKey: true},
+		{Key: "x]y", IsKey: true},
+		{Key: `q"]`, IsKey: true},
+	}
+	if err != nil || !reflect.DeepEqual(elems, expected) {
+		t.Errorf("ParsePath() failed: Expected %+v, got %+v, %v", expected, elems, err)
+	}
+
+	for _, path := range []string{"", "a.", ".a", "a..b", "a[", "a[-1]", "a[0]b", `a["x]`, `a["x"`} {
+		if _, err := ParsePath(path); err == nil {
+			t.Errorf("ParsePath() failed: Expected error for '%s'", path)
+		}
+	}
+}
+
+func TestRecord_SetField(t *testing.T) {
+	record := NewRecord()
+	record.AddField("tags", []interface{}{"a", "b"})
+	record.AddField("address_city", "Colombo")
+
+	if err := record.SetField("address.city", "Kandy"); err != nil {
+		t.Fatalf("SetField() failed: %v", err)
+	}
+	if err := record.SetField("tags[2]", "c"); err != nil {
+		t.Fatalf("SetField() failed: %v", err)
+	}
+	if err := record.SetField("tags[0]", 5); err != nil {
+		t.Fatalf("SetField() failed: %v", err)
+	}
+	if err := record.SetField("tags[9]", "z"); err == nil {
+		t.Errorf("SetField() failed: Expected error for index out of range")
+	}
+	if err := record.SetField("address.city.name", "x"); err == nil {
+		t.Errorf("SetField() failed: Expected error for setting a key of a string")
+	}
+
+	if city, _ := record.GetField("address.city"); city != "Kandy" {
+		t.Errorf("GetField() failed: Expected city 'Kandy', got %v", city)
+	}
+	if tag, _ := record.GetField("tags[0]"); tag != int64(5) {
+		t.Errorf("GetField() failed: Expected tag 5, got %v", tag)
+	}
+	if city, _ := record.GetField("address_city"); city != "Colombo" {
+		t.Errorf("GetField() failed: Expected top level field, got %v", city)
+	}
+
+	if err := record.UnsetField("tags[1]"); err != nil {
+		t.Fatalf("UnsetField() failed: %v", err)
+	}
+	if err := record.UnsetField("address.city"); err != nil {
+		t.Fatalf("UnsetField() failed: %v", err)
+	}
+	if err := record.UnsetField("address.zip"); err == nil {
+		t.Errorf("UnsetField() failed: Expected error for missing field")
+	}
+	expected := map[string]interface{}{
+		"tags":         []interface{}{int64(5), "c"},
+		"address":      map[string]interface{}{},
+		"address_city": "Colombo",
+	}
+	if !reflect.DeepEqual(record.Fields, expected) {
+		t.Errorf("UnsetField() failed: Expected %#v, got %#v", expected, record.Fields)
+	}
+}
+
+func TestRecord_Copy(t *testing.T) {
+	record := NewRecord()
+	record.ID = 4
+	record.AddField("tags", []interface{}{"a", "b"})
+	record.AddField("address", map[string]interface{}{"city": "Colombo"})
+
+	copied := record.Copy()
+	if err := copied.SetField("address.city", "Kandy"); err != nil {
+		t.Fatalf("SetField() failed: %v", err)
+	}
+	if err := copied.SetField("tags[0]", "c"); err != nil {
+		t.Fatalf("SetField() failed: %v", err)
+	}
+	if city, _ := record.GetField("address.city"); city != "Colombo" {
+		t.Errorf("Copy() failed: Changing the copy changed the nested document, got %v", city)
+	}
+	if tag, _ := record.GetField("tags[0]"); tag != "a" {
+		t.Errorf("Copy() failed: Changing the copy changed the array, got %v", tag)
+	}
+	if copied.ID != 4 {
+		t.Errorf("Copy() failed: Expected ID 4, got %d", copied.ID)
+	}
+}
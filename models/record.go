@@ -6,16 +6,41 @@ package models
 
 import (
 	"fmt"
-	"reflect"
 	"time"
 )
 
+// Names of the fields holding the key and timestamps of a
+// record, they can be read with GetField like ordinary fields.
+const (
+	KEY_FIELD        = "_key"
+	CREATED_AT_FIELD = "_createdAt"
+	UPDATED_AT_FIELD = "_updatedAt"
+	EXPIRE_AT_FIELD  = "_expireAt"
+	DELETED_AT_FIELD = "_deletedAt"
+)
+
 // Record represents a record with customizable fields.
+// ID is the number of the record inside its collection and
+// Key its primary key when the collection uses keys.
+// SchemaVersion is the version of the migrations the
+// fields were last upgraded to. CreatedAt and UpdatedAt
+// are set by the collection when the record is saved.
+// ExpireAt is the time the record is deleted at, a zero
+// ExpireAt never expires. DeletedAt is the time a collection
+// with soft deletes moved the record to its trash. ExpiresAt
+// is when the cached copy is evicted from the memory and is
+// not saved.
 type Record struct {
-	ID        int                    `json:"id"`
-	Fields    map[string]interface{} `json:"fields"`
-	ExpiresAt time.Time              `json:"-"`
-	Flushed   bool                   `json:"-"`
+	ID            int                    `json:"id"`
+	Key           string                 `json:"key,omitempty"`
+	Fields        map[string]interface{} `json:"fields"`
+	SchemaVersion int                    `json:"schemaVersion,omitempty"`
+	CreatedAt     time.Time              `json:"createdAt"`
+	UpdatedAt     time.Time              `json:"updatedAt"`
+	ExpireAt      time.Time              `json:"expireAt"`
+	DeletedAt     time.Time              `json:"deletedAt"`
+	ExpiresAt     time.Time              `json:"-"`
+	Flushed       bool                   `json:"-"`
 }
 
 // This function creates a new record.
@@ -34,25 +59,115 @@ func (r *Record) AddField(name string, value interface{}) {
 }
 
 // This function retrieves a field from the record.
+// The name can be a path into nested documents and arrays
+// such as "address.city" or "tags[0]". A top level field
+// named exactly like the path is preferred.
+// KEY_FIELD gives the key and CREATED_AT_FIELD,
+// UPDATED_AT_FIELD, EXPIRE_AT_FIELD and DELETED_AT_FIELD
+// the timestamps.
 func (r *Record) GetField(name string) (interface{}, error) {
-	value, ok := r.Fields[name]
+	if value, ok := r.Fields[name]; ok {
+		return value, nil
+	}
+	switch {
+	case name == KEY_FIELD && r.Key != "":
+		return r.Key, nil
+	case name == CREATED_AT_FIELD && !r.CreatedAt.IsZero():
+		return r.CreatedAt, nil
+	case name == UPDATED_AT_FIELD && !r.UpdatedAt.IsZero():
+		return r.UpdatedAt, nil
+	case name == EXPIRE_AT_FIELD && !r.ExpireAt.IsZero():
+		return r.ExpireAt, nil
+	case name == DELETED_AT_FIELD && !r.DeletedAt.IsZero():
+		return r.DeletedAt, nil
+	}
+	elems, err := ParsePath(name)
+	if err != nil {
+		return nil, err
+	}
+	value, ok := lookupPath(r.Fields, elems)
 	if !ok {
 		return nil, fmt.Errorf("field '%s' does not exist", name)
 	}
 	return value, nil
 }
 
+// This function checks if the record has expired at the time.
+func (r *Record) Expired(now time.Time) bool {
+	return !r.ExpireAt.IsZero() && !now.Before(r.ExpireAt)
+}
+
+// This function checks if the record is in the trash of a
+// collection with soft deletes.
+func (r *Record) Deleted() bool {
+	return !r.DeletedAt.IsZero()
+}
+
+// This function checks if the record is hidden from reads at
+// the time because it is deleted or has expired.
+func (r *Record) Hidden(now time.Time) bool {
+	return r.Deleted() || r.Expired(now)
+}
+
+// This function sets the field at the path creating the
+// documents on the way. An array index one past the end
+// appends to the array.
+func (r *Record) SetField(path string, value interface{}) error {
+	elems, err := ParsePath(path)
+	if err != nil {
+		return err
+	}
+	normalized, err := Normalize(value)
+	if err != nil {
+		return fmt.Errorf("field '%s': %v", path, err)
+	}
+	if r.Fields == nil {
+		r.Fields = make(map[string]interface{})
+	}
+	if _, err := setPath(r.Fields, elems, normalized); err != nil {
+		return fmt.Errorf("field '%s': %v", path, err)
+	}
+	return nil
+}
+
+// This function removes the field at the path.
+// Removing an array element moves the following ones down.
+// Returns an error when the field does not exist.
+func (r *Record) UnsetField(path string) error {
+	elems, err := ParsePath(path)
+	if err != nil {
+		return err
+	}
+	if _, ok := unsetPath(r.Fields, elems); !ok {
+		return fmt.Errorf("field '%s' does not exist", path)
+	}
+	return nil
+}
+
 // This function checks if the record has all required fields
-// with specified types.
+// with specified types. The types are kind names such as "int",
+// "float" or "document", see SchemaFromTypes. Use Schema for
+// optional fields, nested documents and constraints.
 func (r *Record) Validate(schema map[string]string) error {
-	for fieldName, expectedType := range schema {
-		value, ok := r.Fields[fieldName]
-		if !ok {
-			return fmt.Errorf("required field '%s' is missing", fieldName)
-		}
-		if reflect.TypeOf(value).Kind().String() != expectedType {
-			return fmt.Errorf("field '%s' has incorrect type, expected %s", fieldName, expectedType)
-		}
+	s, err := SchemaFromTypes(schema)
+	if err != nil {
+		return err
 	}
-	return nil
+	return s.Validate(r)
+}
+
+// This function maps the names of Go types to the names of
+// the kinds they are kept as.
+func kindFamily(name string) string {
+	switch name {
+	case "int", "int8", "int16", "int32", "int64", "uint", "uint8", "uint16", "uint32", "uint64":
+		return "int"
+	case "float32", "float64":
+		return "float"
+	case "slice":
+		return "array"
+	case "map":
+		return "document"
+	}
+	return name
 }
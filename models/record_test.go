@@ -1,7 +1,9 @@
 package models
 
 import (
+	"encoding/json"
 	"testing"
+	"time"
 )
 
 func TestRecord_GetField(t *testing.T) {
@@ -36,3 +38,60 @@ func TestRecord_Validate(t *testing.T) {
 		t.Errorf("Validate() failed: Validation error: %v", err)
 	}
 }
+
+func TestRecord_Expired(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	record := NewRecord()
+	if record.Expired(now) {
+		t.Errorf("Expired() failed: Record without an expiry has expired")
+	}
+	record.ExpireAt = now.Add(time.Minute)
+	if record.Expired(now) {
+		t.Errorf("Expired() failed: Record expired before its expiry")
+	}
+	if !record.Expired(now.Add(time.Minute)) {
+		t.Errorf("Expired() failed: Record not expired at its expiry")
+	}
+
+	data, err := json.Marshal(record)
+	if err != nil {
+		t.Fatalf("MarshalJSON() failed: %v", err)
+	}
+	var decoded Record
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("UnmarshalJSON() failed: %v", err)
+	}
+	if !decoded.ExpireAt.Equal(record.ExpireAt) {
+		t.Errorf("UnmarshalJSON() failed: Expected expiry %v, got %v", record.ExpireAt, decoded.ExpireAt)
+	}
+	if value, err := decoded.GetField(EXPIRE_AT_FIELD); err != nil || value != record.ExpireAt {
+		t.Errorf("GetField() failed: Expected expiry %v, got %v", record.ExpireAt, value)
+	}
+}
+
+func TestRecord_Deleted(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	record := NewRecord()
+	if record.Deleted() || record.Hidden(now) {
+		t.Errorf("Deleted() failed: Record without a deletion is deleted")
+	}
+	record.DeletedAt = now
+	if !record.Deleted() || !record.Hidden(now) {
+		t.Errorf("Deleted() failed: Record not deleted")
+	}
+
+	data, err := json.Marshal(record)
+	if err != nil {
+		t.Fatalf("MarshalJSON() failed: %v", err)
+	}
+	var decoded Record
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("UnmarshalJSON() failed: %v", err)
+	}
+	if !decoded.DeletedAt.Equal(record.DeletedAt) {
+		t.Errorf("UnmarshalJSON() failed: Expected deletion %v, got %v", record.DeletedAt, decoded.DeletedAt)
+	}
+	if value, err := decoded.GetField(DELETED_AT_FIELD); err != nil || value != record.DeletedAt {
+		t.Errorf("GetField() failed: Expected deletion %v, got %v", record.DeletedAt, value)
+	}
+}
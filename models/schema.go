@@ -0,0 +1,412 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// Schema describes the fields a record is expected to hold.
+// Fields not in the schema are accepted unless Strict is set.
+type Schema struct {
+	Fields map[string]*FieldSchema `json:"fields"`
+	Strict bool                    `json:"strict,omitempty"`
+}
+
+// FieldSchema describes the value of a field.
+//
+// Min and Max bound numbers, MinLength and MaxLength bound the
+// length of strings, bytes and arrays. Fields describes the fields
+// of a document and Items the elements of an array. A field whose
+// Type is KindAny accepts every kind.
+type FieldSchema struct {
+	Type      Kind                    `json:"type,omitempty"`
+	Required  bool                    `json:"required,omitempty"`
+	Nullable  bool                    `json:"nullable,omitempty"`
+	Enum      []interface{}           `json:"enum,omitempty"`
+	Min       *float64                `json:"min,omitempty"`
+	Max       *float64                `json:"max,omitempty"`
+	MinLength *int                    `json:"minLength,omitempty"`
+	MaxLength *int                    `json:"maxLength,omitempty"`
+	Pattern   string                  `json:"pattern,omitempty"`
+	Default   interface{}             `json:"default,omitempty"`
+	Fields    map[string]*FieldSchema `json:"fields,omitempty"`
+	Strict    bool                    `json:"strict,omitempty"`
+	Items     *FieldSchema            `json:"items,omitempty"`
+}
+
+// ValidationError describes a value violating the schema.
+type ValidationError struct {
+	Path    string
+	Message string
+}
+
+func (e ValidationError) Error() string {
+	return fmt.Sprintf("field '%s' %s", e.Path, e.Message)
+}
+
+// ValidationErrors holds every violation found in a record.
+type ValidationErrors []ValidationError
+
+func (e ValidationErrors) Error() string {
+	messages := make([]string, len(e))
+	for i, err := range e {
+		messages[i] = err.Error()
+	}
+	return strings.Join(messages, "; ")
+}
+
+// Compiled patterns of the schemas keyed by their text.
+var patterns sync.Map // map[string]*regexp.Regexp
+
+// This function checks the schema itself is valid: patterns
+// compile, bounds are ordered and kinds with children are the
+// only ones having them.
+func (s *Schema) Check() error {
+	var errs ValidationErrors
+	for _, name := range sortedKeys(s.Fields) {
+		errs = checkFieldSchema(errs, joinKey("", name), s.Fields[name])
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func checkFieldSchema(errs ValidationErrors, path string, field *FieldSchema) ValidationErrors {
+	fail := func(format string, args ...interface{}) {
+		errs = append(errs, ValidationError{path, fmt.Sprintf(format, args...)})
+	}
+	if field == nil {
+		fail("has no schema")
+		return errs
+	}
+	if field.Pattern != "" {
+		if _, err := compilePattern(field.Pattern); err != nil {
+			fail("has an invalid pattern: %v", err)
+		}
+	}
+	if field.Min != nil && field.Max != nil && *field.Min > *field.Max {
+		fail("has min greater than max")
+	}
+	if field.MinLength != nil && field.MaxLength != nil && *field.MinLength > *field.MaxLength {
+		fail("has minLength greater than maxLength")
+	}
+	if field.Fields != nil && field.Type != KindDocument && field.Type != KindAny {
+		fail("has fields but is of type %s", field.Type)
+	}
+	if field.Items != nil && field.Type != KindArray && field.Type != KindAny {
+		fail("has items but is of type %s", field.Type)
+	}
+	for _, name := range sortedKeys(field.Fields) {
+		errs = checkFieldSchema(errs, joinKey(path, name), field.Fields[name])
+	}
+	if field.Items != nil {
+		errs = checkFieldSchema(errs, path+"[]", field.Items)
+	}
+	return errs
+}
+
+// This function checks the record against the schema.
+// Every violation is reported, the error is a ValidationErrors
+// sorted by the paths of the fields.
+func (s *Schema) Validate(record *Record) error {
+	errs := validateDocument(nil, "", record.Fields, s.Fields, s.Strict)
+	sort.SliceStable(errs, func(i, j int) bool { return errs[i].Path < errs[j].Path })
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func validateDocument(errs ValidationErrors, path string, document map[string]interface{}, fields map[string]*FieldSchema, strict bool) ValidationErrors {
+	for _, name := range sortedKeys(fields) {
+		field, childPath := fields[name], joinKey(path, name)
+		value, ok := document[name]
+		if !ok {
+			if field != nil && field.Required {
+				errs = append(errs, ValidationError{childPath, "is required"})
+			}
+			continue
+		}
+		errs = validateValue(errs, childPath, value, field)
+	}
+	if strict {
+		for _, name := range sortedKeys(document) {
+			if _, ok := fields[name]; !ok {
+				errs = append(errs, ValidationError{joinKey(path, name), "is not in the schema"})
+			}
+		}
+	}
+	return errs
+}
+
+func validateValue(errs ValidationErrors, path string, value interface{}, field *FieldSchema) ValidationErrors {
+	if field == nil {
+		return errs
+	}
+	fail := func(format string, args ...interface{}) {
+		errs = append(errs, ValidationError{path, fmt.Sprintf(format, args...)})
+	}
+
+	kind, err := KindOf(value)
+	if err != nil {
+		normalized, nerr := Normalize(value)
+		if nerr != nil {
+			fail("has %v", err)
+			return errs
+		}
+		value = normalized
+		kind, _ = KindOf(value)
+	}
+	if kind == KindNull {
+		if !field.Nullable && field.Type != KindAny && field.Type != KindNull {
+			fail("must not be null")
+		}
+		return errs
+	}
+	if field.Type != KindAny && kind != field.Type && !(field.Type == KindFloat && kind == KindInt) {
+		fail("must be %s, got %s", field.Type, kind)
+		return errs
+	}
+
+	if len(field.Enum) > 0 && !inEnum(value, field.Enum) {
+		fail("must be one of %s", formatEnum(field.Enum))
+	}
+
+	var number *float64
+	switch v := value.(type) {
+	case int64:
+		f := float64(v)
+		number = &f
+	case float64:
+		number = &v
+	}
+	if number != nil {
+		if field.Min != nil && *number < *field.Min {
+			fail("must be at least %v", *field.Min)
+		}
+		if field.Max != nil && *number > *field.Max {
+			fail("must be at most %v", *field.Max)
+		}
+	}
+
+	length := -1
+	switch v := value.(type) {
+	case string:
+		length = utf8.RuneCountInString(v)
+		if field.Pattern != "" {
+			if pattern, err := compilePattern(field.Pattern); err != nil {
+				fail("has an invalid pattern in the schema: %v", err)
+			} else if !pattern.MatchString(v) {
+				fail("must match the pattern %s", field.Pattern)
+			}
+		}
+	case []byte:
+		length = len(v)
+	case []interface{}:
+		length = len(v)
+		if field.Items != nil {
+			for i, item := range v {
+				errs = validateValue(errs, path+"["+strconv.Itoa(i)+"]", item, field.Items)
+			}
+		}
+	case map[string]interface{}:
+		if field.Fields != nil {
+			errs = validateDocument(errs, path, v, field.Fields, field.Strict)
+		}
+	}
+	if length >= 0 {
+		if field.MinLength != nil && length < *field.MinLength {
+			fail("must have a length of at least %d", *field.MinLength)
+		}
+		if field.MaxLength != nil && length > *field.MaxLength {
+			fail("must have a length of at most %d", *field.MaxLength)
+		}
+	}
+	return errs
+}
+
+// This function sets the default values of the missing fields,
+// nested documents with defaults are filled when they exist.
+func (s *Schema) ApplyDefaults(record *Record) {
+	if record.Fields == nil {
+		record.Fields = make(map[string]interface{})
+	}
+	applyDefaults(record.Fields, s.Fields)
+}
+
+func applyDefaults(document map[string]interface{}, fields map[string]*FieldSchema) {
+	for name, field := range fields {
+		if field == nil {
+			continue
+		}
+		value, ok := document[name]
+		if !ok && field.Default != nil {
+			if normalized, err := Normalize(field.Default); err == nil {
+				value, ok = CopyValue(normalized), true
+				document[name] = value
+			}
+		}
+		if child, isDocument := value.(map[string]interface{}); ok && isDocument {
+			applyDefaults(child, field.Fields)
+		}
+	}
+}
+
+// This function encodes the field schema keeping the kinds of
+// its default and enum values.
+func (f FieldSchema) MarshalJSON() ([]byte, error) {
+	type fieldSchema FieldSchema
+	aux := struct {
+		fieldSchema
+		Enum    []json.RawMessage `json:"enum,omitempty"`
+		Default json.RawMessage   `json:"default,omitempty"`
+	}{fieldSchema: fieldSchema(f)}
+	for _, value := range f.Enum {
+		data, err := EncodeValue(value)
+		if err != nil {
+			return nil, fmt.Errorf("enum: %v", err)
+		}
+		aux.Enum = append(aux.Enum, data)
+	}
+	if f.Default != nil {
+		data, err := EncodeValue(f.Default)
+		if err != nil {
+			return nil, fmt.Errorf("default: %v", err)
+		}
+		aux.Default = data
+	}
+	return json.Marshal(aux)
+}
+
+// This function decodes the field schema and the kinds of its
+// default and enum values.
+func (f *FieldSchema) UnmarshalJSON(data []byte) error {
+	type fieldSchema FieldSchema
+	aux := struct {
+		*fieldSchema
+		Enum    []json.RawMessage `json:"enum"`
+		Default json.RawMessage   `json:"default"`
+	}{fieldSchema: (*fieldSchema)(f)}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+	f.Enum = nil
+	for _, raw := range aux.Enum {
+		value, err := DecodeValue(raw)
+		if err != nil {
+			return fmt.Errorf("enum: %v", err)
+		}
+		f.Enum = append(f.Enum, value)
+	}
+	f.Default = nil
+	if len(aux.Default) > 0 {
+		value, err := DecodeValue(aux.Default)
+		if err != nil {
+			return fmt.Errorf("default: %v", err)
+		}
+		f.Default = value
+	}
+	return nil
+}
+
+// This function builds a schema from a map of field names to the
+// names of their types, which is what Record.Validate takes.
+// Every field is required. Sized integer and float type names and
+// the reflect names "slice" and "map" are accepted.
+func SchemaFromTypes(types map[string]string) (*Schema, error) {
+	schema := &Schema{Fields: make(map[string]*FieldSchema, len(types))}
+	for name, typeName := range types {
+		kind, err := ParseKind(kindFamily(typeName))
+		if err != nil {
+			return nil, fmt.Errorf("field '%s': %v", name, err)
+		}
+		schema.Fields[name] = &FieldSchema{Type: kind, Required: true}
+	}
+	return schema, nil
+}
+
+// This function tells if the value is one of the enum values.
+// Integers and floats holding the same number are equal.
+func inEnum(value interface{}, enum []interface{}) bool {
+	data, err := EncodeValue(value)
+	if err != nil {
+		return false
+	}
+	number, isNumber := toFloat(value)
+	for _, allowed := range enum {
+		if other, ok := toFloat(allowed); ok && isNumber {
+			if number == other {
+				return true
+			}
+			continue
+		}
+		if other, err := EncodeValue(allowed); err == nil && string(other) == string(data) {
+			return true
+		}
+	}
+	return false
+}
+
+// This function converts an integer or a float to float64.
+func toFloat(value interface{}) (float64, bool) {
+	normalized, err := Normalize(value)
+	if err != nil {
+		return 0, false
+	}
+	switch v := normalized.(type) {
+	case int64:
+		return float64(v), true
+	case float64:
+		return v, true
+	}
+	return 0, false
+}
+
+func formatEnum(enum []interface{}) string {
+	values := make([]string, len(enum))
+	for i, value := range enum {
+		data, _ := EncodeValue(value)
+		values[i] = string(data)
+	}
+	return "[" + strings.Join(values, ", ") + "]"
+}
+
+func compilePattern(pattern string) (*regexp.Regexp, error) {
+	if compiled, ok := patterns.Load(pattern); ok {
+		return compiled.(*regexp.Regexp), nil
+	}
+	compiled, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	patterns.Store(pattern, compiled)
+	return compiled, nil
+}
+
+// This function appends a key to a path, keys holding dots
+// or brackets are quoted so the path can be parsed back.
+func joinKey(path, key string) string {
+	if key == "" || strings.ContainsAny(key, ".[]\"") {
+		return path + "[" + strconv.Quote(key) + "]"
+	}
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,109 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func float(value float64) *float64 { return &value }
+func length(value int) *int        { return &value }
+
+func TestSchema_Validate(t *testing.T) {
+	schema := &Schema{
+		Strict: true,
+		Fields: map[string]*FieldSchema{
+			"name":   {Type: KindString, Required: true, MinLength: length(2), Pattern: "^[A-Z]"},
+			"age":    {Type: KindInt, Min: float(0), Max: float(150)},
+			"role":   {Type: KindString, Enum: []interface{}{"admin", "user"}, Default: "user"},
+			"score":  {Type: KindFloat, Nullable: true},
+			"tags":   {Type: KindArray, MaxLength: length(2), Items: &FieldSchema{Type: KindString}},
+			"extras": {Type: KindAny},
+			"address": {Type: KindDocument, Required: true, Fields: map[string]*FieldSchema{
+				"city": {Type: KindString, Required: true},
+				"zip":  {Type: KindInt, Default: 10100},
+			}},
+		},
+	}
+	if err := schema.Check(); err != nil {
+		t.Fatalf("Check() failed: %v", err)
+	}
+
+	record := NewRecord()
+	record.AddField("name", "Sajith")
+	record.AddField("age", 30)
+	record.AddField("score", nil)
+	record.AddField("tags", []interface{}{"a"})
+	record.AddField("extras", map[string]interface{}{"any": true})
+	record.AddField("address", map[string]interface{}{"city": "Colombo"})
+	schema.ApplyDefaults(record)
+	if err := schema.Validate(record); err != nil {
+		t.Fatalf("Validate() failed: %v", err)
+	}
+	if record.Fields["role"] != "user" || record.Fields["address"].(map[string]interface{})["zip"] != int64(10100) {
+		t.Errorf("ApplyDefaults() failed: Unexpected fields %v", record.Fields)
+	}
+
+	invalid := NewRecord()
+	invalid.AddField("name", "s")
+	invalid.AddField("age", int64(200))
+	invalid.AddField("role", "root")
+	invalid.AddField("tags", []interface{}{"a", 1, "c"})
+	invalid.AddField("address", map[string]interface{}{"zip": "x"})
+	invalid.AddField("unknown", true)
+	err := schema.Validate(invalid)
+	var errs ValidationErrors
+	if !errors.As(err, &errs) {
+		t.Fatalf("Validate() failed: Expected ValidationErrors, got %v", err)
+	}
+	expected := []string{
+		"address.city", "address.zip", "age", "name", "name",
+		"role", "tags", "tags[1]", "unknown",
+	}
+	paths := make([]string, len(errs))
+	for i, err := range errs {
+		paths[i] = err.Path
+	}
+	if !reflect.DeepEqual(paths, expected) {
+		t.Errorf("Validate() failed: Expected violations at %v, got %v", expected, err)
+	}
+}
+
+func TestSchema_Enum(t *testing.T) {
+	schema := &Schema{Fields: map[string]*FieldSchema{
+		"level": {Type: KindInt, Enum: []interface{}{1.0, 2.0}},
+		"score": {Type: KindFloat, Enum: []interface{}{1, 2}},
+	}}
+	record := NewRecord()
+	record.AddField("level", int64(2))
+	record.AddField("score", 1.0)
+	if err := schema.Validate(record); err != nil {
+		t.Errorf("Validate() failed: Expected equal numbers of other kinds to match, got %v", err)
+	}
+	record.AddField("level", int64(3))
+	if err := schema.Validate(record); err == nil {
+		t.Errorf("Validate() failed: Expected error for a value not in the enum")
+	}
+}
+
+func TestSchema_JSON(t *testing.T) {
+	schema := &Schema{Fields: map[string]*FieldSchema{
+		"level": {Type: KindFloat, Enum: []interface{}{1.0, 2.5}, Default: 1.0},
+		"bad":   {Type: KindString, Pattern: "("},
+	}}
+	data, err := json.Marshal(schema)
+	if err != nil {
+		t.Fatalf("Marshal() failed: %v", err)
+	}
+	var decoded Schema
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("Unmarshal() failed: %v", err)
+	}
+	if !reflect.DeepEqual(&decoded, schema) {
+		t.Errorf("Unmarshal() failed: Expected %+v, got %+v", schema.Fields["level"], decoded.Fields["level"])
+	}
+	if err := decoded.Check(); err == nil {
+		t.Errorf("Check() failed: Expected error for an invalid pattern")
+	}
+}
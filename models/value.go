@@ -0,0 +1,504 @@
+package models
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Kind represents the type of a field value.
+//
+// Field values are kept as the following Go types:
+// nil, bool, int64, float64, string, time.Time in UTC, []byte,
+// []interface{} and map[string]interface{}.
+// KindAny is only used by schemas to accept every kind.
+type Kind int
+
+const (
+	KindAny Kind = iota
+	KindNull
+	KindBool
+	KindInt
+	KindFloat
+	KindString
+	KindTime
+	KindBytes
+	KindArray
+	KindDocument
+)
+
+var kindNames = []string{"any", "null", "bool", "int", "float", "string", "time", "bytes", "array", "document"}
+
+// This function returns the name of the kind.
+func (k Kind) String() string {
+	if k < 0 || int(k) >= len(kindNames) {
+		return fmt.Sprintf("Kind(%d)", int(k))
+	}
+	return kindNames[k]
+}
+
+// This function encodes the kind as its name.
+func (k Kind) MarshalText() ([]byte, error) {
+	return []byte(k.String()), nil
+}
+
+// This function decodes the kind from its name.
+func (k *Kind) UnmarshalText(text []byte) error {
+	kind, err := ParseKind(string(text))
+	if err != nil {
+		return err
+	}
+	*k = kind
+	return nil
+}
+
+// This function parses the name of a kind.
+func ParseKind(name string) (Kind, error) {
+	for i, kindName := range kindNames {
+		if kindName == name {
+			return Kind(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown kind '%s'", name)
+}
+
+// This function gets the kind of a normalized value.
+func KindOf(value interface{}) (Kind, error) {
+	switch value.(type) {
+	case nil:
+		return KindNull, nil
+	case bool:
+		return KindBool, nil
+	case int64:
+		return KindInt, nil
+	case float64:
+		return KindFloat, nil
+	case string:
+		return KindString, nil
+	case time.Time:
+		return KindTime, nil
+	case []byte:
+		return KindBytes, nil
+	case []interface{}:
+		return KindArray, nil
+	case map[string]interface{}:
+		return KindDocument, nil
+	}
+	return 0, fmt.Errorf("unsupported type %T", value)
+}
+
+// This function converts a Go value to the type its kind is kept as.
+// Integers become int64, floats become float64, times are converted
+// to UTC as they are stored without their location,
+// slices become []interface{} and maps with string keys become
+// map[string]interface{}. Pointers are followed. Structs and other
+// values implementing json.Marshaler are kept as what their JSON
+// decodes to, as records stored them before fields had kinds.
+func Normalize(value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case nil, bool, int64, float64, string:
+		return v, nil
+	case int:
+		return int64(v), nil
+	case time.Time:
+		return v.UTC().Round(0), nil
+	case []byte:
+		return v, nil
+	case json.Number:
+		return decodeNumber(v)
+	case json.Marshaler:
+		return normalizeJSON(v)
+	}
+
+	rv := reflect.ValueOf(value)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return rv.Int(), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if rv.Uint() > math.MaxInt64 {
+			return nil, fmt.Errorf("value %d overflows int64", rv.Uint())
+		}
+		return int64(rv.Uint()), nil
+	case reflect.Float32, reflect.Float64:
+		return rv.Float(), nil
+	case reflect.Bool:
+		return rv.Bool(), nil
+	case reflect.String:
+		return rv.String(), nil
+	case reflect.Pointer, reflect.Interface:
+		if rv.IsNil() {
+			return nil, nil
+		}
+		return Normalize(rv.Elem().Interface())
+	case reflect.Slice, reflect.Array:
+		if rv.Kind() == reflect.Slice && rv.IsNil() {
+			return nil, nil
+		}
+		if rv.Type().Elem().Kind() == reflect.Uint8 {
+			data := make([]byte, rv.Len())
+			reflect.Copy(reflect.ValueOf(data), rv)
+			return data, nil
+		}
+		array := make([]interface{}, rv.Len())
+		for i := range array {
+			item, err := Normalize(rv.Index(i).Interface())
+			if err != nil {
+				return nil, fmt.Errorf("[%d]: %v", i, err)
+			}
+			array[i] = item
+		}
+		return array, nil
+	case reflect.Map:
+		if rv.Type().Key().Kind() != reflect.String {
+			return nil, fmt.Errorf("unsupported map key type %s", rv.Type().Key())
+		}
+		if rv.IsNil() {
+			return nil, nil
+		}
+		document := make(map[string]interface{}, rv.Len())
+		iter := rv.MapRange()
+		for iter.Next() {
+			item, err := Normalize(iter.Value().Interface())
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", iter.Key().String(), err)
+			}
+			document[iter.Key().String()] = item
+		}
+		return document, nil
+	case reflect.Struct:
+		return normalizeJSON(value)
+	}
+	return nil, fmt.Errorf("unsupported type %T", value)
+}
+
+// This function normalizes the value its JSON decodes to.
+func normalizeJSON(value interface{}) (interface{}, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var decoded interface{}
+	if err := decoder.Decode(&decoded); err != nil {
+		return nil, err
+	}
+	return Normalize(decoded)
+}
+
+// This function normalizes the fields of the record into a new
+// map, the map of the caller is not changed.
+func (r *Record) Normalize() error {
+	if r.Fields == nil {
+		return nil
+	}
+	fields := make(map[string]interface{}, len(r.Fields))
+	for name, value := range r.Fields {
+		normalized, err := Normalize(value)
+		if err != nil {
+			return fmt.Errorf("field '%s': %w", name, err)
+		}
+		fields[name] = normalized
+	}
+	r.Fields = fields
+	return nil
+}
+
+// This function copies a normalized value, documents, arrays
+// and bytes are copied deeply.
+func CopyValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case []byte:
+		return append([]byte(nil), v...)
+	case []interface{}:
+		array := make([]interface{}, len(v))
+		for i, item := range v {
+			array[i] = CopyValue(item)
+		}
+		return array
+	case map[string]interface{}:
+		document := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			document[key] = CopyValue(item)
+		}
+		return document
+	}
+	return value
+}
+
+// This function copies the record and its fields.
+func (r *Record) Copy() *Record {
+	record := *r
+	record.Fields = CopyValue(r.Fields).(map[string]interface{})
+	return &record
+}
+
+// Keys of the objects wrapping the values of kinds JSON lacks.
+var wrapperKeys = map[string]bool{"$time": true, "$bytes": true, "$float": true, "$doc": true}
+
+// This function encodes a value as JSON keeping its kind.
+// Floats always have a fraction or exponent so they are not
+// mistaken for integers, and kinds JSON lacks are wrapped in
+// an object with a single "$" key: {"$time": ...},
+// {"$bytes": ...}, {"$float": "NaN"} and {"$doc": ...} for
+// documents which would be mistaken for one of these wrappers.
+// Times are encoded in UTC.
+func EncodeValue(value interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := encodeValue(&buf, value); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func encodeValue(buf *bytes.Buffer, value interface{}) error {
+	normalized, err := Normalize(value)
+	if err != nil {
+		return err
+	}
+
+	switch v := normalized.(type) {
+	case nil:
+		buf.WriteString("null")
+	case bool:
+		buf.WriteString(strconv.FormatBool(v))
+	case int64:
+		buf.WriteString(strconv.FormatInt(v, 10))
+	case float64:
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			fmt.Fprintf(buf, `{"$float":"%s"}`, strconv.FormatFloat(v, 'g', -1, 64))
+			return nil
+		}
+		text := strconv.FormatFloat(v, 'g', -1, 64)
+		if !strings.ContainsAny(text, ".e") {
+			text += ".0"
+		}
+		buf.WriteString(text)
+	case string:
+		data, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		buf.Write(data)
+	case time.Time:
+		fmt.Fprintf(buf, `{"$time":"%s"}`, v.Format(time.RFC3339Nano))
+	case []byte:
+		fmt.Fprintf(buf, `{"$bytes":"%s"}`, base64.StdEncoding.EncodeToString(v))
+	case []interface{}:
+		buf.WriteByte('[')
+		for i, item := range v {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if err := encodeValue(buf, item); err != nil {
+				return fmt.Errorf("[%d]: %v", i, err)
+			}
+		}
+		buf.WriteByte(']')
+	case map[string]interface{}:
+		wrap := false
+		if len(v) == 1 {
+			for key := range v {
+				wrap = wrapperKeys[key]
+			}
+		}
+		if wrap {
+			buf.WriteString(`{"$doc":`)
+		}
+		if err := encodeDocument(buf, v); err != nil {
+			return err
+		}
+		if wrap {
+			buf.WriteByte('}')
+		}
+	}
+	return nil
+}
+
+// This function encodes the document with its keys in order.
+func encodeDocument(buf *bytes.Buffer, document map[string]interface{}) error {
+	keys := make([]string, 0, len(document))
+	for key := range document {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	buf.WriteByte('{')
+	for i, key := range keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		name, err := json.Marshal(key)
+		if err != nil {
+			return err
+		}
+		buf.Write(name)
+		buf.WriteByte(':')
+		if err := encodeValue(buf, document[key]); err != nil {
+			return fmt.Errorf("%s: %v", key, err)
+		}
+	}
+	buf.WriteByte('}')
+	return nil
+}
+
+// This function decodes a value encoded by EncodeValue.
+// Only the wrapper keys are unwrapped, other objects are kept
+// as documents, as records stored them before fields had kinds.
+func DecodeValue(data []byte) (interface{}, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var raw interface{}
+	if err := decoder.Decode(&raw); err != nil {
+		return nil, err
+	}
+	return decodeValue(raw)
+}
+
+func decodeValue(raw interface{}) (interface{}, error) {
+	switch v := raw.(type) {
+	case json.Number:
+		return decodeNumber(v)
+	case []interface{}:
+		for i, item := range v {
+			decoded, err := decodeValue(item)
+			if err != nil {
+				return nil, fmt.Errorf("[%d]: %v", i, err)
+			}
+			v[i] = decoded
+		}
+		return v, nil
+	case map[string]interface{}:
+		if len(v) == 1 {
+			for key, item := range v {
+				if wrapperKeys[key] {
+					return decodeWrapped(key, item)
+				}
+			}
+		}
+		return decodeDocument(v)
+	}
+	return raw, nil
+}
+
+// This function decodes a value wrapped in a "$" object.
+func decodeWrapped(key string, item interface{}) (interface{}, error) {
+	switch key {
+	case "$time":
+		text, _ := item.(string)
+		return time.Parse(time.RFC3339Nano, text)
+	case "$bytes":
+		text, _ := item.(string)
+		return base64.StdEncoding.DecodeString(text)
+	case "$float":
+		text, _ := item.(string)
+		return strconv.ParseFloat(text, 64)
+	case "$doc":
+		document, ok := item.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("invalid $doc value")
+		}
+		return decodeDocument(document)
+	}
+	return nil, fmt.Errorf("unknown wrapped kind '%s'", key)
+}
+
+func decodeDocument(document map[string]interface{}) (interface{}, error) {
+	for key, item := range document {
+		decoded, err := decodeValue(item)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", key, err)
+		}
+		document[key] = decoded
+	}
+	return document, nil
+}
+
+// This function decodes a number into int64 unless it has a
+// fraction or exponent.
+func decodeNumber(number json.Number) (interface{}, error) {
+	if strings.ContainsAny(number.String(), ".eE") {
+		return number.Float64()
+	}
+	return number.Int64()
+}
+
+// This function encodes the record with its field kinds.
+// Timestamps which were never set are left out.
+func (r Record) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	if err := encodeDocument(&buf, r.Fields); err != nil {
+		return nil, fmt.Errorf("field %v", err)
+	}
+	type record Record
+	aux := struct {
+		record
+		Fields    json.RawMessage `json:"fields"`
+		CreatedAt *time.Time      `json:"createdAt,omitempty"`
+		UpdatedAt *time.Time      `json:"updatedAt,omitempty"`
+		ExpireAt  *time.Time      `json:"expireAt,omitempty"`
+		DeletedAt *time.Time      `json:"deletedAt,omitempty"`
+	}{record: record(r), Fields: buf.Bytes()}
+	if !r.CreatedAt.IsZero() {
+		aux.CreatedAt = &r.CreatedAt
+	}
+	if !r.UpdatedAt.IsZero() {
+		aux.UpdatedAt = &r.UpdatedAt
+	}
+	if !r.ExpireAt.IsZero() {
+		aux.ExpireAt = &r.ExpireAt
+	}
+	if !r.DeletedAt.IsZero() {
+		aux.DeletedAt = &r.DeletedAt
+	}
+	return json.Marshal(aux)
+}
+
+// This function decodes the record and the kinds of its fields.
+func (r *Record) UnmarshalJSON(data []byte) error {
+	type record Record
+	aux := struct {
+		*record
+		Fields    json.RawMessage `json:"fields"`
+		CreatedAt *time.Time      `json:"createdAt"`
+		UpdatedAt *time.Time      `json:"updatedAt"`
+		ExpireAt  *time.Time      `json:"expireAt"`
+		DeletedAt *time.Time      `json:"deletedAt"`
+	}{record: (*record)(r)}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+	if aux.CreatedAt != nil {
+		r.CreatedAt = aux.CreatedAt.UTC()
+	}
+	if aux.UpdatedAt != nil {
+		r.UpdatedAt = aux.UpdatedAt.UTC()
+	}
+	if aux.ExpireAt != nil {
+		r.ExpireAt = aux.ExpireAt.UTC()
+	}
+	if aux.DeletedAt != nil {
+		r.DeletedAt = aux.DeletedAt.UTC()
+	}
+
+	r.Fields = make(map[string]interface{})
+	if len(aux.Fields) == 0 || string(aux.Fields) == "null" {
+		return nil
+	}
+	decoder := json.NewDecoder(bytes.NewReader(aux.Fields))
+	decoder.UseNumber()
+	if err := decoder.Decode(&r.Fields); err != nil {
+		return err
+	}
+	if _, err := decodeDocument(r.Fields); err != nil {
+		return fmt.Errorf("field %v", err)
+	}
+	return nil
+}
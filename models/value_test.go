@@ -0,0 +1,110 @@
+package models
+
+import (
+	"encoding/json"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRecord_MarshalJSON(t *testing.T) {
+	created := time.Date(2024, 3, 1, 12, 30, 0, 500, time.FixedZone("", 3600))
+	record := NewRecord()
+	record.AddField("age", 30)
+	record.AddField("score", 3.75)
+	record.AddField("whole", 2.0)
+	record.AddField("big", int64(math.MaxInt64))
+	record.AddField("created", created)
+	record.AddField("avatar", []byte{0, 1, 2})
+	record.AddField("missing", nil)
+	record.AddField("address", map[string]interface{}{"city": "Colombo", "zip": 10100})
+	record.AddField("query", map[string]interface{}{"$gt": 5})
+	record.AddField("tags", []string{"a", "b"})
+	if err := record.Normalize(); err != nil {
+		t.Fatalf("Normalize() failed: %v", err)
+	}
+
+	data, err := json.Marshal(record)
+	if err != nil {
+		t.Fatalf("MarshalJSON() failed: %v", err)
+	}
+	var decoded Record
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("UnmarshalJSON() failed: %v", err)
+	}
+
+	expected := map[string]interface{}{
+		"age":     int64(30),
+		"score":   3.75,
+		"whole":   2.0,
+		"big":     int64(math.MaxInt64),
+		"created": created.UTC(),
+		"avatar":  []byte{0, 1, 2},
+		"missing": nil,
+		"address": map[string]interface{}{"city": "Colombo", "zip": int64(10100)},
+		"query":   map[string]interface{}{"$gt": int64(5)},
+		"tags":    []interface{}{"a", "b"},
+	}
+	for name, value := range expected {
+		if !reflect.DeepEqual(decoded.Fields[name], value) {
+			t.Errorf("UnmarshalJSON() failed: Expected %s to be %#v, got %#v", name, value, decoded.Fields[name])
+		}
+	}
+}
+
+func TestEncodeValue(t *testing.T) {
+	for _, value := range []interface{}{math.NaN(), math.Inf(1), math.Inf(-1)} {
+		data, err := EncodeValue(value)
+		if err != nil {
+			t.Fatalf("EncodeValue() failed: %v", err)
+		}
+		decoded, err := DecodeValue(data)
+		if err != nil {
+			t.Fatalf("DecodeValue() failed: %v", err)
+		}
+		float, ok := decoded.(float64)
+		if !ok || math.IsNaN(float) != math.IsNaN(value.(float64)) || (!math.IsNaN(float) && float != value) {
+			t.Errorf("DecodeValue() failed: Expected %v, got %#v", value, decoded)
+		}
+	}
+
+	wrapper := map[string]interface{}{"$time": "soon"}
+	data, err := EncodeValue(wrapper)
+	if err != nil {
+		t.Fatalf("EncodeValue() failed: %v", err)
+	}
+	if decoded, err := DecodeValue(data); err != nil || !reflect.DeepEqual(decoded, wrapper) {
+		t.Errorf("DecodeValue() failed: Expected %v, got %#v: %v", wrapper, decoded, err)
+	}
+	legacy := map[string]interface{}{"$where": "age > 5"}
+	if decoded, err := DecodeValue([]byte(`{"$where":"age > 5"}`)); err != nil || !reflect.DeepEqual(decoded, legacy) {
+		t.Errorf("DecodeValue() failed: Expected %v, got %#v: %v", legacy, decoded, err)
+	}
+
+	if _, err := EncodeValue(map[int]string{1: "a"}); err == nil {
+		t.Errorf("EncodeValue() failed: Expected error for map with int keys")
+	}
+}
+
+func TestRecord_Normalize(t *testing.T) {
+	type address struct {
+		City string `json:"city"`
+		Zip  int    `json:"zip,omitempty"`
+	}
+	fields := map[string]interface{}{"age": 30, "address": address{City: "Colombo"}}
+	record := &Record{Fields: fields}
+	if err := record.Normalize(); err != nil {
+		t.Fatalf("Normalize() failed: %v", err)
+	}
+	expected := map[string]interface{}{"age": int64(30), "address": map[string]interface{}{"city": "Colombo"}}
+	if !reflect.DeepEqual(record.Fields, expected) {
+		t.Errorf("Normalize() failed: Expected %#v, got %#v", expected, record.Fields)
+	}
+	if _, ok := fields["age"].(int); !ok {
+		t.Errorf("Normalize() failed: Changed the map of the caller")
+	}
+	if _, err := Normalize(make(chan int)); err == nil {
+		t.Errorf("Normalize() failed: Expected error for a channel")
+	}
+}
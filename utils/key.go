@@ -0,0 +1,74 @@
+package utils
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"time"
+)
+
+// Alphabet of the Crockford base32 encoding used by ULIDs.
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// This function generates a random version 4 UUID in its
+// canonical form such as "0b9f3c1e-8d2a-4c6e-9f1a-2b3c4d5e6f70".
+func NewUUID() (string, error) {
+	var uuid [16]byte
+	if _, err := rand.Read(uuid[:]); err != nil {
+		return "", fmt.Errorf("error generating uuid: %v", err)
+	}
+	uuid[6] = uuid[6]&0x0f | 0x40
+	uuid[8] = uuid[8]&0x3f | 0x80
+
+	buf := make([]byte, 36)
+	hex.Encode(buf[0:8], uuid[0:4])
+	buf[8] = '-'
+	hex.Encode(buf[9:13], uuid[4:6])
+	buf[13] = '-'
+	hex.Encode(buf[14:18], uuid[6:8])
+	buf[18] = '-'
+	hex.Encode(buf[19:23], uuid[8:10])
+	buf[23] = '-'
+	hex.Encode(buf[24:], uuid[10:])
+	return string(buf), nil
+}
+
+// This function encodes a ULID from the time in milliseconds and
+// 80 bits of entropy. ULIDs sort by their time as strings.
+func ULID(t time.Time, entropy [10]byte) string {
+	var id [16]byte
+	ms := uint64(t.UnixMilli())
+	for i := 5; i >= 0; i-- {
+		id[i] = byte(ms)
+		ms >>= 8
+	}
+	copy(id[6:], entropy[:])
+
+	// 128 bits are encoded into 26 characters of 5 bits, the
+	// first character holds the 3 highest bits.
+	out := make([]byte, 26)
+	var bits uint
+	var acc uint32
+	pos := 25
+	for i := 15; i >= 0; i-- {
+		acc |= uint32(id[i]) << bits
+		bits += 8
+		for bits >= 5 && pos >= 0 {
+			out[pos] = crockford[acc&0x1f]
+			acc >>= 5
+			bits -= 5
+			pos--
+		}
+	}
+	out[0] = crockford[acc&0x1f]
+	return string(out)
+}
+
+// This function generates the random entropy of a ULID.
+func NewULIDEntropy() ([10]byte, error) {
+	var entropy [10]byte
+	if _, err := rand.Read(entropy[:]); err != nil {
+		return entropy, fmt.Errorf("error generating ulid: %v", err)
+	}
+	return entropy, nil
+}